@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
+)
+
+// applyAnswers walks the wizard forward for as long as the current step has
+// an answer, feeding each one through handleEnter so it gets exactly the
+// validation a typed value would. It stops at the first step without an
+// answer, or one whose answer was rejected, and leaves the user there.
+func (m model) applyAnswers() (model, tea.Cmd) {
+	var cmds []tea.Cmd
+	for m.answers != nil {
+		a := m.answers
+		before := m.state
+
+		switch m.state {
+		case stateUsername:
+			if a.Username == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Username)
+
+		case stateFullname:
+			if a.Fullname == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Fullname)
+
+		case stateEmail:
+			if a.Email == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Email)
+
+		case statePassword:
+			if a.PasswordHash != "" {
+				// No plain password to confirm: go straight to the hostname
+				m.config.PasswordHash = a.PasswordHash
+				m.state = stateHostname
+				m.input.SetValue("")
+				m.input.EchoMode = textinput.EchoNormal
+				m.input.EchoCharacter = 0
+				m.input.Placeholder = "e.g., laptop, desktop, server"
+				continue
+			}
+			if a.Password == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Password)
+
+		case statePasswordConfirm:
+			if a.Password == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Password)
+
+		case stateHostname:
+			if a.Hostname == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Hostname)
+
+		case stateStorageMode:
+			if a.StorageMode == "" {
+				return m, tea.Batch(cmds...)
+			}
+			mode, _ := config.ParseStorageMode(a.StorageMode)
+			m.selectedIdx = storageModeIndex(mode)
+
+		case stateDisk:
+			if len(a.Disks) == 0 {
+				return m, tea.Batch(cmds...)
+			}
+			idx := diskIndex(m.disks, a.Disks[0])
+			if idx < 0 {
+				m.err = missingDisk(a.Disks[0], m.hiddenDisks)
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = idx
+			m.acceptChecks = true // Warnings are logged; blocking problems still stop
+
+		case stateFreeSpace:
+			// Answer files that name a disk use all of it unless they ask
+			// for free space
+			if len(a.Disks) == 0 {
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = 0
+			if a.FreeSpace {
+				m.selectedIdx = largestGapChoice(m.layout)
+			}
+
+		case stateDiskMulti:
+			if len(a.Disks) == 0 {
+				return m, tea.Batch(cmds...)
+			}
+			for _, disk := range a.Disks {
+				idx := diskIndex(m.disks, disk)
+				if idx < 0 {
+					m.err = missingDisk(disk, m.hiddenDisks)
+					return m, tea.Batch(cmds...)
+				}
+				m.diskSelected[idx] = true
+			}
+			m.acceptChecks = true
+
+		case stateBootDisks:
+			// Answer files from before this step keep the ESP on the
+			// first disk only
+			if len(a.Disks) == 0 && a.RedundantESP == nil {
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = 1
+			if a.RedundantESP != nil && *a.RedundantESP {
+				m.selectedIdx = 0
+			}
+
+		case stateSizes:
+			// The disks decide the sizes; space_* answers were applied
+			// on the way in
+			if len(a.Disks) == 0 {
+				return m, tea.Batch(cmds...)
+			}
+
+		case stateDatasets:
+			// The layout was applied on the way in; Enter on the empty
+			// new line accepts it
+			if len(a.Disks) == 0 && a.Datasets == nil {
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = len(m.datasetRows()) - 1
+			m.input.SetValue("")
+
+		case statePassphrase, statePassphraseConfirm:
+			if a.Passphrase == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.Passphrase)
+
+		case stateLocale:
+			idx := indexOf(m.locales, a.Locale)
+			if idx < 0 {
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = idx
+
+		case stateKeymap:
+			idx := keymapIndex(m.keymaps, a.Keymap)
+			if idx < 0 {
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = idx
+
+		case stateSSH:
+			if a.EnableSSH == nil {
+				return m, tea.Batch(cmds...)
+			}
+			m.selectedIdx = 1
+			if *a.EnableSSH {
+				m.selectedIdx = 0
+			}
+
+		case stateGitHubUser:
+			if a.GitHubUser == "" {
+				return m, tea.Batch(cmds...)
+			}
+			m.input.SetValue(a.GitHubUser)
+
+		case stateSummary:
+			if !a.ConfirmDestroy {
+				return m, tea.Batch(cmds...)
+			}
+
+		case stateConfirm:
+			if !a.ConfirmDestroy {
+				return m, tea.Batch(cmds...)
+			}
+			// confirm_destroy stands for the confirm word and every
+			// serial number
+			steps := confirmSteps(m.config, m.destroyPreview)
+			m.confirmStep = len(steps) - 1
+			m.input.SetValue(steps[m.confirmStep].Word)
+
+		default:
+			return m, tea.Batch(cmds...)
+		}
+
+		next, cmd := m.handleEnter()
+		m = next.(model)
+		cmds = append(cmds, cmd)
+		if m.state == before {
+			// The answer was rejected; m.err explains why
+			break
+		}
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// answersFromConfig turns a reviewed configuration into an answer file that
+// reproduces it, hashing the password if it was typed in
+func answersFromConfig(c config.Config) (*config.Answers, error) {
+	hash := c.PasswordHash
+	if hash == "" {
+		var err error
+		hash, err = pipeline.HashPassword(context.Background(), shell(), c.Password)
+		if err != nil {
+			return nil, fmt.Errorf("hash password: %w", err)
+		}
+	}
+	return config.AnswersFromConfig(c, hash), nil
+}
+
+// defaultExportPath suggests where to save an exported answer file,
+// preferring a mounted USB stick over the live system's /tmp
+func defaultExportPath(hostname string) string {
+	name := fmt.Sprintf("tuinix-answers-%s.json", hostname)
+	if mounts := disk.RemovableMounts(context.Background(), shell()); len(mounts) > 0 {
+		return filepath.Join(mounts[0], name)
+	}
+	return filepath.Join("/tmp", name)
+}
+
+// missingDisk explains why a disk named in the answer file is not in the
+// disk list
+func missingDisk(path string, hidden []disk.Info) error {
+	if idx := diskIndex(hidden, path); idx >= 0 {
+		return fmt.Errorf("disk %s from the answer file is not offered: %s (use --all-disks to allow it)", path, hidden[idx].Hidden())
+	}
+	return fmt.Errorf("disk %s from the answer file was not found", path)
+}
+
+func indexOf(list []string, val string) int {
+	for i, v := range list {
+		if v == val {
+			return i
+		}
+	}
+	return -1
+}
+
+func storageModeIndex(mode config.StorageMode) int {
+	for i, sm := range config.StorageModes {
+		if sm == mode {
+			return i
+		}
+	}
+	return -1
+}
+
+func keymapIndex(keymaps []config.Keymap, label string) int {
+	for i, km := range keymaps {
+		if km.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+// diskIndex finds the disk called path, by kernel name or by any of its
+// /dev/disk links
+func diskIndex(disks []disk.Info, path string) int {
+	for i, d := range disks {
+		if d.Path == path || indexOf(d.Links, path) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// diskLinks maps each of the chosen paths to the stable name of the disk
+func diskLinks(disks []disk.Info, paths []string) map[string]string {
+	links := map[string]string{}
+	for _, path := range paths {
+		if idx := diskIndex(disks, path); idx >= 0 {
+			links[path] = disks[idx].StablePath()
+		}
+	}
+	return links
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
-	"regexp"
 	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
+	"github.com/timlinux/tuinix/cmd/installer/system"
 )
 
 func tick() tea.Cmd {
@@ -21,21 +24,26 @@ func tick() tea.Cmd {
 
 func checkNetwork() tea.Cmd {
 	return func() tea.Msg {
-		conn, err := net.DialTimeout("tcp", "github.com:443", 5*time.Second)
-		if err != nil {
-			return networkCheckMsg{ok: false}
-		}
-		conn.Close()
-		return networkCheckMsg{ok: true}
+		return networkCheckMsg{ok: networkAvailable()}
+	}
+}
+
+// networkAvailable reports whether GitHub can be reached
+func networkAvailable() bool {
+	conn, err := net.DialTimeout("tcp", "github.com:443", 5*time.Second)
+	if err != nil {
+		return false
 	}
+	conn.Close()
+	return true
 }
 
-func pollLogTail(isZFS bool) tea.Cmd {
+func pollLogTail() tea.Cmd {
 	return func() tea.Msg {
 		time.Sleep(time.Second)
 		data, err := os.ReadFile(logFile)
 		if err != nil {
-			return logTailMsg{lines: nil, currentStep: -1}
+			return logTailMsg{lines: nil}
 		}
 		content := strings.TrimSpace(string(data))
 		lines := strings.Split(content, "\n")
@@ -43,43 +51,126 @@ func pollLogTail(isZFS bool) tea.Cmd {
 		if start < 0 {
 			start = 0
 		}
+		return logTailMsg{lines: lines[start:]}
+	}
+}
+
+// subscribeInstallEvents forwards installation events to the TUI
+func subscribeInstallEvents() chan pipeline.Event {
+	ch := make(chan pipeline.Event, 64)
+	installEvents.subscribe(func(ev pipeline.Event) {
+		ch <- ev
+	})
+	return ch
+}
+
+func waitForInstallEvent(ch chan pipeline.Event) tea.Cmd {
+	return func() tea.Msg {
+		return installEventMsg(<-ch)
+	}
+}
+
+func runInstallation(ctx context.Context, c config.Config) tea.Cmd {
+	return func() tea.Msg {
+		if err := newInstaller().Run(ctx, c); err != nil {
+			return installErrMsg{err: err}
+		}
+		return installDoneMsg{}
+	}
+}
 
-		// Parse log to determine current step based on "Step X complete" messages
-		// The log writes "Step X complete" after each step finishes
-		currentStep := 0
-		stepCompleteRe := regexp.MustCompile(`Step (\d+) complete`)
-		for _, line := range lines {
-			if matches := stepCompleteRe.FindStringSubmatch(line); len(matches) > 1 {
-				var step int
-				fmt.Sscanf(matches[1], "%d", &step)
-				if step > currentStep {
-					currentStep = step
-				}
-			}
+func runResume(ctx context.Context, p *pipeline.Progress, passphrase string) tea.Cmd {
+	return func() tea.Msg {
+		if err := newInstaller().Resume(ctx, p, passphrase); err != nil {
+			return installErrMsg{err: err}
 		}
+		return installDoneMsg{}
+	}
+}
+
+// progressFile records how far an installation got, so a failed install
+// can be resumed by running the installer again (see pipeline.Progress)
+const progressFile = "/tmp/tuinix-install-progress.json"
 
-		return logTailMsg{lines: lines[start:], currentStep: currentStep}
+// resumableInstall returns the saved progress of a failed installation
+// that can be resumed, or nil
+func resumableInstall() *pipeline.Progress {
+	p, err := pipeline.LoadProgress(progressFile)
+	if err != nil {
+		logError("Ignoring saved progress: %v", err)
+		return nil
+	}
+	if !p.Resumable() {
+		return nil
 	}
+	return p
+}
+
+// dryRun is set when the install only generates files and prints the
+// commands it would have run (see runDryRun)
+var dryRun bool
+
+// runner executes every system command. --record and --replay swap it.
+var runner system.Runner = system.ExecRunner{}
+
+// shell issues the installer's own commands outside an installation:
+// disk discovery, mounting answer media, hashing an exported password
+func shell() system.Shell {
+	return system.Shell{Runner: runner}
 }
 
-func runCommand(name string, args ...string) (string, error) {
-	cmdStr := name + " " + strings.Join(args, " ")
-	logInfo("Running command: %s", cmdStr)
+// newInstaller returns the install engine set up from the command line.
+// Its progress goes to installEvents, which feeds the TUI, plain mode and
+// the --events stream.
+func newInstaller() *pipeline.Installer {
+	in := &pipeline.Installer{
+		Runner:   runner,
+		Progress: installEvents.emit,
+		DryRun:   dryRun,
+		LogFile:  logFile,
+	}
+	if !dryRun {
+		in.StateFile = progressFile
+	}
+	return in
+}
 
-	cmd := exec.Command(name, args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	output := stdout.String() + stderr.String()
+// allDisks offers disks with mounted filesystems and the live ISO's own
+// disk for install too, instead of hiding them
+var allDisks bool
 
+// getAvailableDisks returns the disks that can be installed to and the
+// ones left out of the list, which allDisks brings back
+func getAvailableDisks() (available, hidden []disk.Info, err error) {
+	disks, err := disk.List(context.Background(), shell())
 	if err != nil {
-		logError("Command failed: %s\nError: %v\nOutput: %s", cmdStr, err, output)
-	} else {
-		logInfo("Command succeeded: %s", cmdStr)
-		if output != "" {
-			logInfo("Output: %s", output)
+		return nil, nil, fmt.Errorf("find disks: %w", err)
+	}
+	for _, d := range disks {
+		logInfo("Disk %s: %s %s %s, serial %q, %s", d.Path, d.Size, d.Transport, d.Model, d.Serial, d.ByID)
+		if reason := d.Hidden(); reason != "" && !allDisks {
+			logInfo("Hiding disk %s: %s", d.Path, reason)
+			hidden = append(hidden, d)
+			continue
 		}
+		available = append(available, d)
 	}
-	return output, err
+	return available, hidden, nil
+}
+
+// dryRunDiskGB is the size assumed for a disk a dry run cannot measure,
+// such as one named by an answer file written for another machine
+const dryRunDiskGB = 100
+
+// calculateSpaceAllocation sizes c's partitions and datasets from its
+// disks. A disk that cannot be measured is an error, except in dry runs.
+func calculateSpaceAllocation(c *config.Config) error {
+	return config.AllocateSpace(c, func(path string) (int64, error) {
+		size, err := disk.SizeGB(context.Background(), shell(), path)
+		if err != nil && dryRun {
+			logInfo("Dry run: %v; assuming %d GiB", err, dryRunDiskGB)
+			return dryRunDiskGB, nil
+		}
+		return size, err
+	})
 }
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Answers is the JSON answer file used for unattended installs. Every field
+// is optional: anything left out is asked for interactively.
+type Answers struct {
+	Username       string    `json:"username,omitempty"`
+	Fullname       string    `json:"fullname,omitempty"`
+	Email          string    `json:"email,omitempty"`
+	Password       string    `json:"password,omitempty"`
+	PasswordHash   string    `json:"password_hash,omitempty"`
+	Hostname       string    `json:"hostname,omitempty"`
+	StorageMode    string    `json:"storage_mode,omitempty"`
+	Disks          []string  `json:"disks,omitempty"`
+	Passphrase     string    `json:"passphrase,omitempty"`
+	Locale         string    `json:"locale,omitempty"`
+	Keymap         string    `json:"keymap,omitempty"`
+	EnableSSH      *bool     `json:"enable_ssh,omitempty"`
+	GitHubUser     string    `json:"github_user,omitempty"`
+	ZFSPoolName    string    `json:"zfs_pool_name,omitempty"`
+	SpaceBoot      string    `json:"space_boot,omitempty"`
+	SpaceNix       string    `json:"space_nix,omitempty"`
+	SpaceAtuin     string    `json:"space_atuin,omitempty"`
+	SpaceHome      string    `json:"space_home,omitempty"`
+	Datasets       []Dataset `json:"datasets,omitempty"`
+	RedundantESP   *bool     `json:"redundant_esp,omitempty"`
+	FreeSpace      bool      `json:"free_space,omitempty"`
+	ConfirmDestroy bool      `json:"confirm_destroy,omitempty"`
+}
+
+// LoadAnswers reads and validates an answer file
+func LoadAnswers(path string) (*Answers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read answer file: %w", err)
+	}
+	var a Answers
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("parse answer file %s: %w", path, err)
+	}
+	if err := a.Validate(); err != nil {
+		return nil, fmt.Errorf("answer file %s: %w", path, err)
+	}
+	return &a, nil
+}
+
+// Validate checks every answer that is present against the same rules the
+// wizard applies. Missing answers are not an error. Disks are matched
+// against the machine later, when the disk step is reached.
+func (a *Answers) Validate() error {
+	var errs []error
+	check := func(present bool, err error) {
+		if present && err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(a.Username != "", ValidateUsername(a.Username))
+	check(a.Fullname != "", ValidateFullname(a.Fullname))
+	check(a.Email != "", ValidateEmail(a.Email))
+	check(a.Password != "", ValidatePassword(a.Password))
+	check(a.Hostname != "", ValidateHostname(a.Hostname))
+	check(a.Passphrase != "", ValidatePassphrase(a.Passphrase))
+
+	if a.Password != "" && a.PasswordHash != "" {
+		errs = append(errs, fmt.Errorf("set either password or password_hash, not both"))
+	}
+	if a.PasswordHash != "" && a.PasswordHash[0] != '$' {
+		errs = append(errs, fmt.Errorf("password_hash must be a crypt(3) hash such as the output of mkpasswd -m sha-512"))
+	}
+
+	if a.StorageMode != "" {
+		mode, err := ParseStorageMode(a.StorageMode)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			if len(a.Disks) > 0 {
+				check(true, ValidateDiskSelection(mode, a.Disks))
+			}
+			if a.FreeSpace && mode.IsMultiDisk() {
+				errs = append(errs, fmt.Errorf("free_space needs a single-disk storage mode, not %s", a.StorageMode))
+			}
+			if len(a.Datasets) > 0 && !mode.IsZFS() {
+				errs = append(errs, fmt.Errorf("datasets need a ZFS storage mode, not %s", a.StorageMode))
+			}
+			if a.RedundantESP != nil && *a.RedundantESP && !mode.HasRedundancy() {
+				errs = append(errs, fmt.Errorf("redundant_esp needs a mirror or raidz storage mode, not %s", a.StorageMode))
+			}
+		}
+	}
+	if a.Locale != "" && !knownLocale(a.Locale) {
+		errs = append(errs, fmt.Errorf("unsupported locale %q", a.Locale))
+	}
+	if _, ok := KeymapByLabel(a.Keymap); a.Keymap != "" && !ok {
+		errs = append(errs, fmt.Errorf("unsupported keymap %q", a.Keymap))
+	}
+	check(len(a.Datasets) > 0, ValidateDatasets(a.Datasets))
+	if a.ZFSPoolName != "" && !poolNameRe.MatchString(a.ZFSPoolName) {
+		errs = append(errs, fmt.Errorf("invalid zfs_pool_name %q", a.ZFSPoolName))
+	}
+	// Sizes are checked against the disks when the size step is reached
+	for name, val := range map[string]string{"space_boot": a.SpaceBoot, "space_nix": a.SpaceNix, "space_atuin": a.SpaceAtuin, "space_home": a.SpaceHome} {
+		if name == "space_home" && val == "none" {
+			continue
+		}
+		if _, err := ParseSize(val, 100); val != "" && err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyDefaults copies answers that have no wizard step of their own
+func (a *Answers) ApplyDefaults(c *Config) {
+	if a == nil {
+		return
+	}
+	if a.ZFSPoolName != "" {
+		c.ZFSPoolName = a.ZFSPoolName
+	}
+}
+
+// ApplySizes overrides the computed space allocation with any sizes given
+// in the answer file. It runs after AllocateSpace; percentages are left
+// for ResolveSizes.
+func (a *Answers) ApplySizes(c *Config) {
+	if a == nil {
+		return
+	}
+	for _, f := range c.SizeFields() {
+		if val := a.size(f); val != "" {
+			c.SetSize(f, val)
+		}
+	}
+}
+
+// ApplyDatasets copies the answer file's dataset layout into c for the
+// dataset step. Only ZFS pools have datasets.
+func (a *Answers) ApplyDatasets(c *Config) {
+	if a == nil || a.Datasets == nil || !c.StorageMode.IsZFS() {
+		return
+	}
+	c.Datasets = append([]Dataset(nil), a.Datasets...)
+}
+
+// size returns the answer for f
+func (a *Answers) size(f SizeField) string {
+	switch f {
+	case SizeBoot:
+		return a.SpaceBoot
+	case SizeNix:
+		return a.SpaceNix
+	case SizeAtuin:
+		return a.SpaceAtuin
+	case SizeHome:
+		return a.SpaceHome
+	}
+	return ""
+}
+
+// AnswersFromConfig turns a reviewed configuration into an answer file that
+// reproduces it. The password is stored only as passwordHash and the
+// encryption passphrase is left out, so that is asked for again on replay.
+func AnswersFromConfig(c Config, passwordHash string) *Answers {
+	keymap := ""
+	for _, km := range Keymaps {
+		if km.XKBLayout == c.Keymap && km.ConsoleMap == c.ConsoleKeyMap {
+			keymap = km.Label
+		}
+	}
+
+	enableSSH := c.EnableSSH
+	a := &Answers{
+		Username:     c.Username,
+		Fullname:     c.Fullname,
+		Email:        c.Email,
+		PasswordHash: passwordHash,
+		Hostname:     c.Hostname,
+		StorageMode:  c.StorageMode.Key(),
+		Disks:        c.Disks,
+		Locale:       c.Locale,
+		Keymap:       keymap,
+		EnableSSH:    &enableSSH,
+		ZFSPoolName:  c.ZFSPoolName,
+		SpaceBoot:    c.SpaceBoot,
+		FreeSpace:    c.Alongside != nil,
+	}
+	if c.EnableSSH {
+		a.GitHubUser = c.GitHubUser
+	}
+	if c.StorageMode.HasNixVolume() {
+		a.SpaceNix = c.SpaceNix
+		a.SpaceHome = c.SpaceHome
+	}
+	if c.StorageMode.IsZFS() {
+		a.SpaceAtuin = c.SpaceAtuin
+		a.Datasets = c.Datasets
+	}
+	if c.StorageMode.HasRedundancy() {
+		redundantESP := c.RedundantESP
+		a.RedundantESP = &redundantESP
+	}
+	return a
+}
+
+// WriteAnswers saves an answer file readable only by root, since it
+// carries the password hash
+func WriteAnswers(path string, a *Answers) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode answer file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write answer file: %w", err)
+	}
+	return nil
+}
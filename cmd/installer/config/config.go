@@ -0,0 +1,190 @@
+// Package config describes a tuinix installation: who the user is, which
+// disks to use and how to lay them out. It validates configurations and
+// answer files with the same rules the interactive installer applies.
+package config
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config holds all installation configuration
+type Config struct {
+	Username      string
+	Fullname      string
+	Email         string
+	Password      string
+	PasswordHash  string // Pre-hashed password from an answer file; skips mkpasswd
+	Hostname      string
+	Disk          string            // Primary disk (single-disk modes, or boot disk for multi-disk)
+	Disks         []string          // All selected disks (multi-disk modes)
+	DiskLinks     map[string]string // Stable /dev/disk/by-id (or by-path) name of each disk, for disks.nix
+	HostID        string
+	Passphrase    string
+	StorageMode   StorageMode
+	Locale        string
+	Keymap        string
+	ConsoleKeyMap string
+	EnableSSH     bool
+	GitHubUser    string
+	SSHKeys       []string
+	SpaceBoot     string
+	SpaceNix      string
+	SpaceHome     string // Quota of /home; empty for none, leaving it the rest of the pool
+	SpaceAtuin    string
+	SpaceTotalGB  int64 // Space the sizes above are shared out of, measured by AllocateSpace
+	ZFSPoolName   string
+	Datasets      []Dataset  // Changes to the ZFS dataset layout; see ZFSDatasets
+	RedundantESP  bool       // An ESP on every disk of a redundant pool, not only the first; see ESPDisks
+	Alongside     *Alongside // Install into free space on Disk instead of erasing it (nil: whole disk)
+	ProjectRoot   string     // Checkout of the tuinix flake the install is built from
+	WorkDir       string     // Scratch copy of the flake where host files are generated
+}
+
+// DiskLink is the name disks.nix uses for disk: its stable link, or the
+// kernel name if it has none
+func (c Config) DiskLink(disk string) string {
+	if link := c.DiskLinks[disk]; link != "" {
+		return link
+	}
+	return disk
+}
+
+// ESPDisks lists the disks that get an EFI system partition: every disk of
+// the pool with RedundantESP, otherwise only the first. The first disk's
+// ESP is /boot; GRUB mirrors it to the others.
+func (c Config) ESPDisks() []string {
+	if c.RedundantESP && c.StorageMode.HasRedundancy() {
+		return c.Disks
+	}
+	return c.Disks[:min(len(c.Disks), 1)]
+}
+
+// Alongside places an install in a gap on its disk, next to another
+// operating system. The installer creates one partition in the gap for the
+// root filesystem or ZFS pool and mounts the disk's existing EFI system
+// partition as /boot; every other partition is left alone.
+type Alongside struct {
+	Start      int64    // First sector of the new partition
+	Sectors    int64    // Its length
+	SectorSize int64    // Bytes per sector
+	PartUUID   string   // GPT partition GUID the new partition is created with
+	ESP        string   // Existing EFI system partition, e.g. /dev/nvme0n1p1
+	ESPUUID    string   // Its filesystem UUID, which hardware.nix mounts /boot by
+	ESPBytes   int64    // Its size
+	Keep       []string // The disk's other partitions, described for the confirm screen
+}
+
+// SizeGB is the size of the new partition in GiB
+func (a *Alongside) SizeGB() int64 {
+	return a.Sectors * a.SectorSize >> 30
+}
+
+// Device is the path the new partition appears at once it is created
+func (a *Alongside) Device() string {
+	return "/dev/disk/by-partuuid/" + a.PartUUID
+}
+
+const (
+	// MinAlongsideGB is the smallest gap tuinix installs into: room for
+	// the Nix store's minimum quota plus a usable root and home
+	MinAlongsideGB = 32
+
+	// MinESPBytes is the smallest shared ESP that holds a few generations
+	// of kernels and initrds next to the other system's boot loader
+	MinESPBytes = 256 << 20
+)
+
+// NewPartUUID returns a random GPT partition GUID
+func NewPartUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Validate checks that c is complete enough to install from. The wizard
+// guarantees this step by step; programs that build a Config themselves
+// should call it before starting an install.
+func (c Config) Validate() error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	add(ValidateUsername(c.Username))
+	add(ValidateFullname(c.Fullname))
+	add(ValidateEmail(c.Email))
+	if c.PasswordHash == "" {
+		add(ValidatePassword(c.Password))
+	}
+	add(ValidateHostname(c.Hostname))
+	add(ValidateDiskSelection(c.StorageMode, c.Disks))
+	if len(c.Disks) > 0 && c.Disk != c.Disks[0] {
+		add(fmt.Errorf("disk %s must be the first of the selected disks", c.Disk))
+	}
+	if c.StorageMode.IsEncrypted() {
+		add(ValidatePassphrase(c.Passphrase))
+	}
+	if a := c.Alongside; a != nil {
+		if c.StorageMode.IsMultiDisk() {
+			add(fmt.Errorf("%s takes over whole disks and cannot install into free space", c.StorageMode))
+		}
+		if a.ESP == "" || a.ESPUUID == "" {
+			add(fmt.Errorf("installing into free space needs an existing EFI system partition on %s", c.Disk))
+		} else if a.ESPBytes < MinESPBytes {
+			add(fmt.Errorf("the EFI system partition %s is %d MiB; at least %d MiB is needed for tuinix's kernels", a.ESP, a.ESPBytes>>20, MinESPBytes>>20))
+		}
+		if a.PartUUID == "" {
+			add(fmt.Errorf("no partition GUID chosen for the new partition"))
+		}
+		if a.SizeGB() < MinAlongsideGB {
+			add(fmt.Errorf("the free space on %s is %d GiB; at least %d GiB is needed", c.Disk, a.SizeGB(), MinAlongsideGB))
+		}
+	}
+	if c.RedundantESP && !c.StorageMode.HasRedundancy() {
+		add(fmt.Errorf("%s does not survive losing a disk, so an ESP on every disk would not keep it bootable", c.StorageMode))
+	}
+	add(c.ValidateSizes())
+	for _, f := range c.SizeFields() {
+		if val := c.Size(f); val != "" && !sizeValueRe.MatchString(val) {
+			add(fmt.Errorf("%s size %q must be resolved to M, G or T with ResolveSizes", f.Label(), val))
+		}
+	}
+	if len(c.Datasets) > 0 && !c.StorageMode.IsZFS() {
+		add(fmt.Errorf("%s has no ZFS pool to add datasets to", c.StorageMode))
+	}
+	add(c.ValidateZFSLayout())
+	if c.StorageMode.IsZFS() {
+		if !hostIDRe.MatchString(c.HostID) {
+			add(fmt.Errorf("host ID %q must be 8 hexadecimal digits", c.HostID))
+		}
+		if !poolNameRe.MatchString(c.ZFSPoolName) {
+			add(fmt.Errorf("invalid ZFS pool name %q", c.ZFSPoolName))
+		}
+	}
+	if !knownLocale(c.Locale) {
+		add(fmt.Errorf("unsupported locale %q", c.Locale))
+	}
+	if c.Keymap == "" || c.ConsoleKeyMap == "" {
+		add(fmt.Errorf("keymap is required"))
+	}
+	if c.EnableSSH && len(c.SSHKeys) == 0 {
+		add(fmt.Errorf("SSH is enabled but no authorized keys are set"))
+	}
+	if c.ProjectRoot == "" || c.WorkDir == "" {
+		add(fmt.Errorf("project root and work directory are required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// GenerateHostID returns a fresh ZFS host ID
+func GenerateHostID() string {
+	return fmt.Sprintf("%08x", uint32(time.Now().UnixNano())&0xFFFFFFFF)
+}
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Dataset is a ZFS filesystem in the pool. In Config.Datasets, an entry
+// named like a built-in dataset changes that dataset's properties; any
+// other entry adds a dataset.
+type Dataset struct {
+	Name       string            `json:"name"`                 // Below the pool, e.g. "docker"
+	Mountpoint string            `json:"mountpoint,omitempty"` // e.g. /var/lib/docker
+	Properties map[string]string `json:"properties,omitempty"` // ZFS properties set when it is created
+}
+
+var (
+	datasetNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+	recordsizeRe  = regexp.MustCompile(`^([0-9]+[KM]|512)$`)
+	compressionRe = regexp.MustCompile(`^(on|off|lz4|lzjb|zle|gzip(-[1-9])?|zstd(-[0-9]+)?|zstd-fast(-[0-9]+)?)$`)
+	onOffRe       = regexp.MustCompile(`^(on|off)$`)
+	spaceRe       = regexp.MustCompile(`^(none|[0-9]+[MGT])$`)
+)
+
+// DatasetProperties are the ZFS properties a dataset may set, with the
+// values each accepts
+var DatasetProperties = map[string]*regexp.Regexp{
+	"recordsize":            recordsizeRe,
+	"compression":           compressionRe,
+	"atime":                 onOffRe,
+	"relatime":              onOffRe,
+	"quota":                 spaceRe,
+	"refquota":              spaceRe,
+	"reservation":           spaceRe,
+	"refreservation":        spaceRe,
+	"com.sun:auto-snapshot": regexp.MustCompile(`^(true|false)$`),
+	"sync":                  regexp.MustCompile(`^(standard|always|disabled)$`),
+	"logbias":               regexp.MustCompile(`^(latency|throughput)$`),
+	"primarycache":          regexp.MustCompile(`^(all|none|metadata)$`),
+	"copies":                regexp.MustCompile(`^[123]$`),
+}
+
+// managedProperties are built-in dataset properties other settings own
+var managedProperties = map[string]string{
+	"nix/quota":  "the /nix quota is set on the disk space step",
+	"home/quota": "the /home quota is set on the disk space step",
+}
+
+// BuiltinDatasets are the datasets every tuinix pool has, sized by c. The
+// pool also holds the atuin volume, which is not a filesystem.
+func BuiltinDatasets(c Config) []Dataset {
+	homeQuota := c.SpaceHome
+	if homeQuota == "" {
+		homeQuota = "none"
+	}
+	return []Dataset{
+		{Name: "root", Mountpoint: "/", Properties: map[string]string{
+			"com.sun:auto-snapshot": "false",
+			"mountpoint":            "/",
+		}},
+		{Name: "nix", Mountpoint: "/nix", Properties: map[string]string{
+			"com.sun:auto-snapshot": "false",
+			"quota":                 c.SpaceNix,
+		}},
+		{Name: "home", Mountpoint: "/home", Properties: map[string]string{
+			"com.sun:auto-snapshot": "true",
+			"quota":                 homeQuota,
+		}},
+		{Name: "overflow", Mountpoint: "/overflow", Properties: map[string]string{
+			"com.sun:auto-snapshot": "true",
+		}},
+	}
+}
+
+// ZFSDatasets is the pool's dataset layout: the built-in datasets with
+// c.Datasets' changes applied, then the datasets c.Datasets adds
+func (c Config) ZFSDatasets() []Dataset {
+	datasets := BuiltinDatasets(c)
+	for _, d := range c.Datasets {
+		if i := datasetIndex(datasets, d.Name); i >= 0 {
+			props := map[string]string{}
+			for k, v := range datasets[i].Properties {
+				props[k] = v
+			}
+			for k, v := range d.Properties {
+				props[k] = v
+			}
+			datasets[i].Properties = props
+			continue
+		}
+		datasets = append(datasets, d)
+	}
+	return datasets
+}
+
+// SetDataset adds d to c.Datasets, or merges it into the entry of the same
+// name: a mountpoint replaces the old one and each property is set, or
+// removed if its value is empty. Built-in datasets left with no changes
+// are dropped from c.Datasets.
+func (c *Config) SetDataset(d Dataset) {
+	i := datasetIndex(c.Datasets, d.Name)
+	if i < 0 {
+		c.Datasets = append(c.Datasets, Dataset{Name: d.Name})
+		i = len(c.Datasets) - 1
+	}
+	// Copies of the slice and map, which a copy of c may share
+	c.Datasets = append([]Dataset(nil), c.Datasets...)
+	entry := &c.Datasets[i]
+	if d.Mountpoint != "" {
+		entry.Mountpoint = d.Mountpoint
+	}
+	props := map[string]string{}
+	for k, v := range entry.Properties {
+		props[k] = v
+	}
+	for k, v := range d.Properties {
+		if v == "" {
+			delete(props, k)
+		} else {
+			props[k] = v
+		}
+	}
+	entry.Properties = props
+	if IsBuiltinDataset(entry.Name) && len(entry.Properties) == 0 {
+		c.RemoveDataset(entry.Name)
+	}
+}
+
+// RemoveDataset drops the dataset called name from c.Datasets. For a
+// built-in dataset that undoes its changes.
+func (c *Config) RemoveDataset(name string) bool {
+	i := datasetIndex(c.Datasets, name)
+	if i < 0 {
+		return false
+	}
+	c.Datasets = append(c.Datasets[:i:i], c.Datasets[i+1:]...)
+	return true
+}
+
+// ValidateDatasets checks datasets as they appear in Config.Datasets or an
+// answer file: names, mountpoints and property values
+func ValidateDatasets(datasets []Dataset) error {
+	var errs []error
+	names := map[string]bool{}
+	mounts := map[string]string{}
+	for _, d := range BuiltinDatasets(Config{}) {
+		mounts[d.Mountpoint] = d.Name
+	}
+	mounts["/boot"] = "the ESP"
+	mounts["/var/atuin"] = "atuin"
+
+	for _, d := range datasets {
+		if !datasetNameRe.MatchString(d.Name) {
+			errs = append(errs, fmt.Errorf("dataset name %q must be letters, digits and _.:- and start with a letter or digit", d.Name))
+			continue
+		}
+		if names[d.Name] {
+			errs = append(errs, fmt.Errorf("dataset %s is listed twice", d.Name))
+		}
+		names[d.Name] = true
+
+		if IsBuiltinDataset(d.Name) {
+			if d.Mountpoint != "" {
+				errs = append(errs, fmt.Errorf("dataset %s is built in; its mountpoint cannot change", d.Name))
+			}
+		} else if d.Name == "atuin" {
+			errs = append(errs, fmt.Errorf("atuin is the shell history volume; its size is set on the disk space step"))
+		} else {
+			switch mp := path.Clean(d.Mountpoint); {
+			case d.Mountpoint == "":
+				errs = append(errs, fmt.Errorf("dataset %s needs a mountpoint", d.Name))
+			case !strings.HasPrefix(d.Mountpoint, "/") || mp != d.Mountpoint:
+				errs = append(errs, fmt.Errorf("dataset %s: mountpoint %q must be an absolute path such as /srv", d.Name, d.Mountpoint))
+			case mounts[mp] != "":
+				errs = append(errs, fmt.Errorf("dataset %s: %s is already the mountpoint of %s", d.Name, mp, mounts[mp]))
+			default:
+				mounts[mp] = d.Name
+			}
+		}
+
+		for _, k := range sortedKeys(d.Properties) {
+			v := d.Properties[k]
+			re, ok := DatasetProperties[k]
+			switch {
+			case managedProperties[d.Name+"/"+k] != "":
+				errs = append(errs, fmt.Errorf("dataset %s: %s", d.Name, managedProperties[d.Name+"/"+k]))
+			case !ok:
+				errs = append(errs, fmt.Errorf("dataset %s: unsupported property %q (supported: %s)", d.Name, k, strings.Join(sortedKeys(DatasetProperties), ", ")))
+			case !re.MatchString(v):
+				errs = append(errs, fmt.Errorf("dataset %s: invalid %s %q", d.Name, k, v))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateZFSLayout checks c.Datasets and, once AllocateSpace has measured
+// the disks, that the space the datasets reserve fits in what the sizes
+// leave free
+func (c Config) ValidateZFSLayout() error {
+	if err := ValidateDatasets(c.Datasets); err != nil {
+		return err
+	}
+	u, err := c.SpaceUse()
+	if err != nil || c.SpaceTotalGB == 0 {
+		return nil // ValidateSizes reports it
+	}
+	var reserved int64
+	for _, d := range c.ZFSDatasets() {
+		for _, k := range []string{"reservation", "refreservation"} {
+			if v := d.Properties[k]; v != "" && v != "none" {
+				size, _ := ParseSize(v, 0)
+				reserved += size
+			}
+		}
+	}
+	if free := u.Free() - MinRootMiB; reserved > free {
+		return fmt.Errorf("datasets reserve %s, but only %s is free after the disk space step's sizes", FormatSize(reserved), FormatSize(max(free, 0)))
+	}
+	return nil
+}
+
+// SortedProperties lists d's property names in the order they are written
+func (d Dataset) SortedProperties() []string {
+	return sortedKeys(d.Properties)
+}
+
+// IsBuiltinDataset reports whether name is one of BuiltinDatasets
+func IsBuiltinDataset(name string) bool {
+	return datasetIndex(BuiltinDatasets(Config{}), name) >= 0
+}
+
+func datasetIndex(datasets []Dataset, name string) int {
+	for i, d := range datasets {
+		if d.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParseDataset reads a dataset from one line of the dataset editor:
+// its name, an optional mountpoint and property=value pairs, e.g.
+// "docker /var/lib/docker recordsize=16K compression=lz4". A property
+// given without a value is removed by SetDataset.
+func ParseDataset(line string) (Dataset, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Dataset{}, fmt.Errorf("no dataset name given")
+	}
+	d := Dataset{Name: fields[0]}
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "/") {
+			if d.Mountpoint != "" {
+				return Dataset{}, fmt.Errorf("dataset %s has two mountpoints, %s and %s", d.Name, d.Mountpoint, f)
+			}
+			d.Mountpoint = f
+			continue
+		}
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return Dataset{}, fmt.Errorf("%q is neither a mountpoint nor a property=value pair", f)
+		}
+		if d.Properties == nil {
+			d.Properties = map[string]string{}
+		}
+		d.Properties[k] = v
+	}
+	return d, nil
+}
+
+// String writes d the way ParseDataset reads it
+func (d Dataset) String() string {
+	parts := []string{d.Name}
+	if d.Mountpoint != "" {
+		parts = append(parts, d.Mountpoint)
+	}
+	for _, k := range d.SortedProperties() {
+		parts = append(parts, k+"="+d.Properties[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// DatasetChange is the entry of c.Datasets for the dataset called name,
+// or just the name if it has no changes
+func (c Config) DatasetChange(name string) Dataset {
+	if i := datasetIndex(c.Datasets, name); i >= 0 {
+		return c.Datasets[i]
+	}
+	return Dataset{Name: name}
+}
@@ -0,0 +1,41 @@
+package config
+
+// Keymap pairs the X11/Wayland layout with the matching console keymap
+type Keymap struct {
+	Label      string // Display label (e.g. "us", "pt")
+	XKBLayout  string // X11/Wayland layout (e.g. "us", "pt")
+	ConsoleMap string // Linux console keymap (e.g. "us", "pt-latin1")
+}
+
+// Locales are the system locales the installer offers
+var Locales = []string{"en_US.UTF-8", "en_GB.UTF-8", "pt_PT.UTF-8", "pt_BR.UTF-8", "de_DE.UTF-8", "fr_FR.UTF-8", "es_ES.UTF-8"}
+
+// Keymaps are the keyboard layouts the installer offers
+var Keymaps = []Keymap{
+	{Label: "us", XKBLayout: "us", ConsoleMap: "us"},
+	{Label: "uk", XKBLayout: "gb", ConsoleMap: "uk"},
+	{Label: "pt", XKBLayout: "pt", ConsoleMap: "pt-latin1"},
+	{Label: "br", XKBLayout: "br", ConsoleMap: "br-abnt2"},
+	{Label: "de", XKBLayout: "de", ConsoleMap: "de-latin1"},
+	{Label: "fr", XKBLayout: "fr", ConsoleMap: "fr-latin1"},
+	{Label: "es", XKBLayout: "es", ConsoleMap: "es"},
+}
+
+// KeymapByLabel finds one of Keymaps by its display label
+func KeymapByLabel(label string) (Keymap, bool) {
+	for _, km := range Keymaps {
+		if km.Label == label {
+			return km, true
+		}
+	}
+	return Keymap{}, false
+}
+
+func knownLocale(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
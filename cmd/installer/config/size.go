@@ -0,0 +1,246 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SizeField is one of the sizes the installer lets the user change
+type SizeField int
+
+const (
+	SizeBoot  SizeField = iota // EFI system partition
+	SizeNix                    // Quota of the nix dataset or subvolume
+	SizeAtuin                  // Volume holding the atuin shell history
+	SizeHome                   // Optional quota of the home dataset or subvolume
+)
+
+// Smallest sizes the size editor accepts
+const (
+	MinBootMiB  = 512      // a few generations of kernels and initrds
+	MinNixMiB   = 10 << 10 // the closure of a desktop system
+	MinAtuinMiB = 256
+	MinHomeMiB  = 1 << 10
+
+	// MinRootMiB is left over for / (and /overflow on ZFS) after the
+	// other datasets are carved out of the pool
+	MinRootMiB = 8 << 10
+)
+
+// Label names f on the size editor and summary screens
+func (f SizeField) Label() string {
+	switch f {
+	case SizeBoot:
+		return "/boot"
+	case SizeNix:
+		return "/nix quota"
+	case SizeAtuin:
+		return "atuin"
+	case SizeHome:
+		return "/home quota"
+	}
+	return "unknown"
+}
+
+// AnswerKey is f's key in an answer file
+func (f SizeField) AnswerKey() string {
+	switch f {
+	case SizeBoot:
+		return "space_boot"
+	case SizeNix:
+		return "space_nix"
+	case SizeAtuin:
+		return "space_atuin"
+	case SizeHome:
+		return "space_home"
+	}
+	return ""
+}
+
+// SizeFields lists the sizes c's layout lets the user change, in the order
+// the size editor shows them. An install into free space shares the
+// existing ESP, so it has no boot size.
+func (c Config) SizeFields() []SizeField {
+	var fields []SizeField
+	if c.Alongside == nil {
+		fields = append(fields, SizeBoot)
+	}
+	if c.StorageMode.HasNixVolume() {
+		fields = append(fields, SizeNix)
+	}
+	if c.StorageMode.IsZFS() {
+		fields = append(fields, SizeAtuin)
+	}
+	if c.StorageMode.HasNixVolume() {
+		fields = append(fields, SizeHome)
+	}
+	return fields
+}
+
+// Size returns the value of f in c
+func (c Config) Size(f SizeField) string {
+	switch f {
+	case SizeBoot:
+		return c.SpaceBoot
+	case SizeNix:
+		return c.SpaceNix
+	case SizeAtuin:
+		return c.SpaceAtuin
+	case SizeHome:
+		return c.SpaceHome
+	}
+	return ""
+}
+
+// SetSize sets f in c to val, as typed
+func (c *Config) SetSize(f SizeField, val string) {
+	val = strings.TrimSpace(val)
+	switch f {
+	case SizeBoot:
+		c.SpaceBoot = val
+	case SizeNix:
+		c.SpaceNix = val
+	case SizeAtuin:
+		c.SpaceAtuin = val
+	case SizeHome:
+		c.SpaceHome = val
+	}
+}
+
+// ParseSize reads a size as the size editor and answer files take it: a
+// whole number followed by M, G or T, or a percentage of ofMiB. It returns
+// the size in MiB.
+func ParseSize(val string, ofMiB int64) (int64, error) {
+	val = strings.ToUpper(strings.TrimSpace(val))
+	if val == "" {
+		return 0, fmt.Errorf("size is empty")
+	}
+	unit := val[len(val)-1]
+	n, err := strconv.ParseInt(val[:len(val)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a size: use a number followed by M, G, T or %%", val)
+	}
+	switch unit {
+	case 'M':
+		return n, nil
+	case 'G':
+		return n << 10, nil
+	case 'T':
+		return n << 20, nil
+	case '%':
+		if n > 100 {
+			return 0, fmt.Errorf("%q is more than 100%%", val)
+		}
+		size := ofMiB * n / 100
+		if size >= 1<<10 {
+			size = size >> 10 << 10 // Whole GiB; a percentage is a rough size anyway
+		}
+		return size, nil
+	}
+	return 0, fmt.Errorf("%q is not a size: use a number followed by M, G, T or %%", val)
+}
+
+// FormatSize prints a size in MiB the way disko and ZFS take it, in whole
+// GiB where it divides evenly
+func FormatSize(mib int64) string {
+	if mib%1024 == 0 {
+		return fmt.Sprintf("%dG", mib>>10)
+	}
+	return fmt.Sprintf("%dM", mib)
+}
+
+// SpaceUse is how an install divides its space, in MiB. Pool is what is
+// left after the ESP: the ZFS pool, Btrfs filesystem or root partition.
+// Home is 0 when /home has no quota.
+type SpaceUse struct {
+	Total, Boot, Pool, Nix, Atuin, Home int64
+}
+
+// Free is what remains for / and anything without a quota
+func (u SpaceUse) Free() int64 {
+	return u.Pool - u.Nix - u.Atuin - u.Home
+}
+
+// SpaceUse parses c's sizes against the space AllocateSpace found.
+// Percentages of /boot are of the whole space; the others are of the pool.
+// Every size that does not parse or is too small is reported.
+func (c Config) SpaceUse() (SpaceUse, error) {
+	u := SpaceUse{Total: c.SpaceTotalGB << 10}
+	var errs []error
+	parse := func(f SizeField, of, min int64) int64 {
+		size, err := ParseSize(c.Size(f), of)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Label(), err))
+			return 0
+		}
+		if size < min {
+			errs = append(errs, fmt.Errorf("%s: at least %s is needed", f.Label(), FormatSize(min)))
+		}
+		return size
+	}
+
+	fields := c.SizeFields()
+	has := func(f SizeField) bool {
+		for _, field := range fields {
+			if field == f {
+				return true
+			}
+		}
+		return false
+	}
+
+	if has(SizeBoot) {
+		u.Boot = parse(SizeBoot, u.Total, MinBootMiB)
+	}
+	u.Pool = u.Total - u.Boot
+	if c.RedundantESP && c.StorageMode == StorageZFSStripedMirror {
+		// Each mirror pair gives up room for its own ESPs
+		u.Pool = u.Total - u.Boot*int64(len(MirrorPairs(c.Disks)))
+	}
+	if has(SizeNix) {
+		u.Nix = parse(SizeNix, u.Pool, MinNixMiB)
+	}
+	if has(SizeAtuin) {
+		u.Atuin = parse(SizeAtuin, u.Pool, MinAtuinMiB)
+	}
+	if has(SizeHome) && c.SpaceHome != "" && c.SpaceHome != "none" {
+		u.Home = parse(SizeHome, u.Pool, MinHomeMiB)
+	}
+	return u, errors.Join(errs...)
+}
+
+// ValidateSizes checks that c's sizes parse, meet their minimums and,
+// once AllocateSpace has measured the disks, fit in them with MinRootMiB
+// to spare
+func (c Config) ValidateSizes() error {
+	u, err := c.SpaceUse()
+	if err != nil || c.SpaceTotalGB == 0 {
+		return err
+	}
+	if u.Free() < MinRootMiB {
+		return fmt.Errorf("together the sizes leave %s of %s for /; at least %s must stay free",
+			FormatSize(max(u.Free(), 0)), FormatSize(u.Total), FormatSize(MinRootMiB))
+	}
+	return nil
+}
+
+// ResolveSizes validates c's sizes and rewrites them the way the disko
+// templates need them: percentages become sizes, units are upper case and
+// a /home without a quota is empty
+func (c *Config) ResolveSizes() error {
+	if err := c.ValidateSizes(); err != nil {
+		return err
+	}
+	u, _ := c.SpaceUse()
+	resolved := map[SizeField]int64{SizeBoot: u.Boot, SizeNix: u.Nix, SizeAtuin: u.Atuin, SizeHome: u.Home}
+	for _, f := range c.SizeFields() {
+		if resolved[f] == 0 {
+			c.SetSize(f, "")
+		} else {
+			c.SetSize(f, FormatSize(resolved[f]))
+		}
+	}
+	return nil
+}
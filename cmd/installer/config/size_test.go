@@ -0,0 +1,238 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		val     string
+		ofMiB   int64
+		want    int64
+		wantErr bool
+	}{
+		{"512M", 0, 512, false},
+		{"5g", 0, 5 << 10, false},
+		{" 1T ", 0, 1 << 20, false},
+		{"10%", 100 << 10, 10 << 10, false},
+		{"100%", 100 << 10, 100 << 10, false},
+		{"3%", 50000, 1 << 10, false}, // 1500M, rounded down to whole GiB
+		{"5%", 50000, 2 << 10, false}, // 2500M
+		{"1%", 50000, 500, false},     // Under 1 GiB is kept as it is
+		{"101%", 100 << 10, 0, true},
+		{"0G", 0, 0, true},
+		{"-1G", 0, 0, true},
+		{"", 0, 0, true},
+		{"none", 0, 0, true},
+		{"G", 0, 0, true},
+		{"5", 0, 0, true},
+		{"5GB", 0, 0, true},
+		{"1.5G", 0, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.val, tt.ofMiB)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q, %d) = %d, %v; want %d, error %v", tt.val, tt.ofMiB, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// sizedConfig is a 100 GiB single-disk ZFS install with the sizes
+// AllocateSpace picks
+func sizedConfig() Config {
+	return Config{
+		StorageMode:  StorageZFSEncryptedSingle,
+		Disk:         "/dev/vda",
+		Disks:        []string{"/dev/vda"},
+		SpaceTotalGB: 100,
+		SpaceBoot:    "5G",
+		SpaceNix:     "20G",
+		SpaceAtuin:   "1G",
+	}
+}
+
+func TestSpaceUse(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		want    SpaceUse
+		wantErr bool
+	}{
+		{
+			name: "home without a quota",
+			want: SpaceUse{Total: 102400, Boot: 5120, Pool: 97280, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name:   "home quota none",
+			change: func(c *Config) { c.SpaceHome = "none" },
+			want:   SpaceUse{Total: 102400, Boot: 5120, Pool: 97280, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name:   "percentages of the pool, in whole GiB",
+			change: func(c *Config) { c.SpaceNix = "15%"; c.SpaceHome = "50%" },
+			want:   SpaceUse{Total: 102400, Boot: 5120, Pool: 97280, Nix: 14336, Atuin: 1024, Home: 48128},
+		},
+		{
+			name:   "boot percentage of the whole space",
+			change: func(c *Config) { c.SpaceBoot = "1%" },
+			want:   SpaceUse{Total: 102400, Boot: 1024, Pool: 101376, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name: "striped mirrors with an ESP on every disk",
+			change: func(c *Config) {
+				c.StorageMode = StorageZFSStripedMirror
+				c.Disks = []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"}
+				c.RedundantESP = true
+				c.SpaceTotalGB = 200
+				c.SpaceBoot = "1G"
+			},
+			// Each of the two pairs loses 1 GiB to its ESPs
+			want: SpaceUse{Total: 204800, Boot: 1024, Pool: 202752, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name: "striped mirrors with one ESP",
+			change: func(c *Config) {
+				c.StorageMode = StorageZFSStripedMirror
+				c.Disks = []string{"/dev/sda", "/dev/sdb", "/dev/sdc", "/dev/sdd"}
+				c.SpaceTotalGB = 200
+				c.SpaceBoot = "1G"
+			},
+			want: SpaceUse{Total: 204800, Boot: 1024, Pool: 203776, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name: "mirror with an ESP on every disk",
+			change: func(c *Config) {
+				c.StorageMode = StorageZFSMirror
+				c.Disks = []string{"/dev/sda", "/dev/sdb"}
+				c.RedundantESP = true
+			},
+			want: SpaceUse{Total: 102400, Boot: 5120, Pool: 97280, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name: "XFS has only a boot size",
+			change: func(c *Config) {
+				c.StorageMode = StorageXFS
+				c.SpaceNix, c.SpaceAtuin = "", ""
+			},
+			want: SpaceUse{Total: 102400, Boot: 5120, Pool: 97280},
+		},
+		{
+			name: "Btrfs has no atuin volume",
+			change: func(c *Config) {
+				c.StorageMode = StorageBtrfs
+				c.SpaceAtuin = ""
+			},
+			want: SpaceUse{Total: 102400, Boot: 5120, Pool: 97280, Nix: 20480},
+		},
+		{
+			name: "alongside another OS shares its ESP",
+			change: func(c *Config) {
+				c.Alongside = &Alongside{}
+				c.SpaceBoot = ""
+			},
+			want: SpaceUse{Total: 102400, Pool: 102400, Nix: 20480, Atuin: 1024},
+		},
+		{
+			name:    "below the minimums",
+			change:  func(c *Config) { c.SpaceBoot = "256M"; c.SpaceNix = "5G"; c.SpaceHome = "512M" },
+			want:    SpaceUse{Total: 102400, Boot: 256, Pool: 102144, Nix: 5120, Atuin: 1024, Home: 512},
+			wantErr: true,
+		},
+		{
+			name:    "not a size",
+			change:  func(c *Config) { c.SpaceNix = "lots" },
+			want:    SpaceUse{Total: 102400, Boot: 5120, Pool: 97280, Atuin: 1024},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := sizedConfig()
+			if tt.change != nil {
+				tt.change(&c)
+			}
+			got, err := c.SpaceUse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SpaceUse error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SpaceUse = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSizesMinRoot(t *testing.T) {
+	// 95 GiB of pool after the ESP, less 1 GiB of atuin, leaves 94 GiB
+	// for /nix and / together
+	tests := []struct {
+		nix     string
+		wantErr bool
+	}{
+		{"86G", false},    // Exactly MinRootMiB left for /
+		{"88064M", false}, // The same
+		{"88065M", true},  // 1 MiB short
+		{"94G", true},
+		{"100%", true},
+	}
+	for _, tt := range tests {
+		c := sizedConfig()
+		c.SpaceNix = tt.nix
+		if err := c.ValidateSizes(); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateSizes with /nix %s = %v, want error %v", tt.nix, err, tt.wantErr)
+		}
+	}
+
+	// Before the disks are measured only the sizes themselves are checked
+	c := sizedConfig()
+	c.SpaceTotalGB = 0
+	c.SpaceNix = "1T"
+	if err := c.ValidateSizes(); err != nil {
+		t.Errorf("ValidateSizes before AllocateSpace = %v", err)
+	}
+}
+
+func TestResolveSizes(t *testing.T) {
+	tests := []struct {
+		name                   string
+		boot, nix, atuin, home string
+		wantBoot, wantNix      string
+		wantAtuin, wantHome    string
+		wantErr                bool
+	}{
+		{
+			name: "already resolved",
+			boot: "5G", nix: "20G", atuin: "1G", home: "",
+			wantBoot: "5G", wantNix: "20G", wantAtuin: "1G", wantHome: "",
+		},
+		{
+			name: "lower case units and none",
+			boot: "512m", nix: "20g", atuin: "1024m", home: "none",
+			wantBoot: "512M", wantNix: "20G", wantAtuin: "1G", wantHome: "",
+		},
+		{
+			name: "percentages",
+			boot: "1%", nix: "15%", atuin: "1%", home: "50%",
+			wantBoot: "1G", wantNix: "14G", wantAtuin: "1013M", wantHome: "49G",
+		},
+		{
+			name: "too big to leave room for /",
+			boot: "5G", nix: "90G", atuin: "1G", home: "",
+			wantBoot: "5G", wantNix: "90G", wantAtuin: "1G", wantHome: "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := sizedConfig()
+			c.SpaceBoot, c.SpaceNix, c.SpaceAtuin, c.SpaceHome = tt.boot, tt.nix, tt.atuin, tt.home
+			err := c.ResolveSizes()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveSizes = %v, want error %v", err, tt.wantErr)
+			}
+			got := [4]string{c.SpaceBoot, c.SpaceNix, c.SpaceAtuin, c.SpaceHome}
+			want := [4]string{tt.wantBoot, tt.wantNix, tt.wantAtuin, tt.wantHome}
+			if got != want {
+				t.Errorf("ResolveSizes gives boot, nix, atuin, home %q, want %q", got, want)
+			}
+		})
+	}
+}
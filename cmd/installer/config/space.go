@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AllocateSpace fills in the boot partition and dataset sizes for c from
+// the size of its disks. diskSizeGB reports a disk's size in GiB; a disk
+// it cannot measure is an error.
+func AllocateSpace(c *Config, diskSizeGB func(disk string) (int64, error)) error {
+	// For multi-disk ZFS, calculate total pool size across all disks
+	// (excluding the boot partition on the first disk)
+	var totalSizeGB int64
+
+	if c.Alongside != nil {
+		// Only the new partition is ours; the existing ESP serves as /boot
+		totalSizeGB = c.Alongside.SizeGB()
+	} else if c.StorageMode.IsMultiDisk() {
+		sizes := make([]int64, len(c.Disks))
+		for i, disk := range c.Disks {
+			size, err := diskSizeGB(disk)
+			if err != nil {
+				return err
+			}
+			sizes[i] = size
+			totalSizeGB += size
+		}
+		// For raidz, usable space is roughly (N-1)/N of total
+		// For raidz2, usable space is roughly (N-2)/N of total
+		// For raidz3, usable space is roughly (N-3)/N of total
+		// For stripe, usable space is total
+		// A mirror holds as much as its smallest disk; striped mirrors
+		// hold the sum of each pair's smallest disk
+		n := int64(len(c.Disks))
+		switch c.StorageMode {
+		case StorageZFSRaidz:
+			totalSizeGB = totalSizeGB * (n - 1) / n
+		case StorageZFSRaidz2:
+			totalSizeGB = totalSizeGB * (n - 2) / n
+		case StorageZFSRaidz3:
+			totalSizeGB = totalSizeGB * (n - 3) / n
+		case StorageZFSMirror:
+			totalSizeGB = smallest(sizes)
+		case StorageZFSStripedMirror:
+			totalSizeGB = 0
+			for i := 0; i+1 < len(sizes); i += 2 { // Same pairs as MirrorPairs
+				totalSizeGB += smallest(sizes[i : i+2])
+			}
+		}
+	} else {
+		size, err := diskSizeGB(c.Disk)
+		if err != nil {
+			return err
+		}
+		totalSizeGB = size
+	}
+
+	if totalSizeGB <= 0 {
+		return fmt.Errorf("no space for the system on %s", strings.Join(c.Disks, ", "))
+	}
+	c.SpaceTotalGB = totalSizeGB
+
+	bootGB := int64(5)
+	c.SpaceBoot = fmt.Sprintf("%dG", bootGB)
+	if c.Alongside != nil {
+		bootGB = 0
+		c.SpaceBoot = ""
+	}
+
+	if !c.StorageMode.HasNixVolume() {
+		// XFS and LUKS: just boot + root, no separate partitions
+		c.SpaceNix = ""
+		c.SpaceAtuin = ""
+		c.SpaceHome = ""
+		return nil
+	}
+
+	// The boot partition is separate from the ZFS pool or Btrfs partition,
+	// so subtract it from the first disk's contribution to get actual pool
+	// size
+	poolSizeGB := totalSizeGB - bootGB
+
+	nixGB := poolSizeGB * 5 / 100
+	if nixGB < 20 {
+		nixGB = 20
+	}
+
+	// /home has no quota unless the user sets one: it shares what is
+	// left with / (and /overflow on ZFS)
+	c.SpaceNix = fmt.Sprintf("%dG", nixGB)
+	c.SpaceHome = ""
+	if c.StorageMode.IsBtrfs() {
+		// Shell history needs no volume of its own outside ZFS
+		c.SpaceAtuin = ""
+		return nil
+	}
+	atuinGB := poolSizeGB * 5 / 10000
+	if atuinGB < 1 {
+		atuinGB = 1
+	}
+	c.SpaceAtuin = fmt.Sprintf("%dG", atuinGB)
+	return nil
+}
+
+func smallest(sizes []int64) int64 {
+	var min int64
+	for i, size := range sizes {
+		if i == 0 || size < min {
+			min = size
+		}
+	}
+	return min
+}
+
+// MirrorPairs splits disks into the two-way mirrors of a striped-mirror
+// pool, in selection order: the first and second disk, the third and
+// fourth, and so on
+func MirrorPairs(disks []string) [][]string {
+	var pairs [][]string
+	for i := 0; i+1 < len(disks); i += 2 {
+		pairs = append(pairs, disks[i:i+2])
+	}
+	return pairs
+}
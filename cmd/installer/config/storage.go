@@ -0,0 +1,239 @@
+package config
+
+import "fmt"
+
+// StorageMode determines disk layout strategy
+type StorageMode int
+
+const (
+	StorageZFSEncryptedSingle StorageMode = iota // Encrypted ZFS, single disk (original)
+	StorageXFS                                   // XFS unencrypted, single disk (max performance)
+	StorageZFSStripe                             // Encrypted ZFS stripe, multi-disk (combined space)
+	StorageZFSRaidz                              // Encrypted ZFS raidz, multi-disk (1 disk fault tolerance)
+	StorageZFSRaidz2                             // Encrypted ZFS raidz2, multi-disk (2 disk fault tolerance)
+	StorageZFSMirror                             // Encrypted ZFS mirror, multi-disk (all but one disk can fail)
+	StorageZFSStripedMirror                      // Encrypted ZFS striped mirrors (RAID10), even disk count
+	StorageZFSRaidz3                             // Encrypted ZFS raidz3, multi-disk (3 disk fault tolerance)
+	StorageLUKSXFS                               // LUKS2-encrypted XFS, single disk (no ZFS needed)
+	StorageLUKSExt4                              // LUKS2-encrypted ext4, single disk (no ZFS needed)
+	StorageBtrfs                                 // Btrfs subvolumes, single disk (snapshots without ZFS)
+	StorageLUKSBtrfs                             // Btrfs subvolumes in LUKS2, single disk
+)
+
+// StorageModes lists every mode in the order the wizard offers them
+var StorageModes = []StorageMode{
+	StorageZFSEncryptedSingle,
+	StorageXFS,
+	StorageLUKSXFS,
+	StorageLUKSExt4,
+	StorageBtrfs,
+	StorageLUKSBtrfs,
+	StorageZFSStripe,
+	StorageZFSMirror,
+	StorageZFSStripedMirror,
+	StorageZFSRaidz,
+	StorageZFSRaidz2,
+	StorageZFSRaidz3,
+}
+
+var storageModeDescriptions = map[StorageMode]string{
+	StorageZFSEncryptedSingle: "Single disk with AES-256-GCM encryption, compression, and snapshots",
+	StorageXFS:                "Single disk, no encryption. Maximum raw I/O performance",
+	StorageZFSStripe:          "Multiple disks combined for maximum space (no redundancy)",
+	StorageZFSRaidz:           "Multiple disks with single parity. Tolerates 1 disk failure (min 3 disks)",
+	StorageZFSRaidz2:          "Multiple disks with double parity. Tolerates 2 disk failures (min 4 disks)",
+	StorageZFSMirror:          "Every disk holds a full copy. Survives all but one disk failing (min 2 disks)",
+	StorageZFSStripedMirror:   "Pairs of mirrored disks, striped (RAID10). 1 failure per pair (even count, min 4)",
+	StorageZFSRaidz3:          "Multiple disks with triple parity. Tolerates 3 disk failures (min 5 disks)",
+	StorageLUKSXFS:            "Single disk, LUKS2 encryption with XFS. For machines without ZFS (aarch64)",
+	StorageLUKSExt4:           "Single disk, LUKS2 encryption with ext4. For machines without ZFS (aarch64)",
+	StorageBtrfs:              "Single disk, Btrfs subvolumes with zstd compression and snapshots, no encryption",
+	StorageLUKSBtrfs:          "Single disk, Btrfs subvolumes with zstd compression and snapshots in LUKS2",
+}
+
+func (s StorageMode) String() string {
+	switch s {
+	case StorageZFSEncryptedSingle:
+		return "Encrypted ZFS (single disk)"
+	case StorageXFS:
+		return "XFS unencrypted (max performance)"
+	case StorageZFSStripe:
+		return "Encrypted ZFS stripe (combined space)"
+	case StorageZFSRaidz:
+		return "Encrypted ZFS raidz (1-disk fault tolerance)"
+	case StorageZFSRaidz2:
+		return "Encrypted ZFS raidz2 (2-disk fault tolerance)"
+	case StorageZFSMirror:
+		return "Encrypted ZFS mirror (full copy on every disk)"
+	case StorageZFSStripedMirror:
+		return "Encrypted ZFS striped mirrors (RAID10)"
+	case StorageZFSRaidz3:
+		return "Encrypted ZFS raidz3 (3-disk fault tolerance)"
+	case StorageLUKSXFS:
+		return "LUKS-encrypted XFS (single disk, no ZFS)"
+	case StorageLUKSExt4:
+		return "LUKS-encrypted ext4 (single disk, no ZFS)"
+	case StorageBtrfs:
+		return "Btrfs (single disk, snapshots)"
+	case StorageLUKSBtrfs:
+		return "LUKS-encrypted Btrfs (single disk, snapshots)"
+	default:
+		return "Unknown"
+	}
+}
+
+// Description is a one-line explanation of the mode for selection lists
+func (s StorageMode) Description() string {
+	return storageModeDescriptions[s]
+}
+
+// Key returns the stable identifier used for the mode in answer files
+func (s StorageMode) Key() string {
+	switch s {
+	case StorageZFSEncryptedSingle:
+		return "zfs"
+	case StorageXFS:
+		return "xfs"
+	case StorageZFSStripe:
+		return "zfs-stripe"
+	case StorageZFSRaidz:
+		return "zfs-raidz"
+	case StorageZFSRaidz2:
+		return "zfs-raidz2"
+	case StorageZFSMirror:
+		return "zfs-mirror"
+	case StorageZFSStripedMirror:
+		return "zfs-raid10"
+	case StorageZFSRaidz3:
+		return "zfs-raidz3"
+	case StorageLUKSXFS:
+		return "luks-xfs"
+	case StorageLUKSExt4:
+		return "luks-ext4"
+	case StorageBtrfs:
+		return "btrfs"
+	case StorageLUKSBtrfs:
+		return "luks-btrfs"
+	default:
+		return ""
+	}
+}
+
+// ParseStorageMode maps an answer file identifier back to a storage mode
+func ParseStorageMode(key string) (StorageMode, error) {
+	for _, mode := range StorageModes {
+		if mode.Key() == key {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown storage mode %q", key)
+}
+
+func (s StorageMode) IsZFS() bool {
+	return s != StorageXFS && !s.IsLUKS() && !s.IsBtrfs()
+}
+
+// IsLUKS reports whether the root filesystem sits in a LUKS2 container
+func (s StorageMode) IsLUKS() bool {
+	return s == StorageLUKSXFS || s == StorageLUKSExt4 || s == StorageLUKSBtrfs
+}
+
+// IsBtrfs reports whether the root filesystem is Btrfs with subvolumes
+func (s StorageMode) IsBtrfs() bool {
+	return s == StorageBtrfs || s == StorageLUKSBtrfs
+}
+
+func (s StorageMode) IsEncrypted() bool {
+	return s != StorageXFS && s != StorageBtrfs
+}
+
+// HasNixVolume reports whether /nix gets its own ZFS dataset or Btrfs
+// subvolume with a quota, sized by AllocateSpace
+func (s StorageMode) HasNixVolume() bool {
+	return s.IsZFS() || s.IsBtrfs()
+}
+
+// RootFormat is the filesystem the non-ZFS modes put on the root partition
+func (s StorageMode) RootFormat() string {
+	switch s {
+	case StorageXFS, StorageLUKSXFS:
+		return "xfs"
+	case StorageLUKSExt4:
+		return "ext4"
+	case StorageBtrfs, StorageLUKSBtrfs:
+		return "btrfs"
+	default:
+		return ""
+	}
+}
+
+// HasRedundancy reports whether s's pool survives losing a disk, so that
+// an ESP on every disk keeps the machine bootable too
+func (s StorageMode) HasRedundancy() bool {
+	switch s {
+	case StorageZFSMirror, StorageZFSStripedMirror,
+		StorageZFSRaidz, StorageZFSRaidz2, StorageZFSRaidz3:
+		return true
+	}
+	return false
+}
+
+// IsRaidz reports whether s stripes data with parity across its disks
+func (s StorageMode) IsRaidz() bool {
+	return s == StorageZFSRaidz || s == StorageZFSRaidz2 || s == StorageZFSRaidz3
+}
+
+func (s StorageMode) IsMultiDisk() bool {
+	switch s {
+	case StorageZFSStripe, StorageZFSMirror, StorageZFSStripedMirror,
+		StorageZFSRaidz, StorageZFSRaidz2, StorageZFSRaidz3:
+		return true
+	}
+	return false
+}
+
+func (s StorageMode) MinDisks() int {
+	switch s {
+	case StorageZFSRaidz:
+		return 3
+	case StorageZFSRaidz2:
+		return 4
+	case StorageZFSRaidz3:
+		return 5
+	case StorageZFSStripe, StorageZFSMirror:
+		return 2
+	case StorageZFSStripedMirror:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// MinDiskMiB is the smallest disk s installs to when n disks are
+// selected: room for a boot partition, plus an even share of the smallest
+// pool the size editor accepts on each disk that holds data rather than
+// redundancy
+func (s StorageMode) MinDiskMiB(n int) int64 {
+	pool := int64(MinRootMiB)
+	if s.HasNixVolume() {
+		pool += MinNixMiB
+	}
+	if s.IsZFS() {
+		pool += MinAtuinMiB
+	}
+	data := int64(1)
+	switch s {
+	case StorageZFSStripe:
+		data = int64(n)
+	case StorageZFSStripedMirror:
+		data = int64(n / 2)
+	case StorageZFSRaidz:
+		data = int64(n - 1)
+	case StorageZFSRaidz2:
+		data = int64(n - 2)
+	case StorageZFSRaidz3:
+		data = int64(n - 3)
+	}
+	data = max(data, 1)
+	return MinBootMiB + (pool+data-1)/data
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	poolNameRe  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]*$`)
+	sizeValueRe = regexp.MustCompile(`^[0-9]+[MGT]$`)
+	hostIDRe    = regexp.MustCompile(`^[0-9a-f]{8}$`)
+)
+
+func isValidUsername(s string) bool {
+	if s == "" {
+		return false
+	}
+	matched, _ := regexp.MatchString(`^[a-z_][a-z0-9_-]*$`, s)
+	return matched
+}
+
+func isValidEmail(s string) bool {
+	if s == "" {
+		return false
+	}
+	matched, _ := regexp.MatchString(`^[^@]+@[^@]+\.[^@]+$`, s)
+	return matched
+}
+
+func isValidHostname(s string) bool {
+	if s == "" {
+		return false
+	}
+	matched, _ := regexp.MatchString(`^[a-zA-Z0-9-]+$`, s)
+	return matched
+}
+
+// The Validate* helpers wrap the predicates above with the messages shown in
+// the wizard, so the TUI and answer files report problems the same way.
+
+func ValidateUsername(s string) error {
+	if !isValidUsername(s) {
+		return fmt.Errorf("invalid username: use lowercase letters, numbers, underscores, hyphens")
+	}
+	return nil
+}
+
+func ValidateFullname(s string) error {
+	if s == "" {
+		return fmt.Errorf("full name is required")
+	}
+	return nil
+}
+
+func ValidateEmail(s string) error {
+	if !isValidEmail(s) {
+		return fmt.Errorf("please enter a valid email address")
+	}
+	return nil
+}
+
+func ValidatePassword(s string) error {
+	if len(s) < 8 {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+	return nil
+}
+
+func ValidateHostname(s string) error {
+	if !isValidHostname(s) {
+		return fmt.Errorf("invalid hostname: use letters, numbers, and hyphens only")
+	}
+	return nil
+}
+
+func ValidatePassphrase(s string) error {
+	if len(s) < 8 {
+		return fmt.Errorf("passphrase must be at least 8 characters")
+	}
+	return nil
+}
+
+func ValidateDiskSelection(mode StorageMode, disks []string) error {
+	if len(disks) < mode.MinDisks() {
+		return fmt.Errorf("select at least %d disks for %s", mode.MinDisks(), mode)
+	}
+	if !mode.IsMultiDisk() && len(disks) != 1 {
+		return fmt.Errorf("%s uses exactly one disk", mode)
+	}
+	if mode == StorageZFSStripedMirror && len(disks)%2 != 0 {
+		return fmt.Errorf("%s pairs the disks up, so select an even number of them", mode)
+	}
+	return nil
+}
+
+// FetchGitHubKeys fetches public SSH keys for a GitHub user
+func FetchGitHubKeys(ctx context.Context, username string) ([]string, error) {
+	url := fmt.Sprintf("https://github.com/%s.keys", username)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("GitHub user %q not found", username)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var keys []string
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+)
+
+// datasetRows are the names of the dataset editor's rows: the pool's
+// datasets, then an empty name for the row that adds one
+func (m model) datasetRows() []string {
+	var rows []string
+	for _, d := range m.config.ZFSDatasets() {
+		rows = append(rows, d.Name)
+	}
+	return append(rows, "")
+}
+
+// datasetRow is the name of the dataset the editor's input is on, empty
+// on the row that adds a dataset
+func (m model) datasetRow() string {
+	return m.datasetRows()[m.selectedIdx]
+}
+
+// focusDataset puts the changes made to the selected dataset into the
+// input, ready to edit
+func (m model) focusDataset() model {
+	name := m.datasetRow()
+	m.input.SetValue("")
+	m.input.Placeholder = "e.g. docker /var/lib/docker recordsize=16K"
+	if name != "" {
+		m.input.SetValue(m.config.DatasetChange(name).String())
+		m.input.Placeholder = name
+	}
+	m.input.CursorEnd()
+	return m
+}
+
+// handleDatasetKey moves between the rows of the dataset editor, dropping
+// any edit that was not applied with Enter. Anything else is left to the
+// input.
+func (m model) handleDatasetKey(key string) (model, bool) {
+	n := len(m.datasetRows())
+	switch key {
+	case "up", "shift+tab":
+		m.selectedIdx = (m.selectedIdx + n - 1) % n
+	case "down", "tab":
+		m.selectedIdx = (m.selectedIdx + 1) % n
+	default:
+		return m, false
+	}
+	return m.focusDataset(), true
+}
+
+// applyDataset replaces the selected dataset's changes with the input, or
+// adds the dataset the input describes. An empty input removes an added
+// dataset and undoes the changes to a built-in one. The layout is only
+// changed if it stays valid.
+func (m model) applyDataset() (model, error) {
+	c := m.config
+	if name := m.datasetRow(); name != "" {
+		c.RemoveDataset(name)
+	}
+	if line := strings.TrimSpace(m.input.Value()); line != "" {
+		d, err := config.ParseDataset(line)
+		if err != nil {
+			return m, err
+		}
+		c.SetDataset(d)
+	}
+	if err := c.ValidateZFSLayout(); err != nil {
+		return m, err
+	}
+	m.config = c
+	m.selectedIdx = len(m.datasetRows()) - 1
+	return m.focusDataset(), nil
+}
+
+// renderDatasets is the dataset editor: every dataset of the pool with
+// its properties, the selected one open for editing
+func (m model) renderDatasets() string {
+	nameStyle := lipgloss.NewStyle().Foreground(colorOffWhite)
+	var rows strings.Builder
+	for i, d := range m.config.ZFSDatasets() {
+		label := fmt.Sprintf("  %-10s %-16s", d.Name, d.Mountpoint)
+		if i == m.selectedIdx {
+			rows.WriteString(lipgloss.NewStyle().Foreground(colorOrange).Bold(true).Render(label) + "\n")
+			rows.WriteString("    " + m.input.View() + "\n")
+			continue
+		}
+		marker := ""
+		if !config.IsBuiltinDataset(d.Name) {
+			marker = successStyle.Render(" (added)")
+		} else if len(m.config.DatasetChange(d.Name).Properties) > 0 {
+			marker = successStyle.Render(" (changed)")
+		}
+		rows.WriteString(nameStyle.Render(label) + marker + "\n")
+		rows.WriteString(grayStyle.Render("    "+datasetProperties(d)) + "\n")
+	}
+	rows.WriteString(grayStyle.Render(fmt.Sprintf("  %-10s %-16s volume of %s", "atuin", "/var/atuin", m.config.SpaceAtuin)) + "\n")
+
+	add := "  + add a dataset"
+	if m.selectedIdx == len(m.datasetRows())-1 {
+		rows.WriteString(lipgloss.NewStyle().Foreground(colorOrange).Bold(true).Render(add) + "\n")
+		rows.WriteString("    " + m.input.View() + "\n")
+	} else {
+		rows.WriteString(nameStyle.Render(add) + "\n")
+	}
+
+	var errText string
+	if m.err != nil {
+		for _, line := range strings.Split(m.err.Error(), "\n") {
+			errText += "\n" + errorStyle.Render("! "+line)
+		}
+	}
+	hint := grayStyle.Render("\nUp/Down to move | Enter to apply a line | Enter on an empty new line to continue")
+	return grayStyle.Render("Pool "+m.config.ZFSPoolName) + "\n\n" + rows.String() + errText + hint
+}
+
+// datasetProperties lists the properties d is created with, leaving out
+// the mountpoint that is already shown
+func datasetProperties(d config.Dataset) string {
+	var props []string
+	for _, k := range d.SortedProperties() {
+		if k != "mountpoint" {
+			props = append(props, k+"="+d.Properties[k])
+		}
+	}
+	if len(props) == 0 {
+		return "inherits the pool's properties"
+	}
+	return strings.Join(props, " ")
+}
+
+// datasetSummary describes the changes to the dataset layout, for the
+// summary screens
+func datasetSummary(c config.Config) string {
+	if len(c.Datasets) == 0 {
+		return "default"
+	}
+	var names []string
+	for _, d := range c.Datasets {
+		if config.IsBuiltinDataset(d.Name) {
+			names = append(names, d.Name+" (changed)")
+		} else {
+			names = append(names, d.Name+" on "+d.Mountpoint)
+		}
+	}
+	return strings.Join(names, ", ")
+}
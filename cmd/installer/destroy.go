@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+)
+
+// serialChars is how many characters from the end of each disk's serial
+// number the multi-disk confirmation asks for
+const serialChars = 4
+
+// diskPreview is what the confirmation screen shows about a disk that is
+// about to be erased
+type diskPreview struct {
+	Disk     string
+	Contents *disk.Contents // nil if the disk could not be read
+	Err      error
+}
+
+// confirmStep is one thing the user types to confirm an install: the
+// confirm word, then for multi-disk modes the end of each disk's serial
+type confirmStep struct {
+	Prompt string
+	Word   string
+}
+
+// previewDestruction reads what is on each of the disks c erases. An
+// install into free space erases nothing.
+func previewDestruction(c config.Config) []diskPreview {
+	if c.Alongside != nil {
+		return nil
+	}
+	ctx := context.Background()
+	pools := disk.ImportablePools(ctx, shell())
+	var previews []diskPreview
+	for _, d := range c.Disks {
+		contents, err := disk.Inspect(ctx, shell(), d, pools)
+		if err != nil {
+			logError("Inspect %s: %v", d, err)
+		}
+		previews = append(previews, diskPreview{Disk: d, Contents: contents, Err: err})
+	}
+	return previews
+}
+
+// confirmSteps lists what has to be typed to start the install. Multi-disk
+// modes also ask for the last characters of each disk's serial number, or
+// its kernel name if it reports none, so that a mix-up between similar
+// disks is caught before any of them is wiped.
+func confirmSteps(c config.Config, previews []diskPreview) []confirmStep {
+	word := confirmWord(c)
+	steps := []confirmStep{{Prompt: "Type " + word + " to confirm", Word: word}}
+	if !c.StorageMode.IsMultiDisk() {
+		return steps
+	}
+	for _, p := range previews {
+		if p.Contents != nil && len(p.Contents.Serial) >= serialChars {
+			steps = append(steps, confirmStep{
+				Prompt: fmt.Sprintf("Last %d characters of the serial number of %s", serialChars, p.Disk),
+				Word:   p.Contents.Serial[len(p.Contents.Serial)-serialChars:],
+			})
+		} else {
+			steps = append(steps, confirmStep{
+				Prompt: fmt.Sprintf("%s reports no serial number; type its name (%s)", p.Disk, path.Base(p.Disk)),
+				Word:   path.Base(p.Disk),
+			})
+		}
+	}
+	return steps
+}
+
+// previewHeader describes the disk of p
+func previewHeader(p diskPreview) string {
+	c := p.Contents
+	if c == nil {
+		return p.Disk
+	}
+	parts := []string{c.Disk, disk.FormatBytes(c.Bytes)}
+	if c.Model != "" {
+		parts = append(parts, c.Model)
+	}
+	if c.Serial != "" {
+		parts = append(parts, "S/N "+c.Serial)
+	}
+	return strings.Join(parts, "  ")
+}
+
+// previewItems lists what will be destroyed on p's disk, one line each
+func previewItems(p diskPreview) []string {
+	if p.Err != nil {
+		return []string{"could not read the disk: " + p.Err.Error()}
+	}
+	if len(p.Contents.Items) == 0 {
+		return []string{"no partitions or signatures found"}
+	}
+	var lines []string
+	for _, it := range p.Contents.Items {
+		line := fmt.Sprintf("%s%-16s %8s  %s", strings.Repeat("  ", it.Depth), it.Device, disk.FormatBytes(it.Bytes), it.What)
+		if it.OS != "" {
+			line += "  [" + it.OS + "]"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+)
+
+// Filesystem label of removable media that carries an answer file
+const answerMediaLabel = "TUINIX-CFG"
+
+// Local copy of an answer file fetched from a URL or removable media
+const discoveredAnswerPath = "/tmp/tuinix-answers.json"
+
+// Answer files baked into the boot media, next to the tuinix flake on the
+// ISO; the first match in name order is used
+const isoAnswerGlob = "/iso/tuinix/answers.*"
+
+// Where the answer file may live on labelled media, in order of preference
+var answerMediaPaths = []string{
+	"tuinix/answers.json",
+	"answers.json",
+}
+
+// discoverAnswerFile looks for an answer file when none was given with
+// --config. It tries, in order: tuinix.config= on the kernel command line
+// (a path or an http(s) URL), removable media labelled TUINIX-CFG, and
+// answers.* in /iso/tuinix on the boot media. It returns a
+// description of the source for display and the local path to load; an
+// empty source means nothing was found.
+func discoverAnswerFile() (source, path string, err error) {
+	if ref := cmdlineAnswerRef("/proc/cmdline"); ref != "" {
+		source = "kernel command line (" + ref + ")"
+		if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+			return source, discoveredAnswerPath, downloadAnswerFile(ref, discoveredAnswerPath)
+		}
+		return source, ref, nil
+	}
+
+	if source, err := copyAnswerFromMedia(discoveredAnswerPath); source != "" || err != nil {
+		return source, discoveredAnswerPath, err
+	}
+
+	matches, _ := filepath.Glob(isoAnswerGlob)
+	if len(matches) > 0 {
+		return matches[0], matches[0], nil
+	}
+
+	return "", "", nil
+}
+
+// cmdlineAnswerRef returns the value of tuinix.config= from the kernel
+// command line, or "" if it is not set
+func cmdlineAnswerRef(cmdlinePath string) string {
+	data, err := os.ReadFile(cmdlinePath)
+	if err != nil {
+		return ""
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if ref, ok := strings.CutPrefix(field, "tuinix.config="); ok {
+			return ref
+		}
+	}
+	return ""
+}
+
+// downloadAnswerFile fetches an answer file over HTTP. The network may still
+// be coming up this early in boot, so failures are retried for a while.
+func downloadAnswerFile(url, dest string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	var lastErr error
+	for attempt := 1; attempt <= 5; attempt++ {
+		if attempt > 1 {
+			time.Sleep(3 * time.Second)
+		}
+		logInfo("Fetching answer file %s (attempt %d)", url, attempt)
+		resp, err := client.Get(url)
+		if err != nil {
+			lastErr = fmt.Errorf("fetch %s: %w", url, err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("read %s: %w", url, err)
+			continue
+		}
+		if resp.StatusCode != 200 {
+			lastErr = fmt.Errorf("fetch %s: HTTP status %d", url, resp.StatusCode)
+			continue
+		}
+		return os.WriteFile(dest, body, 0600)
+	}
+	logError("downloadAnswerFile: %v", lastErr)
+	return lastErr
+}
+
+// copyAnswerFromMedia finds a removable filesystem labelled TUINIX-CFG,
+// mounting it read-only if needed, and copies its answer file to dest
+func copyAnswerFromMedia(dest string) (string, error) {
+	devices, err := disk.BlockDevices(context.Background(), shell())
+	if err != nil {
+		return "", nil
+	}
+
+	var found *disk.BlockDevice
+	var walk func(d disk.BlockDevice, removable bool)
+	walk = func(d disk.BlockDevice, removable bool) {
+		removable = removable || bool(d.Removable) || bool(d.Hotplug)
+		if found == nil && removable && d.Label == answerMediaLabel {
+			dev := d
+			found = &dev
+		}
+		for _, child := range d.Children {
+			walk(child, removable)
+		}
+	}
+	for _, d := range devices {
+		walk(d, false)
+	}
+	if found == nil {
+		return "", nil
+	}
+
+	source := fmt.Sprintf("%s media (%s)", answerMediaLabel, found.Path)
+	mountpoint := found.Mountpoint
+	if mountpoint == "" {
+		mountpoint = "/run/tuinix-answers"
+		if err := os.MkdirAll(mountpoint, 0755); err != nil {
+			return source, fmt.Errorf("create mountpoint: %w", err)
+		}
+		if _, err := shell().Local(context.Background(), "mount", "-o", "ro", found.Path, mountpoint); err != nil {
+			return source, fmt.Errorf("mount %s: %w", found.Path, err)
+		}
+		defer shell().Local(context.Background(), "umount", mountpoint)
+	}
+
+	for _, rel := range answerMediaPaths {
+		data, err := os.ReadFile(filepath.Join(mountpoint, rel))
+		if err != nil {
+			continue
+		}
+		return source + " " + rel, os.WriteFile(dest, data, 0600)
+	}
+	return source, fmt.Errorf("no %s found on %s", strings.Join(answerMediaPaths, " or "), found.Path)
+}
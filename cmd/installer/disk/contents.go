@@ -0,0 +1,210 @@
+package disk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// GPT partition type GUIDs that tell which operating system made a
+// partition
+const (
+	msBasicDataType = "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"
+	msReservedType  = "E3C9E316-0B5C-4DB8-817D-F92DF00215AE"
+	msRecoveryType  = "DE94BBA4-06D1-4D40-A16A-BFD50179D6AC"
+	appleAPFSType   = "7C3457EF-0000-11AA-AA11-00306543ECAC"
+)
+
+// Contents is what a disk holds, for the confirmation screen
+type Contents struct {
+	Disk   string
+	Model  string
+	Serial string
+	Bytes  int64
+	Items  []Item
+}
+
+// Item is a partition, or a filesystem or volume signature, found on a
+// disk
+type Item struct {
+	Device string
+	Bytes  int64
+	Depth  int    // 0 for the disk's own signature and its partitions, 1 for what is inside them
+	What   string // e.g. `ext4 "nixos"`, "ZFS pool rpool (ONLINE, importable)"
+	OS     string // The operating system it probably belongs to, if that can be told
+}
+
+// Inspect lists every partition, filesystem, ZFS pool member, LUKS
+// header, LVM physical volume and mdraid member on disk. lsblk gives the
+// layout; blkid probes anything udev has not identified, and pools
+// describes the ZFS pools `zpool import` can see (see ImportablePools).
+func Inspect(ctx context.Context, sh system.Shell, disk string, pools map[string]string) (*Contents, error) {
+	d, err := Tree(ctx, sh, disk)
+	if err != nil {
+		return nil, err
+	}
+	c := &Contents{
+		Disk:   disk,
+		Model:  strings.TrimSpace(d.Model),
+		Serial: strings.TrimSpace(d.Serial),
+		Bytes:  int64(d.Size),
+	}
+
+	var walk func(b BlockDevice, depth int)
+	walk = func(b BlockDevice, depth int) {
+		if b.FSType == "" {
+			probe(ctx, sh, &b)
+		}
+		if b.Type != "disk" || b.FSType != "" {
+			c.Items = append(c.Items, Item{
+				Device: b.Path,
+				Bytes:  int64(b.Size),
+				Depth:  depth,
+				What:   describe(ctx, sh, b, pools),
+				OS:     guessOS(b),
+			})
+		}
+		for _, child := range b.Children {
+			childDepth := depth
+			if b.Type != "disk" {
+				childDepth++
+			}
+			walk(child, childDepth)
+		}
+	}
+	walk(*d, 0)
+	return c, nil
+}
+
+// Tree returns disk with everything on it as children: partitions, and
+// the LUKS mappings, LVM volumes and md arrays built on them
+func Tree(ctx context.Context, sh system.Shell, disk string) (*BlockDevice, error) {
+	devices, err := queryBlockDevices(ctx, sh, "NAME,PATH,TYPE,SIZE,MODEL,SERIAL,FSTYPE,LABEL,PARTTYPE,PARTLABEL,MOUNTPOINT", "--bytes", disk)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) != 1 {
+		return nil, fmt.Errorf("lsblk found %d devices for %s", len(devices), disk)
+	}
+	return &devices[0], nil
+}
+
+// probe fills in b's filesystem type and label with blkid, which reads
+// the device itself rather than udev's possibly stale database
+func probe(ctx context.Context, sh system.Shell, b *BlockDevice) {
+	out, err := sh.Query(ctx, "blkid", "-p", "-o", "export", b.Path)
+	if err != nil {
+		return // blkid exits 2 when it finds nothing
+	}
+	for _, line := range strings.Split(out, "\n") {
+		k, v, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch k {
+		case "TYPE":
+			b.FSType = v
+		case "LABEL":
+			b.Label = v
+		}
+	}
+}
+
+// describe says what b is, in the words of the confirmation screen
+func describe(ctx context.Context, sh system.Shell, b BlockDevice, pools map[string]string) string {
+	var what string
+	switch b.FSType {
+	case "":
+		what = "no filesystem"
+		if b.Type != "part" {
+			what = b.Type
+		}
+	case "zfs_member":
+		what = "ZFS pool " + b.Label
+		if state, ok := pools[b.Label]; ok {
+			what += " (" + state + ", importable)"
+		}
+	case "crypto_LUKS":
+		what = "LUKS header"
+	case "LVM2_member":
+		what = "LVM physical volume"
+		if vg, err := sh.Query(ctx, "pvs", "--noheadings", "-o", "vg_name", b.Path); err == nil && strings.TrimSpace(vg) != "" {
+			what += " of volume group " + strings.TrimSpace(vg)
+		}
+	case "linux_raid_member":
+		what = "mdraid member"
+		if b.Label != "" {
+			what += " of " + b.Label
+		}
+	case "swap":
+		what = "swap"
+	default:
+		what = b.FSType
+		if b.Label != "" {
+			what += fmt.Sprintf(" %q", b.Label)
+		}
+	}
+	if b.PartLabel != "" && b.PartLabel != b.Label {
+		what += fmt.Sprintf(", partition %q", b.PartLabel)
+	}
+	return what
+}
+
+// guessOS names the operating system b probably belongs to, from its
+// filesystem, labels and partition type
+func guessOS(b BlockDevice) string {
+	label := strings.ToLower(b.Label + " " + b.PartLabel)
+	switch {
+	case strings.EqualFold(b.PartType, espType):
+		return "EFI boot loaders"
+	case strings.EqualFold(b.PartType, msReservedType), strings.EqualFold(b.PartType, msRecoveryType),
+		b.FSType == "ntfs", b.FSType == "BitLocker",
+		strings.EqualFold(b.PartType, msBasicDataType) && b.FSType != "vfat" && b.FSType != "exfat":
+		return "Windows"
+	case strings.EqualFold(b.PartType, appleAPFSType), b.FSType == "apfs", b.FSType == "hfsplus":
+		return "macOS"
+	case b.FSType == "zfs_member" && b.Label == "NIXROOT":
+		return "tuinix"
+	case b.FSType == "zfs_member" && (b.Label == "rpool" || b.Label == "bpool"):
+		return "Linux (Ubuntu or Proxmox ZFS)"
+	case strings.Contains(label, "nixos"):
+		return "NixOS"
+	case strings.Contains(label, "ubuntu"), strings.Contains(label, "fedora"), strings.Contains(label, "debian"):
+		return "Linux (" + strings.TrimSpace(b.Label+" "+b.PartLabel) + ")"
+	case b.FSType == "zfs_member":
+		return "ZFS"
+	}
+	switch b.FSType {
+	case "ext2", "ext3", "ext4", "xfs", "btrfs", "swap", "crypto_LUKS", "LVM2_member", "linux_raid_member":
+		return "Linux"
+	case "vfat", "exfat":
+		return "removable or shared data"
+	}
+	return ""
+}
+
+// ImportablePools maps the ZFS pools `zpool import` can see on the
+// machine's disks to their state. Pools that are already imported are not
+// listed. Without ZFS tools the map is empty.
+func ImportablePools(ctx context.Context, sh system.Shell) map[string]string {
+	pools := map[string]string{}
+	out, err := sh.Query(ctx, "zpool", "import")
+	if err != nil {
+		return pools
+	}
+	var pool string
+	for _, line := range strings.Split(out, "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "pool":
+			pool = strings.TrimSpace(v)
+		case "state":
+			if pool != "" {
+				pools[pool] = strings.TrimSpace(v)
+			}
+		}
+	}
+	return pools
+}
@@ -0,0 +1,315 @@
+// Package disk discovers the block devices of the machine being installed:
+// the disks that can be installed to, their sizes and any mounted
+// removable media.
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// Info describes a whole disk of the machine
+type Info struct {
+	Path               string // Kernel name, e.g. /dev/nvme0n1
+	Size               string // Human-readable size, e.g. 931.5G
+	Bytes              int64
+	Model              string
+	Serial             string
+	WWN                string
+	Transport          string // nvme, sata, usb, virtio, ...
+	Rotational         bool   // Spinning disk rather than flash
+	LogicalSectorSize  int64
+	PhysicalSectorSize int64
+	ByID               string   // Preferred /dev/disk/by-id link, empty if udev made none
+	Links              []string // Every /dev/disk/by-id and /dev/disk/by-path link
+	FSType             string   // Signature on the whole disk, when it has no partition table
+	Partitions         []BlockDevice
+	Mounts             []string // Mounted filesystems and swap on the disk or its partitions
+	BootMedia          bool     // The live ISO was booted from this disk
+}
+
+// Hidden explains why d is left out of the disks offered for install, or
+// is empty if it can be installed to
+func (d Info) Hidden() string {
+	switch {
+	case d.BootMedia:
+		return "the live ISO was booted from it"
+	case len(d.Mounts) > 0:
+		return "mounted at " + strings.Join(d.Mounts, ", ")
+	}
+	return ""
+}
+
+// StablePath is the name of d that survives reboots and other disks
+// coming and going: its preferred by-id link, else its by-path link, else
+// the kernel name
+func (d Info) StablePath() string {
+	if d.ByID != "" {
+		return d.ByID
+	}
+	for _, link := range d.Links {
+		if strings.HasPrefix(link, "/dev/disk/by-path/") {
+			return link
+		}
+	}
+	return d.Path
+}
+
+// Filesystems lists the filesystems, swap and volume signatures found on
+// d's partitions, or on d itself when it has no partition table
+func (d Info) Filesystems() []string {
+	var found []string
+	if d.FSType != "" {
+		found = append(found, d.FSType)
+	}
+	var walk func(b BlockDevice)
+	walk = func(b BlockDevice) {
+		if b.FSType != "" {
+			found = append(found, b.FSType)
+		}
+		for _, child := range b.Children {
+			walk(child)
+		}
+	}
+	for _, p := range d.Partitions {
+		walk(p)
+	}
+	return found
+}
+
+// diskColumns are the lsblk columns List reads
+const diskColumns = "NAME,PATH,TYPE,SIZE,MODEL,SERIAL,WWN,TRAN,ROTA,LOG-SEC,PHY-SEC,LABEL,FSTYPE,UUID,MOUNTPOINT,RM,HOTPLUG"
+
+// List returns every whole disk of the machine, including those Hidden
+// reports on, from lsblk's JSON output. Loop devices, optical drives,
+// zram swap and empty card readers are left out. Without lsblk there are
+// no disks: List never makes one up.
+func List(ctx context.Context, sh system.Shell) ([]Info, error) {
+	devices, err := queryBlockDevices(ctx, sh, diskColumns, "--bytes")
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []Info
+	for _, b := range devices {
+		if b.Type != "disk" || b.Size == 0 || strings.HasPrefix(b.Name, "zram") {
+			continue
+		}
+		d := Info{
+			Path:               b.Path,
+			Size:               FormatBytes(int64(b.Size)),
+			Bytes:              int64(b.Size),
+			Model:              strings.TrimSpace(b.Model),
+			Serial:             strings.TrimSpace(b.Serial),
+			WWN:                b.WWN,
+			Transport:          b.Transport,
+			Rotational:         bool(b.Rotational),
+			LogicalSectorSize:  int64(b.LogSec),
+			PhysicalSectorSize: int64(b.PhySec),
+			FSType:             b.FSType,
+			Partitions:         b.Children,
+		}
+		if d.Transport == "" && strings.HasPrefix(b.Name, "vd") {
+			d.Transport = "virtio"
+		}
+		var walk func(b BlockDevice)
+		walk = func(b BlockDevice) {
+			if b.Mountpoint != "" {
+				d.Mounts = append(d.Mounts, b.Mountpoint)
+			}
+			if isBootMedia(b) {
+				d.BootMedia = true
+			}
+			for _, child := range b.Children {
+				walk(child)
+			}
+		}
+		walk(b)
+		d.Links = links(ctx, sh, d.Path)
+		d.ByID = preferredByID(d.Links)
+		disks = append(disks, d)
+	}
+	return disks, nil
+}
+
+// isBootMedia reports whether b holds the live ISO: the ISO 9660 image
+// itself, or the filesystems the installer runs from
+func isBootMedia(b BlockDevice) bool {
+	switch b.Mountpoint {
+	case "/iso", "/nix/.ro-store":
+		return true
+	}
+	return b.FSType == "iso9660"
+}
+
+// links returns the /dev/disk/by-id and /dev/disk/by-path symlinks udev
+// made for device
+func links(ctx context.Context, sh system.Shell, device string) []string {
+	out, err := sh.Query(ctx, "udevadm", "info", "--query=symlink", "--name="+device)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, link := range strings.Fields(out) {
+		if strings.HasPrefix(link, "disk/by-id/") || strings.HasPrefix(link, "disk/by-path/") {
+			found = append(found, "/dev/"+link)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// preferredByID picks the by-id link that names the disk by model and
+// serial number (ata-, nvme-, scsi-, usb-, ...), falling back to its WWN
+// or EUI link
+func preferredByID(links []string) string {
+	var best, fallback string
+	for _, link := range links {
+		name, ok := strings.CutPrefix(link, "/dev/disk/by-id/")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(name, "wwn-") || strings.HasPrefix(name, "nvme-eui.") || strings.HasPrefix(name, "nvme-nvme.") {
+			if fallback == "" {
+				fallback = link
+			}
+			continue
+		}
+		// NVMe namespaces get a second link ending in _1; keep the shorter
+		if best == "" || len(link) < len(best) {
+			best = link
+		}
+	}
+	if best == "" {
+		return fallback
+	}
+	return best
+}
+
+// SizeGB returns the size of a disk in GiB. A disk lsblk cannot measure,
+// or one smaller than 1 GiB, is an error.
+func SizeGB(ctx context.Context, sh system.Shell, disk string) (int64, error) {
+	output, err := sh.Query(ctx, "lsblk", "-d", "-n", "-b", "-o", "SIZE", disk)
+	if err != nil {
+		return 0, fmt.Errorf("measure %s: %w", disk, err)
+	}
+	var sizeBytes int64
+	if _, err := fmt.Sscanf(strings.TrimSpace(output), "%d", &sizeBytes); err != nil {
+		return 0, fmt.Errorf("measure %s: lsblk reported size %q", disk, strings.TrimSpace(output))
+	}
+	sizeGB := sizeBytes >> 30
+	if sizeGB == 0 {
+		return 0, fmt.Errorf("measure %s: lsblk reported %d bytes", disk, sizeBytes)
+	}
+	return sizeGB, nil
+}
+
+// BlockDevice is one node of the tree printed by `lsblk --json`. Fields
+// for columns that were not asked for are left empty.
+type BlockDevice struct {
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Type       string        `json:"type"`
+	Size       lsblkInt      `json:"size"`
+	Model      string        `json:"model"`
+	Serial     string        `json:"serial"`
+	WWN        string        `json:"wwn"`
+	Transport  string        `json:"tran"`
+	Rotational lsblkBool     `json:"rota"`
+	LogSec     lsblkInt      `json:"log-sec"`
+	PhySec     lsblkInt      `json:"phy-sec"`
+	Label      string        `json:"label"`
+	PartType   string        `json:"parttype"`
+	PartLabel  string        `json:"partlabel"`
+	FSType     string        `json:"fstype"`
+	UUID       string        `json:"uuid"`
+	Mountpoint string        `json:"mountpoint"`
+	Removable  lsblkBool     `json:"rm"`
+	Hotplug    lsblkBool     `json:"hotplug"`
+	Children   []BlockDevice `json:"children"`
+}
+
+// lsblkBool accepts both the JSON booleans printed by current util-linux
+// and the "0"/"1" strings printed by older releases
+type lsblkBool bool
+
+func (b *lsblkBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true", "1":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// lsblkInt accepts both the JSON numbers printed by current util-linux
+// and the strings printed by older releases
+type lsblkInt int64
+
+func (n *lsblkInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("lsblk number %s: %w", data, err)
+	}
+	*n = lsblkInt(v)
+	return nil
+}
+
+// BlockDevices returns every block device with its partitions as children
+func BlockDevices(ctx context.Context, sh system.Shell) ([]BlockDevice, error) {
+	return queryBlockDevices(ctx, sh, "NAME,PATH,LABEL,FSTYPE,UUID,MOUNTPOINT,RM,HOTPLUG")
+}
+
+// queryBlockDevices runs lsblk --json for columns
+func queryBlockDevices(ctx context.Context, sh system.Shell, columns string, args ...string) ([]BlockDevice, error) {
+	out, err := sh.Query(ctx, "lsblk", append([]string{"--json", "-o", columns}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("lsblk: %w", err)
+	}
+	var parsed struct {
+		Blockdevices []BlockDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal([]byte(out), &parsed); err != nil {
+		return nil, fmt.Errorf("parse lsblk output: %w", err)
+	}
+	return parsed.Blockdevices, nil
+}
+
+// RemovableMounts lists writable mountpoints on removable or hot-plugged
+// media, skipping the live ISO itself
+func RemovableMounts(ctx context.Context, sh system.Shell) []string {
+	devices, err := BlockDevices(ctx, sh)
+	if err != nil {
+		system.LogError("RemovableMounts: %v", err)
+		return nil
+	}
+
+	var mounts []string
+	var walk func(d BlockDevice, removable bool)
+	walk = func(d BlockDevice, removable bool) {
+		removable = removable || bool(d.Removable) || bool(d.Hotplug)
+		if removable && d.Mountpoint != "" && d.Mountpoint != "[SWAP]" &&
+			d.Mountpoint != "/" && d.Mountpoint != "/iso" && d.FSType != "iso9660" {
+			mounts = append(mounts, d.Mountpoint)
+		}
+		for _, child := range d.Children {
+			walk(child, removable)
+		}
+	}
+	for _, d := range devices {
+		walk(d, false)
+	}
+	return mounts
+}
@@ -0,0 +1,201 @@
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// replayShell answers exactly the scripted commands, in order, and fails
+// the test if any of them is never run
+func replayShell(t *testing.T, script ...system.RecordedCommand) system.Shell {
+	t.Helper()
+	r := system.NewReplayRunner(script)
+	t.Cleanup(func() {
+		for _, c := range r.Remaining() {
+			t.Errorf("scripted command never run: %s", c)
+		}
+	})
+	return system.Shell{Runner: r}
+}
+
+// query scripts a read-only command and what it printed
+func query(stdout string, name string, args ...string) system.RecordedCommand {
+	return system.RecordedCommand{
+		Command: system.Command{Name: name, Args: args, Local: true},
+		Result:  system.Result{Stdout: stdout},
+	}
+}
+
+// failing scripts a command that exits with an error
+func failing(name string, args ...string) system.RecordedCommand {
+	return system.RecordedCommand{
+		Command: system.Command{Name: name, Args: args, Local: true},
+		Error:   "exit status 1",
+	}
+}
+
+func TestLsblkInt(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    lsblkInt
+		wantErr bool
+	}{
+		{`512`, 512, false},
+		{`"512"`, 512, false},
+		{`512110190592`, 512110190592, false},
+		{`"512110190592"`, 512110190592, false},
+		{`null`, 0, false},
+		{`""`, 0, false},
+		{`"476.9G"`, 0, true},
+	}
+	for _, tt := range tests {
+		var n lsblkInt
+		err := json.Unmarshal([]byte(tt.json), &n)
+		if (err != nil) != tt.wantErr || n != tt.want {
+			t.Errorf("lsblkInt %s = %d, %v; want %d, error %v", tt.json, n, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLsblkBool(t *testing.T) {
+	tests := []struct {
+		json string
+		want lsblkBool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`"1"`, true},
+		{`"0"`, false},
+		{`1`, true},
+		{`0`, false},
+		{`null`, false},
+	}
+	for _, tt := range tests {
+		b := !tt.want
+		if err := json.Unmarshal([]byte(tt.json), &b); err != nil || b != tt.want {
+			t.Errorf("lsblkBool %s = %v, %v; want %v", tt.json, b, err, tt.want)
+		}
+	}
+}
+
+func TestBlockDevice(t *testing.T) {
+	tests := []struct {
+		name, json string
+		want       BlockDevice
+	}{
+		{
+			name: "util-linux 2.37 and later",
+			json: `{"name":"sda","path":"/dev/sda","type":"disk","size":512110190592,"model":"Samsung SSD 860 ",
+				"serial":"S3Z9NB0K","tran":"sata","rota":false,"log-sec":512,"phy-sec":4096,"rm":false,"hotplug":true,
+				"children":[{"name":"sda1","path":"/dev/sda1","type":"part","size":536870912,"fstype":"vfat",
+				"parttype":"c12a7328-f81f-11d2-ba4b-00a0c93ec93b","mountpoint":null}]}`,
+			want: BlockDevice{
+				Name: "sda", Path: "/dev/sda", Type: "disk", Size: 512110190592, Model: "Samsung SSD 860 ",
+				Serial: "S3Z9NB0K", Transport: "sata", LogSec: 512, PhySec: 4096, Hotplug: true,
+				Children: []BlockDevice{{
+					Name: "sda1", Path: "/dev/sda1", Type: "part", Size: 536870912, FSType: "vfat",
+					PartType: "c12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+				}},
+			},
+		},
+		{
+			name: "older util-linux prints every column as a string",
+			json: `{"name":"vda","path":"/dev/vda","type":"disk","size":"21474836480","rota":"1",
+				"log-sec":"512","phy-sec":"512","rm":"0","hotplug":"0","mountpoint":null}`,
+			want: BlockDevice{
+				Name: "vda", Path: "/dev/vda", Type: "disk", Size: 21474836480, Rotational: true,
+				LogSec: 512, PhySec: 512,
+			},
+		},
+		{
+			name: "columns not asked for",
+			json: `{"name":"sr0","path":"/dev/sr0"}`,
+			want: BlockDevice{Name: "sr0", Path: "/dev/sr0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got BlockDevice
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	lsblk := `{"blockdevices":[
+		{"name":"loop0","path":"/dev/loop0","type":"loop","size":"1073741824"},
+		{"name":"zram0","path":"/dev/zram0","type":"disk","size":"4294967296"},
+		{"name":"sr0","path":"/dev/sr0","type":"rom","size":"0"},
+		{"name":"vda","path":"/dev/vda","type":"disk","size":"21474836480","rota":"1","log-sec":"512","phy-sec":"512",
+			"children":[{"name":"vda1","path":"/dev/vda1","type":"part","size":"536870912","fstype":"vfat","mountpoint":"/iso"}]},
+		{"name":"nvme0n1","path":"/dev/nvme0n1","type":"disk","size":512110190592,"model":"Samsung SSD 980 1TB",
+			"serial":"S64ANS0T123456","tran":"nvme","rota":false,"log-sec":512,"phy-sec":512}
+	]}`
+	sh := replayShell(t,
+		query(lsblk, "lsblk", "--json", "-o", diskColumns, "--bytes"),
+		failing("udevadm", "info", "--query=symlink", "--name=/dev/vda"),
+		query("disk/by-id/nvme-Samsung_SSD_980_1TB_S64ANS0T123456_1 disk/by-id/nvme-eui.002538 "+
+			"disk/by-id/nvme-Samsung_SSD_980_1TB_S64ANS0T123456 disk/by-path/pci-0000:01:00.0-nvme-1",
+			"udevadm", "info", "--query=symlink", "--name=/dev/nvme0n1"),
+	)
+	disks, err := List(context.Background(), sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disks) != 2 {
+		t.Fatalf("List found %d disks, want vda and nvme0n1: %+v", len(disks), disks)
+	}
+
+	vda, nvme := disks[0], disks[1]
+	if vda.Path != "/dev/vda" || vda.Bytes != 20<<30 || !vda.Rotational || vda.Transport != "virtio" || !vda.BootMedia {
+		t.Errorf("vda = %+v", vda)
+	}
+	if got := vda.StablePath(); got != "/dev/vda" {
+		t.Errorf("vda.StablePath() = %s, want the kernel name", got)
+	}
+	if nvme.Model != "Samsung SSD 980 1TB" || nvme.Rotational || nvme.BootMedia {
+		t.Errorf("nvme0n1 = %+v", nvme)
+	}
+	if want := "/dev/disk/by-id/nvme-Samsung_SSD_980_1TB_S64ANS0T123456"; nvme.ByID != want {
+		t.Errorf("nvme0n1.ByID = %s, want %s", nvme.ByID, want)
+	}
+}
+
+func TestSizeGB(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     system.RecordedCommand
+		want    int64
+		wantErr string
+	}{
+		{"whole GiB", query("21474836480\n", "lsblk", "-d", "-n", "-b", "-o", "SIZE", "/dev/vda"), 20, ""},
+		{"rounded down", query("512110190592\n", "lsblk", "-d", "-n", "-b", "-o", "SIZE", "/dev/vda"), 476, ""},
+		{"lsblk fails", failing("lsblk", "-d", "-n", "-b", "-o", "SIZE", "/dev/vda"), 0, "exit status 1"},
+		{"empty card reader", query("0\n", "lsblk", "-d", "-n", "-b", "-o", "SIZE", "/dev/vda"), 0, "0 bytes"},
+		{"no output", query("", "lsblk", "-d", "-n", "-b", "-o", "SIZE", "/dev/vda"), 0, "reported size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SizeGB(context.Background(), replayShell(t, tt.cmd), "/dev/vda")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("SizeGB error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SizeGB = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// espType is the GPT partition type GUID of an EFI system partition
+const espType = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+
+// MinGapBytes is the smallest free space ReadLayout reports. Anything
+// smaller is alignment slack, not room for an operating system.
+const MinGapBytes = 1 << 30
+
+// Layout is a disk's partition table as sfdisk reports it, with the
+// unpartitioned gaps between its partitions
+type Layout struct {
+	Disk       string
+	Label      string // "gpt" or "dos"
+	SectorSize int64
+	Partitions []Partition
+	Gaps       []Gap
+}
+
+// Partition is one entry in a partition table
+type Partition struct {
+	Node    string // e.g. /dev/nvme0n1p1
+	Start   int64  // first sector
+	Sectors int64
+	Type    string // GPT type GUID, or MBR type code
+	Name    string // GPT partition name
+	FSType  string // filesystem, as lsblk reports it
+	Label   string // filesystem label
+	UUID    string // filesystem UUID
+}
+
+// IsESP reports whether p is an EFI system partition
+func (p Partition) IsESP() bool {
+	return strings.EqualFold(p.Type, espType)
+}
+
+// Gap is unpartitioned space, aligned to 1 MiB at both ends
+type Gap struct {
+	Start   int64 // first sector
+	Sectors int64
+}
+
+// Bytes converts a number of l's sectors to bytes
+func (l *Layout) Bytes(sectors int64) int64 {
+	return sectors * l.SectorSize
+}
+
+// ESP returns the disk's first EFI system partition, or nil
+func (l *Layout) ESP() *Partition {
+	for i := range l.Partitions {
+		if l.Partitions[i].IsESP() {
+			return &l.Partitions[i]
+		}
+	}
+	return nil
+}
+
+// Largest returns the biggest gap, or nil if there is none
+func (l *Layout) Largest() *Gap {
+	var best *Gap
+	for i := range l.Gaps {
+		if best == nil || l.Gaps[i].Sectors > best.Sectors {
+			best = &l.Gaps[i]
+		}
+	}
+	return best
+}
+
+// ReadLayout reads disk's partition table with sfdisk --json and works out
+// the gaps of at least MinGapBytes between and after its partitions. A disk
+// with no partition table is an error: there is nothing to install next to.
+func ReadLayout(ctx context.Context, sh system.Shell, disk string) (*Layout, error) {
+	out, err := sh.Query(ctx, "sfdisk", "--json", disk)
+	if err != nil {
+		return nil, fmt.Errorf("read partition table of %s: %w", disk, err)
+	}
+	var parsed struct {
+		PartitionTable struct {
+			Label      string `json:"label"`
+			FirstLBA   int64  `json:"firstlba"`
+			LastLBA    int64  `json:"lastlba"`
+			SectorSize int64  `json:"sectorsize"`
+			Partitions []struct {
+				Node  string `json:"node"`
+				Start int64  `json:"start"`
+				Size  int64  `json:"size"`
+				Type  string `json:"type"`
+				Name  string `json:"name"`
+			} `json:"partitions"`
+		} `json:"partitiontable"`
+	}
+	if err := json.Unmarshal([]byte(out), &parsed); err != nil {
+		return nil, fmt.Errorf("parse sfdisk output for %s: %w", disk, err)
+	}
+	pt := parsed.PartitionTable
+
+	l := &Layout{Disk: disk, Label: pt.Label, SectorSize: pt.SectorSize}
+	if l.SectorSize == 0 {
+		// sfdisk before util-linux 2.38 leaves the sector size out
+		l.SectorSize = 512
+	}
+	for _, p := range pt.Partitions {
+		l.Partitions = append(l.Partitions, Partition{
+			Node: p.Node, Start: p.Start, Sectors: p.Size, Type: p.Type, Name: p.Name,
+		})
+	}
+	sort.Slice(l.Partitions, func(i, j int) bool { return l.Partitions[i].Start < l.Partitions[j].Start })
+
+	// sfdisk only reports the first usable sector of GPT disks
+	first := pt.FirstLBA
+	if first == 0 {
+		first = 2048
+	}
+	last := pt.LastLBA
+	if last == 0 {
+		size, err := sh.Query(ctx, "blockdev", "--getsz", disk)
+		if err != nil {
+			return nil, fmt.Errorf("read size of %s: %w", disk, err)
+		}
+		fmt.Sscanf(strings.TrimSpace(size), "%d", &last)
+		last = last*512/l.SectorSize - 1
+	}
+	l.Gaps = findGaps(l.Partitions, first, last, (1<<20)/l.SectorSize, MinGapBytes/l.SectorSize)
+
+	addFilesystems(ctx, sh, l)
+	return l, nil
+}
+
+// findGaps lists the free runs of sectors between first and last that are
+// not covered by a partition, aligned to align sectors and at least min
+// sectors long
+func findGaps(parts []Partition, first, last, align, min int64) []Gap {
+	var gaps []Gap
+	add := func(start, end int64) { // end is exclusive
+		start = (start + align - 1) / align * align
+		end = end / align * align
+		if end-start >= min {
+			gaps = append(gaps, Gap{Start: start, Sectors: end - start})
+		}
+	}
+	next := first
+	for _, p := range parts {
+		if p.Start > next {
+			add(next, p.Start)
+		}
+		if end := p.Start + p.Sectors; end > next {
+			next = end
+		}
+	}
+	add(next, last+1)
+	return gaps
+}
+
+// addFilesystems fills in the filesystem type, label and UUID of l's
+// partitions from lsblk
+func addFilesystems(ctx context.Context, sh system.Shell, l *Layout) {
+	devices, err := BlockDevices(ctx, sh)
+	if err != nil {
+		system.LogError("ReadLayout: %v", err)
+		return
+	}
+	byPath := map[string]BlockDevice{}
+	var walk func(d BlockDevice)
+	walk = func(d BlockDevice) {
+		byPath[d.Path] = d
+		for _, child := range d.Children {
+			walk(child)
+		}
+	}
+	for _, d := range devices {
+		walk(d)
+	}
+	for i := range l.Partitions {
+		if d, ok := byPath[l.Partitions[i].Node]; ok {
+			l.Partitions[i].FSType = d.FSType
+			l.Partitions[i].Label = d.Label
+			l.Partitions[i].UUID = d.UUID
+		}
+	}
+}
+
+// FormatBytes prints a size the way lsblk does, e.g. 931.5G
+func FormatBytes(b int64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	size := float64(b)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if size == float64(int64(size)) {
+		return fmt.Sprintf("%d%s", int64(size), units[i])
+	}
+	return fmt.Sprintf("%.1f%s", size, units[i])
+}
@@ -0,0 +1,164 @@
+package disk
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+func TestFindGaps(t *testing.T) {
+	const (
+		align = 2048      // 1 MiB of 512-byte sectors
+		min   = 2097152   // 1 GiB
+		last  = 104857566 // Last usable sector of a 50 GiB GPT disk
+	)
+	tests := []struct {
+		name  string
+		parts []Partition
+		want  []Gap
+	}{
+		{
+			name: "empty table",
+			want: []Gap{{Start: 2048, Sectors: 104853504}},
+		},
+		{
+			name:  "disk full",
+			parts: []Partition{{Start: 2048, Sectors: 104855519}},
+		},
+		{
+			name: "room after the last partition, end rounded down to 1 MiB",
+			parts: []Partition{
+				{Start: 2048, Sectors: 1048576},
+				{Start: 1050624, Sectors: 41943040},
+			},
+			want: []Gap{{Start: 42993664, Sectors: 61861888}},
+		},
+		{
+			name: "room between partitions, start rounded up to 1 MiB",
+			parts: []Partition{
+				{Start: 2048, Sectors: 1048577},
+				{Start: 20973568, Sectors: 83881984},
+			},
+			want: []Gap{{Start: 1052672, Sectors: 19920896}},
+		},
+		{
+			name: "slack under 1 GiB is not a gap",
+			parts: []Partition{
+				{Start: 2048, Sectors: 1048576},
+				{Start: 2048000, Sectors: 102807552},
+			},
+		},
+		{
+			name: "overlapping and nested partitions",
+			parts: []Partition{
+				{Start: 2048, Sectors: 20971520},
+				{Start: 4096, Sectors: 2048},
+				{Start: 10000000, Sectors: 20000000},
+			},
+			want: []Gap{{Start: 30001152, Sectors: 74854400}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findGaps(tt.parts, 2048, last, align, min)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findGaps = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLayout(t *testing.T) {
+	lsblk := `{"blockdevices":[{"name":"sda","path":"/dev/sda","children":[
+		{"name":"sda1","path":"/dev/sda1","label":"SYSTEM","fstype":"vfat","uuid":"A1B2-C3D4"},
+		{"name":"sda2","path":"/dev/sda2","fstype":"ntfs","uuid":"01D9E3"}]}]}`
+	blockDevices := query(lsblk, "lsblk", "--json", "-o", "NAME,PATH,LABEL,FSTYPE,UUID,MOUNTPOINT,RM,HOTPLUG")
+
+	tests := []struct {
+		name   string
+		sfdisk string
+		extra  []string // blockdev --getsz output, for sfdisk without lastlba
+		want   Layout
+	}{
+		{
+			name: "GPT, util-linux 2.38",
+			sfdisk: `{"partitiontable":{"label":"gpt","id":"5F1C","device":"/dev/sda","unit":"sectors",
+				"firstlba":34,"lastlba":104857566,"sectorsize":512,"partitions":[
+				{"node":"/dev/sda2","start":1050624,"size":41943040,"type":"EBD0A0A2-B9E5-4433-87C0-68B6B72699C7","name":"Basic data partition"},
+				{"node":"/dev/sda1","start":2048,"size":1048576,"type":"C12A7328-F81F-11D2-BA4B-00A0C93EC93B","name":"EFI system partition"}]}}`,
+			want: Layout{
+				Disk: "/dev/sda", Label: "gpt", SectorSize: 512,
+				Partitions: []Partition{
+					{Node: "/dev/sda1", Start: 2048, Sectors: 1048576, Type: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+						Name: "EFI system partition", FSType: "vfat", Label: "SYSTEM", UUID: "A1B2-C3D4"},
+					{Node: "/dev/sda2", Start: 1050624, Sectors: 41943040, Type: "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7",
+						Name: "Basic data partition", FSType: "ntfs", UUID: "01D9E3"},
+				},
+				Gaps: []Gap{{Start: 42993664, Sectors: 61861888}},
+			},
+		},
+		{
+			name: "MBR from an older sfdisk: no sector size or usable range",
+			sfdisk: `{"partitiontable":{"label":"dos","id":"0x1c2d","device":"/dev/sda","unit":"sectors","partitions":[
+				{"node":"/dev/sda1","start":2048,"size":41943040,"type":"7","bootable":true}]}}`,
+			extra: []string{"104857600\n"},
+			want: Layout{
+				Disk: "/dev/sda", Label: "dos", SectorSize: 512,
+				Partitions: []Partition{
+					{Node: "/dev/sda1", Start: 2048, Sectors: 41943040, Type: "7", FSType: "vfat", Label: "SYSTEM", UUID: "A1B2-C3D4"},
+				},
+				Gaps: []Gap{{Start: 41945088, Sectors: 62912512}},
+			},
+		},
+		{
+			name: "4Kn disk",
+			sfdisk: `{"partitiontable":{"label":"gpt","device":"/dev/sda","unit":"sectors",
+				"firstlba":6,"lastlba":13107194,"sectorsize":4096,"partitions":[
+				{"node":"/dev/sda1","start":256,"size":131072,"type":"C12A7328-F81F-11D2-BA4B-00A0C93EC93B"}]}}`,
+			want: Layout{
+				Disk: "/dev/sda", Label: "gpt", SectorSize: 4096,
+				Partitions: []Partition{
+					{Node: "/dev/sda1", Start: 256, Sectors: 131072, Type: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+						FSType: "vfat", Label: "SYSTEM", UUID: "A1B2-C3D4"},
+				},
+				Gaps: []Gap{{Start: 131328, Sectors: 12975616}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := []system.RecordedCommand{query(tt.sfdisk, "sfdisk", "--json", "/dev/sda")}
+			for _, out := range tt.extra {
+				script = append(script, query(out, "blockdev", "--getsz", "/dev/sda"))
+			}
+			script = append(script, blockDevices)
+
+			got, err := ReadLayout(context.Background(), replayShell(t, script...), "/dev/sda")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ReadLayout = %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLayoutErrors(t *testing.T) {
+	for name, sh := range map[string]func(t *testing.T) system.Shell{
+		"no partition table": func(t *testing.T) system.Shell {
+			return replayShell(t, failing("sfdisk", "--json", "/dev/sda"))
+		},
+		"not JSON": func(t *testing.T) system.Shell {
+			return replayShell(t, query("sfdisk: cannot open /dev/sda", "sfdisk", "--json", "/dev/sda"))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if l, err := ReadLayout(context.Background(), sh(t), "/dev/sda"); err == nil {
+				t.Errorf("ReadLayout = %+v, want an error", l)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// ATA SMART attributes that count sectors the disk has given up on
+const (
+	ataReallocated   = 5
+	ataPending       = 197
+	ataUncorrectable = 198
+)
+
+// SMART is the health a disk reports about itself
+type SMART struct {
+	Passed          bool  // The disk's own overall verdict
+	Reallocated     int64 // Sectors remapped to spares after failing (ATA)
+	Pending         int64 // Unreadable sectors waiting to be remapped (ATA)
+	Uncorrectable   int64 // Sectors lost in offline scans (ATA)
+	MediaErrors     int64 // Unrecovered data integrity errors (NVMe)
+	CriticalWarning int64 // Bit field of spare, temperature, reliability and read-only warnings (NVMe)
+	PercentUsed     int64 // Share of the rated write endurance used up (NVMe)
+}
+
+// smartctlOutput is the part of `smartctl -j` output ReadSMART uses
+type smartctlOutput struct {
+	Smartctl struct {
+		Messages []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	Status *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATA struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMe struct {
+		CriticalWarning int64 `json:"critical_warning"`
+		PercentUsed     int64 `json:"percentage_used"`
+		MediaErrors     int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// ReadSMART asks disk for its SMART health with smartctl. It returns nil
+// without an error when smartctl is not installed, and an error when the
+// disk reports no health, as virtual disks and many USB bridges do.
+func ReadSMART(ctx context.Context, sh system.Shell, disk string) (*SMART, error) {
+	// smartctl's exit status is a bit field that is non-zero for failing
+	// disks too, so the JSON is read whatever it is
+	out, err := sh.Query(ctx, "smartctl", "-j", "-H", "-A", disk)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, nil
+	}
+	var o smartctlOutput
+	if jerr := json.Unmarshal([]byte(out), &o); jerr != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("parse smartctl output: %w", jerr)
+	}
+	if o.Status == nil {
+		var msgs []string
+		for _, m := range o.Smartctl.Messages {
+			msgs = append(msgs, m.String)
+		}
+		if len(msgs) == 0 {
+			msgs = append(msgs, "no SMART status")
+		}
+		return nil, fmt.Errorf("%s: %s", disk, strings.Join(msgs, "; "))
+	}
+
+	s := &SMART{
+		Passed:          o.Status.Passed,
+		MediaErrors:     o.NVMe.MediaErrors,
+		CriticalWarning: o.NVMe.CriticalWarning,
+		PercentUsed:     o.NVMe.PercentUsed,
+	}
+	for _, attr := range o.ATA.Table {
+		switch attr.ID {
+		case ataReallocated:
+			s.Reallocated = attr.Raw.Value
+		case ataPending:
+			s.Pending = attr.Raw.Value
+		case ataUncorrectable:
+			s.Uncorrectable = attr.Raw.Value
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
+)
+
+// runDryRun collects a configuration like --plain does, generates the host
+// flake into outputDir and prints the commands a real install would run.
+// No disk is touched and nothing is written outside outputDir.
+func runDryRun(answers *config.Answers, outputDir string) error {
+	if outputDir == "" {
+		return fmt.Errorf("--dry-run needs --output DIR")
+	}
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return fmt.Errorf("resolve output dir: %w", err)
+	}
+	// The installer starts by clearing its work directory, so never
+	// point it at a directory that already holds something
+	if entries, err := os.ReadDir(outputDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("output dir %s is not empty", outputDir)
+	}
+
+	if answers == nil {
+		answers = &config.Answers{}
+	}
+	p := &plainSession{
+		in:      bufio.NewReader(os.Stdin),
+		out:     os.Stdout,
+		answers: answers,
+	}
+
+	dryRun = true
+	c := defaultConfig()
+	c.WorkDir = outputDir
+	answers.ApplyDefaults(&c)
+	if err := p.ask(&c); err != nil {
+		return err
+	}
+	p.printSummary(c)
+
+	p.logf("Dry run: generating configuration in %s", outputDir)
+	installEvents.subscribe(func(ev pipeline.Event) {
+		if ev.Type == pipeline.EventStepStarted {
+			p.logf("[%d/%d] %s", ev.Index, ev.Total, ev.Name)
+		}
+	})
+	installer := newInstaller()
+	if err := installer.Run(context.Background(), c); err != nil {
+		return err
+	}
+
+	hostDir := filepath.Join(outputDir, "hosts", c.Hostname)
+	p.printf("\nGenerated files:\n")
+	for _, f := range []string{
+		filepath.Join(hostDir, "default.nix"),
+		filepath.Join(hostDir, "disks.nix"),
+		filepath.Join(hostDir, "hardware.nix"),
+		filepath.Join(outputDir, "users", c.Username+".nix"),
+	} {
+		p.printf("  %s\n", f)
+	}
+
+	p.printf("\nCommands a real install would run:\n")
+	for i, cmd := range installer.Planned() {
+		p.printf("  %3d. %s\n", i+1, cmd)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
+)
+
+// eventBus fans installation events out to every subscriber: the TUI, the
+// plain mode output and the --events stream
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers []func(pipeline.Event)
+}
+
+var installEvents = &eventBus{}
+
+func (b *eventBus) subscribe(fn func(pipeline.Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+func (b *eventBus) emit(ev pipeline.Event) {
+	b.mu.Lock()
+	subscribers := append([]func(pipeline.Event){}, b.subscribers...)
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+}
+
+// openEventStream opens the --events target: "fd:N" for an inherited file
+// descriptor, "unix:PATH" to connect to a listening unix socket, or a plain
+// path to a file that is created or appended to
+func openEventStream(target string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasPrefix(target, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor in %q", target)
+		}
+		return os.NewFile(uintptr(fd), target), nil
+	case strings.HasPrefix(target, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+		if err != nil {
+			return nil, fmt.Errorf("connect to event socket: %w", err)
+		}
+		return conn, nil
+	default:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open event file: %w", err)
+		}
+		return f, nil
+	}
+}
+
+// streamEvents writes every event to w as a JSON line
+func streamEvents(w io.Writer) {
+	enc := json.NewEncoder(w)
+	installEvents.subscribe(func(ev pipeline.Event) {
+		if err := enc.Encode(ev); err != nil {
+			logError("Writing progress event failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+)
+
+// readFreeSpace returns the layout of path if tuinix can be installed next
+// to what is already on it: a GPT disk with an EFI system partition and at
+// least one gap of config.MinAlongsideGB. Only those gaps are kept. It
+// returns nil, logging why, when the disk can only be used whole.
+func readFreeSpace(path string) *disk.Layout {
+	l, err := disk.ReadLayout(context.Background(), shell(), path)
+	if err != nil {
+		logInfo("No free-space install on %s: %v", path, err)
+		return nil
+	}
+	if l.Label != "gpt" {
+		logInfo("No free-space install on %s: partition table is %s, not gpt", path, l.Label)
+		return nil
+	}
+	if l.ESP() == nil {
+		logInfo("No free-space install on %s: no EFI system partition", path)
+		return nil
+	}
+	var gaps []disk.Gap
+	for _, g := range l.Gaps {
+		if l.Bytes(g.Sectors)>>30 >= config.MinAlongsideGB {
+			gaps = append(gaps, g)
+		}
+	}
+	if len(gaps) == 0 {
+		logInfo("No free-space install on %s: no gap of %d GiB or more", path, config.MinAlongsideGB)
+		return nil
+	}
+	l.Gaps = gaps
+	return l
+}
+
+// freeSpaceChoices lists the options of the free-space step: the whole
+// disk first, then each gap in l
+func freeSpaceChoices(l *disk.Layout) []string {
+	choices := []string{"Use the whole disk (erases everything on it)"}
+	for _, g := range l.Gaps {
+		choices = append(choices, fmt.Sprintf("Free space: %s at %s",
+			disk.FormatBytes(l.Bytes(g.Sectors)), disk.FormatBytes(l.Bytes(g.Start))))
+	}
+	return choices
+}
+
+// largestGapChoice is the index in freeSpaceChoices of l's biggest gap
+func largestGapChoice(l *disk.Layout) int {
+	largest := l.Largest()
+	for i := range l.Gaps {
+		if &l.Gaps[i] == largest {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// newAlongside sets up an install into gap g of l, sharing l's ESP
+func newAlongside(l *disk.Layout, g disk.Gap) *config.Alongside {
+	esp := l.ESP()
+	a := &config.Alongside{
+		Start:      g.Start,
+		Sectors:    g.Sectors,
+		SectorSize: l.SectorSize,
+		PartUUID:   config.NewPartUUID(),
+		ESP:        esp.Node,
+		ESPUUID:    esp.UUID,
+		ESPBytes:   l.Bytes(esp.Sectors),
+	}
+	for _, p := range l.Partitions {
+		desc := describePartition(l, p)
+		if p.IsESP() {
+			desc += "  (mounted as /boot, not formatted)"
+		}
+		a.Keep = append(a.Keep, desc)
+	}
+	return a
+}
+
+// describePartition prints one line about p for the free-space and
+// confirmation screens
+func describePartition(l *disk.Layout, p disk.Partition) string {
+	var what []string
+	for _, s := range []string{p.FSType, p.Label, p.Name} {
+		if s != "" {
+			what = append(what, s)
+		}
+	}
+	if len(what) == 0 {
+		what = append(what, "unknown contents")
+	}
+	return fmt.Sprintf("%-16s %8s  %s", p.Node, disk.FormatBytes(l.Bytes(p.Sectors)), strings.Join(what, ", "))
+}
+
+// confirmWord is what the user types on the confirmation screen. Nothing
+// is destroyed by an install into free space, so it asks for INSTALL.
+func confirmWord(c config.Config) string {
+	if c.Alongside != nil {
+		return "INSTALL"
+	}
+	return "DESTROY"
+}
+
+// alongsidePlan lists the partitions an install into free space creates
+// and the ones it leaves alone
+func alongsidePlan(c config.Config) (create, keep []string) {
+	a := c.Alongside
+	create = []string{fmt.Sprintf("%-16s %8s  %s", "new partition",
+		disk.FormatBytes(a.Sectors*a.SectorSize), c.StorageMode)}
+	return create, a.Keep
+}
+
+// diskSummary names the disk(s) an install uses
+func diskSummary(c config.Config) string {
+	if c.StorageMode.IsMultiDisk() {
+		return strings.Join(c.Disks, ", ")
+	}
+	if a := c.Alongside; a != nil {
+		return fmt.Sprintf("%s (free space, %s)", c.Disk, disk.FormatBytes(a.Sectors*a.SectorSize))
+	}
+	return c.Disk
+}
+
+// bootSummary describes where /boot lives
+func bootSummary(c config.Config) string {
+	if a := c.Alongside; a != nil {
+		return fmt.Sprintf("existing ESP %s (%s, shared)", a.ESP, disk.FormatBytes(a.ESPBytes))
+	}
+	if n := len(c.ESPDisks()); n > 1 {
+		return fmt.Sprintf("%s on each of %d disks, kept in sync by GRUB", c.SpaceBoot, n)
+	}
+	return c.SpaceBoot
+}
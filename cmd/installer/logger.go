@@ -3,31 +3,29 @@ package main
 import (
 	"log"
 	"os"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
 )
 
 // Log file for installation diagnostics
 const logFile = "/tmp/tuinix-install.log"
 
-var logger *log.Logger
-
+// initLogger sends the log of the installer and its engine packages to
+// logFile
 func initLogger() {
 	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		// Fall back to stderr if we can't create log file
-		logger = log.New(os.Stderr, "[tuinix] ", log.LstdFlags)
+		system.Logger = log.New(os.Stderr, "[tuinix] ", log.LstdFlags)
 		return
 	}
-	logger = log.New(f, "", log.LstdFlags)
+	system.Logger = log.New(f, "", log.LstdFlags)
 }
 
 func logInfo(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Printf("[INFO] "+format, args...)
-	}
+	system.LogInfo(format, args...)
 }
 
 func logError(format string, args ...interface{}) {
-	if logger != nil {
-		logger.Printf("[ERROR] "+format, args...)
-	}
+	system.LogError(format, args...)
 }
@@ -1,31 +1,145 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
 	"os"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/system"
 )
 
 func main() {
+	configPath := flag.String("config", "", "answer file (JSON) for unattended installs; missing answers are asked interactively")
+	plain := flag.Bool("plain", false, "ask questions as numbered line prompts instead of the full-screen TUI (for serial consoles and logs)")
+	dryRunFlag := flag.Bool("dry-run", false, "generate the host flake into --output and print the install commands without running them")
+	outputDir := flag.String("output", "", "directory for the generated flake in --dry-run mode (must be empty)")
+	eventsTarget := flag.String("events", "", "write JSON-lines progress events to fd:N, unix:SOCKET or a file path")
+	recordPath := flag.String("record", "", "record every system command with its stdin, environment and output to this file (contains secrets)")
+	replayPath := flag.String("replay", "", "answer system commands from a recording instead of running them")
+	flag.BoolVar(&allDisks, "all-disks", false, "offer disks with mounted filesystems and the live ISO's own disk for install too")
+	flag.Parse()
+
 	initLogger()
 	logInfo("tuinix installer started")
 
-	if os.Geteuid() != 0 {
+	if *eventsTarget != "" {
+		w, err := openEventStream(*eventsTarget)
+		if err != nil {
+			logError("Event stream: %v", err)
+			fmt.Println(errorStyle.Render("! " + err.Error()))
+			os.Exit(1)
+		}
+		defer w.Close()
+		streamEvents(w)
+	}
+
+	var replay *system.ReplayRunner
+	if *replayPath != "" {
+		script, err := system.LoadReplayScript(*replayPath)
+		if err != nil {
+			fmt.Println(errorStyle.Render("! " + err.Error()))
+			os.Exit(1)
+		}
+		replay = system.NewReplayRunner(script)
+		runner = replay
+		logInfo("Replaying %d commands from %s", len(script), *replayPath)
+	} else if *recordPath != "" {
+		f, err := os.OpenFile(*recordPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Println(errorStyle.Render("! " + err.Error()))
+			os.Exit(1)
+		}
+		defer f.Close()
+		runner = system.NewRecordingRunner(runner, f)
+		logInfo("Recording commands to %s", *recordPath)
+	}
+
+	var answers *config.Answers
+	if *configPath != "" {
+		var err error
+		answers, err = config.LoadAnswers(*configPath)
+		if err != nil {
+			logError("Answer file rejected: %v", err)
+			fmt.Println(errorStyle.Render("! " + err.Error()))
+			os.Exit(1)
+		}
+		logInfo("Loaded answer file %s", *configPath)
+	}
+
+	// A dry run never touches the disks, so it does not need root
+	if *dryRunFlag {
+		if err := runDryRun(answers, *outputDir); err != nil {
+			logError("Dry run failed: %v", err)
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// A replay runs no real commands, so it does not need root either
+	if os.Geteuid() != 0 && replay == nil {
 		fmt.Println(errorStyle.Render("! This installer must be run as root"))
 		fmt.Println(grayStyle.Render("  Use: sudo installer"))
 		os.Exit(1)
 	}
 
+	// Without --config, look for an answer file on the kernel command line,
+	// labelled USB media or the ISO itself
+	var answerSource string
+	var answerErr error
+	if answers == nil {
+		var path string
+		answerSource, path, answerErr = discoverAnswerFile()
+		if answerSource != "" && answerErr == nil {
+			answers, answerErr = config.LoadAnswers(path)
+		}
+		if answerErr != nil {
+			logError("Discovered answer file from %s not used: %v", answerSource, answerErr)
+			answers = nil
+		} else if answerSource != "" {
+			logInfo("Using answer file from %s", answerSource)
+		}
+	}
+
+	if *plain {
+		if answerSource != "" {
+			fmt.Printf("Answer file: %s\n", answerSource)
+			if answerErr != nil {
+				fmt.Printf("! Answer file not used: %v\n", answerErr)
+			}
+		}
+		if err := runPlain(answers); err != nil {
+			logError("Plain install failed: %v", err)
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		replay.Report()
+		logInfo("Installer finished")
+		return
+	}
+
 	rand.Seed(time.Now().UnixNano())
 
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	m := initialModel()
+	m.answers = answers
+	m.answerSource = answerSource
+	m.answerErr = answerErr
+	answers.ApplyDefaults(&m.config)
+
+	// The installer handles signals itself, so that stopping an install
+	// asks first and cleans up (see requestStop)
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithoutSignalHandler())
+	forwardSignals(p)
 	if _, err := p.Run(); err != nil {
 		logError("Program error: %v", err)
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	replay.Report()
 	logInfo("Installer finished")
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -13,6 +14,9 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/harmonica"
 	"golang.org/x/term"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
 )
 
 // Spring animation constants
@@ -30,29 +34,13 @@ func initialModel() model {
 
 	vp := viewport.New(40, 10)
 
-	// Find project root
-	projectRoot := findProjectRoot()
-
 	m := model{
 		state:    stateFireTransition,
 		input:    ti,
 		viewport: vp,
-		locales:  []string{"en_US.UTF-8", "en_GB.UTF-8", "pt_PT.UTF-8", "pt_BR.UTF-8", "de_DE.UTF-8", "fr_FR.UTF-8", "es_ES.UTF-8"},
-		keymaps: []keymapEntry{
-			{Label: "us", XKBLayout: "us", ConsoleMap: "us"},
-			{Label: "uk", XKBLayout: "gb", ConsoleMap: "uk"},
-			{Label: "pt", XKBLayout: "pt", ConsoleMap: "pt-latin1"},
-			{Label: "br", XKBLayout: "br", ConsoleMap: "br-abnt2"},
-			{Label: "de", XKBLayout: "de", ConsoleMap: "de-latin1"},
-			{Label: "fr", XKBLayout: "fr", ConsoleMap: "fr-latin1"},
-			{Label: "es", XKBLayout: "es", ConsoleMap: "es"},
-		},
-		config: Config{
-			ZFSPoolName: "NIXROOT",
-			SpaceBoot:   "5G",
-			ProjectRoot: projectRoot,
-			WorkDir:     "/tmp/tuinix-install",
-		},
+		locales:  config.Locales,
+		keymaps:  config.Keymaps,
+		config:   defaultConfig(),
 	}
 
 	// Get terminal size
@@ -80,6 +68,17 @@ func initialModel() model {
 	return m
 }
 
+// defaultConfig returns the settings every install starts from, before
+// any answers are filled in
+func defaultConfig() config.Config {
+	return config.Config{
+		ZFSPoolName: "NIXROOT",
+		SpaceBoot:   "5G",
+		ProjectRoot: findProjectRoot(),
+		WorkDir:     "/tmp/tuinix-install",
+	}
+}
+
 func findProjectRoot() string {
 	locations := []string{
 		"/home/tuinix",
@@ -110,19 +109,50 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.confirmStop {
+			return m.handleStopKey(msg.String())
+		}
+		if m.state == stateSizes {
+			if next, ok := m.handleSizeKey(msg.String()); ok {
+				return next, nil
+			}
+		}
+		if m.state == stateDatasets {
+			if next, ok := m.handleDatasetKey(msg.String()); ok {
+				return next, nil
+			}
+		}
 		switch msg.String() {
 		case "ctrl+c":
-			return m, tea.Quit
+			return m.requestStop()
 		case "q":
 			// Only allow q to quit on non-input screens (splash, disk selection, locale, keymap, ssh, summary, complete, error)
 			// Text input states must pass q through to the input field
 			switch m.state {
-			case stateSplash, stateNetworkCheck, stateDisk, stateDiskMulti, stateLocale, stateKeymap, stateSSH, stateSummary, stateStorageMode, stateComplete, stateError:
+			case stateSplash, stateNetworkCheck, stateDisk, stateFreeSpace, stateDiskMulti, stateBootDisks, stateLocale, stateKeymap, stateSSH, stateSummary, stateStorageMode, stateComplete, stateError, stateResume:
 				return m, tea.Quit
 			}
 		case "enter":
 			if m.state != stateFireTransition && m.state != stateGravityOut && m.state != stateSplash {
-				return m.handleEnter()
+				next, enterCmd := m.handleEnter()
+				m, cmd = next.(model).applyAnswers()
+				return m, tea.Batch(enterCmd, cmd)
+			}
+		case "e":
+			// Export the reviewed configuration as an answer file
+			if m.state == stateSummary {
+				m.state = stateExport
+				m.err = nil
+				m.input.SetValue(defaultExportPath(m.config.Hostname))
+				m.input.Placeholder = "Path to save the answer file"
+				m.input.CursorEnd()
+				return m, nil
+			}
+		case "esc":
+			if m.state == stateExport {
+				m.state = stateSummary
+				m.err = nil
+				return m, nil
 			}
 		case " ":
 			// Space toggles disk selection in multi-disk mode
@@ -130,7 +160,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.diskSelected[m.selectedIdx] = !m.diskSelected[m.selectedIdx]
 			}
 		case "up", "k":
-			if m.state == stateDisk || m.state == stateDiskMulti || m.state == stateLocale || m.state == stateKeymap || m.state == stateSSH || m.state == stateStorageMode {
+			if m.state == stateDisk || m.state == stateFreeSpace || m.state == stateDiskMulti || m.state == stateBootDisks || m.state == stateLocale || m.state == stateKeymap || m.state == stateSSH || m.state == stateStorageMode || m.state == stateResume {
 				if m.selectedIdx > 0 {
 					m.selectedIdx--
 				}
@@ -138,15 +168,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "down", "j":
 			if m.state == stateDisk && m.selectedIdx < len(m.disks)-1 {
 				m.selectedIdx++
+			} else if m.state == stateFreeSpace && m.selectedIdx < len(m.layout.Gaps) {
+				m.selectedIdx++
 			} else if m.state == stateDiskMulti && m.selectedIdx < len(m.disks)-1 {
 				m.selectedIdx++
 			} else if m.state == stateLocale && m.selectedIdx < len(m.locales)-1 {
 				m.selectedIdx++
 			} else if m.state == stateKeymap && m.selectedIdx < len(m.keymaps)-1 {
 				m.selectedIdx++
-			} else if m.state == stateSSH && m.selectedIdx < 1 {
+			} else if (m.state == stateSSH || m.state == stateResume || m.state == stateBootDisks) && m.selectedIdx < 1 {
 				m.selectedIdx++
-			} else if m.state == stateStorageMode && m.selectedIdx < len(storageModes)-1 {
+			} else if m.state == stateStorageMode && m.selectedIdx < len(config.StorageModes)-1 {
 				m.selectedIdx++
 			}
 		}
@@ -160,36 +192,56 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tickMsg:
 		return m.handleTick()
 
-	case installStepMsg:
-		m.installLog = append(m.installLog, msg.msg)
-		m.installStep = msg.step
+	case signalMsg:
+		return m.requestStop()
+
+	case installEventMsg:
+		switch msg.Type {
+		case pipeline.EventStepStarted:
+			m.installStep = msg.Index - 1
+			m.installStepName = msg.Name
+			m.installDestructive = msg.Destructive
+		case pipeline.EventStepFinished, pipeline.EventStepSkipped:
+			m.installStep = msg.Index
+			m.installDestructive = false
+		}
+		if m.state == stateInstalling {
+			return m, waitForInstallEvent(m.installEvents)
+		}
 		return m, nil
 
 	case installDoneMsg:
+		m.confirmStop, m.stopping = false, false
 		m.state = stateComplete
 		return m, nil
 
 	case installErrMsg:
+		m.confirmStop, m.stopping = false, false
 		m.installErr = msg.err
+		m.resume = resumableInstall()
 		m.state = stateError
 		return m, nil
 
 	case networkCheckMsg:
 		m.networkOk = msg.ok
 		if msg.ok {
+			if m.resume = resumableInstall(); m.resume != nil {
+				m.state = stateResume
+				m.selectedIdx = 0
+				return m, tick()
+			}
 			m.state = stateUsername
 			m.input.Placeholder = "e.g., john, alice"
 			m.input.SetValue("")
+			m, cmd = m.applyAnswers()
+			return m, tea.Batch(tick(), cmd)
 		}
 		return m, tick()
 
 	case logTailMsg:
 		m.logTail = msg.lines
-		if msg.currentStep >= 0 {
-			m.installStep = msg.currentStep
-		}
 		if m.state == stateInstalling {
-			return m, pollLogTail(m.config.StorageMode.isZFS())
+			return m, pollLogTail()
 		}
 		return m, nil
 	}
@@ -200,11 +252,16 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.state == stateHostname ||
 		m.state == statePassphrase || m.state == statePassphraseConfirm ||
 		m.state == stateGitHubUser ||
-		m.state == stateConfirm ||
-		m.state == stateStorageMode || m.state == stateDiskMulti {
+		m.state == stateExport || m.state == stateConfirm || m.state == stateResumePassphrase ||
+		m.state == stateStorageMode || m.state == stateDiskMulti || m.state == stateSizes || m.state == stateDatasets {
 		m.input, cmd = m.input.Update(msg)
 		cmds = append(cmds, cmd)
 	}
+	if m.state == stateSizes {
+		// Check the sizes as they are typed
+		m.config.SetSize(m.sizeField(), m.input.Value())
+		m.err = m.config.ValidateSizes()
+	}
 
 	// Update viewport for scrollable description
 	m.viewport, cmd = m.viewport.Update(msg)
@@ -294,7 +351,7 @@ func (m model) handleTick() (tea.Model, tea.Cmd) {
 		return m, tick()
 
 	case stateInstalling:
-		return m, tea.Batch(tick(), pollLogTail(m.config.StorageMode.isZFS()))
+		return m, tea.Batch(tick(), pollLogTail())
 
 	default:
 		// For wizard states, update spring animations
@@ -336,6 +393,11 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 	switch m.state {
 	case stateNetworkCheck:
 		if m.networkOk {
+			if m.resume = resumableInstall(); m.resume != nil {
+				m.state = stateResume
+				m.selectedIdx = 0
+				return m, nil
+			}
 			m.state = stateUsername
 			m.input.Placeholder = "e.g., john, alice"
 			m.input.SetValue("")
@@ -347,8 +409,8 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case stateUsername:
 		val := strings.TrimSpace(m.input.Value())
-		if !isValidUsername(val) {
-			m.err = fmt.Errorf("invalid username: use lowercase letters, numbers, underscores, hyphens")
+		if err := config.ValidateUsername(val); err != nil {
+			m.err = err
 			return m, nil
 		}
 		m.config.Username = val
@@ -359,8 +421,8 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case stateFullname:
 		val := strings.TrimSpace(m.input.Value())
-		if val == "" {
-			m.err = fmt.Errorf("full name is required")
+		if err := config.ValidateFullname(val); err != nil {
+			m.err = err
 			return m, nil
 		}
 		m.config.Fullname = val
@@ -371,8 +433,8 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case stateEmail:
 		val := strings.TrimSpace(m.input.Value())
-		if !isValidEmail(val) {
-			m.err = fmt.Errorf("please enter a valid email address")
+		if err := config.ValidateEmail(val); err != nil {
+			m.err = err
 			return m, nil
 		}
 		m.config.Email = val
@@ -385,8 +447,8 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case statePassword:
 		val := m.input.Value()
-		if len(val) < 8 {
-			m.err = fmt.Errorf("password must be at least 8 characters")
+		if err := config.ValidatePassword(val); err != nil {
+			m.err = err
 			return m, nil
 		}
 		m.config.Password = val // pragma: allowlist secret
@@ -411,8 +473,8 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case stateHostname:
 		val := strings.TrimSpace(m.input.Value())
-		if !isValidHostname(val) {
-			m.err = fmt.Errorf("invalid hostname: use letters, numbers, and hyphens only")
+		if err := config.ValidateHostname(val); err != nil {
+			m.err = err
 			return m, nil
 		}
 		m.config.Hostname = val
@@ -421,14 +483,24 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 		m.selectedIdx = 0
 
 	case stateStorageMode:
-		mode := storageModes[m.selectedIdx]
+		mode := config.StorageModes[m.selectedIdx]
 		m.config.StorageMode = mode
-		m.disks = getAvailableDisks()
+		disks, hidden, err := getAvailableDisks()
+		if err != nil {
+			m.err = err
+			return m, nil
+		}
+		m.disks, m.hiddenDisks = disks, hidden
+		m.diskChecks, m.checkedDisks = nil, ""
 		m.selectedIdx = 0
 		m.err = nil
-		if mode.isMultiDisk() {
-			if len(m.disks) < mode.minDisks() {
-				m.err = fmt.Errorf("%s requires at least %d disks, but only %d found", mode, mode.minDisks(), len(m.disks))
+		if len(m.disks) == 0 {
+			m.err = fmt.Errorf("no disk to install to was found%s", hiddenSummary(hidden))
+			return m, nil
+		}
+		if mode.IsMultiDisk() {
+			if len(m.disks) < mode.MinDisks() {
+				m.err = fmt.Errorf("%s requires at least %d disks, but only %d found%s", mode, mode.MinDisks(), len(m.disks), hiddenSummary(hidden))
 				return m, nil
 			}
 			m.diskSelected = make([]bool, len(m.disks))
@@ -439,25 +511,38 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 
 	case stateDisk:
 		if len(m.disks) > 0 {
+			var ok bool
+			if m, ok = m.checkSelection(m.selectedDiskInfos()); !ok {
+				return m, nil
+			}
 			m.config.Disk = m.disks[m.selectedIdx].Path
 			m.config.Disks = []string{m.config.Disk}
-			m.config.HostID = generateHostID()
-			if m.config.StorageMode.isEncrypted() {
-				m.state = statePassphrase
-				m.input.SetValue("")
-				m.input.Placeholder = "Enter ZFS encryption passphrase"
-				m.input.EchoMode = textinput.EchoPassword
-				m.input.EchoCharacter = '*'
-			} else {
-				// XFS mode: skip passphrase, go to locale
-				m.state = stateLocale
-				m.input.SetValue("")
-				m.input.EchoMode = textinput.EchoNormal
-				m.input.EchoCharacter = 0
+			m.config.DiskLinks = diskLinks(m.disks, m.config.Disks)
+			m.config.HostID = config.GenerateHostID()
+			m.config.Alongside = nil
+			// Offer to install next to what is on the disk, if there is room
+			if m.layout = readFreeSpace(m.config.Disk); m.layout != nil {
+				m.state = stateFreeSpace
 				m.selectedIdx = 0
+				return m, nil
+			}
+			if m.answers != nil && m.answers.FreeSpace {
+				// Never fall back to erasing a disk the answer file wanted kept
+				m.err = fmt.Errorf("%s has no free space to install into (see the install log)", m.config.Disk)
+				return m, nil
 			}
+			m.err = nil
+			m = m.afterDisk()
 		}
 
+	case stateFreeSpace:
+		m.config.Alongside = nil
+		if m.selectedIdx > 0 {
+			m.config.Alongside = newAlongside(m.layout, m.layout.Gaps[m.selectedIdx-1])
+			logInfo("Installing into free space on %s at sector %d", m.config.Disk, m.config.Alongside.Start)
+		}
+		m = m.afterDisk()
+
 	case stateDiskMulti:
 		var selectedDisks []string
 		for i, sel := range m.diskSelected {
@@ -465,26 +550,63 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 				selectedDisks = append(selectedDisks, m.disks[i].Path)
 			}
 		}
-		minRequired := m.config.StorageMode.minDisks()
-		if len(selectedDisks) < minRequired {
-			m.err = fmt.Errorf("select at least %d disks for %s", minRequired, m.config.StorageMode)
+		if err := config.ValidateDiskSelection(m.config.StorageMode, selectedDisks); err != nil {
+			m.err = err
+			return m, nil
+		}
+		var ok bool
+		if m, ok = m.checkSelection(m.selectedDiskInfos()); !ok {
 			return m, nil
 		}
 		m.config.Disks = selectedDisks
 		m.config.Disk = selectedDisks[0] // First disk is the boot disk
-		m.config.HostID = generateHostID()
+		m.config.DiskLinks = diskLinks(m.disks, m.config.Disks)
+		m.config.HostID = config.GenerateHostID()
+		m.config.RedundantESP = false
 		m.err = nil
-		// Multi-disk modes are always encrypted ZFS
-		m.state = statePassphrase
-		m.input.SetValue("")
-		m.input.Placeholder = "Enter ZFS encryption passphrase"
-		m.input.EchoMode = textinput.EchoPassword
-		m.input.EchoCharacter = '*'
+		if m.config.StorageMode.HasRedundancy() {
+			m.state = stateBootDisks
+			m.selectedIdx = 0
+			return m, nil
+		}
+		m = m.afterDisk()
+
+	case stateBootDisks:
+		m.config.RedundantESP = m.selectedIdx == 0
+		logInfo("ESP on every disk: %v", m.config.RedundantESP)
+		m = m.afterDisk()
+
+	case stateSizes:
+		m.config.SetSize(m.sizeField(), m.input.Value())
+		if err := m.config.ResolveSizes(); err != nil {
+			m.err = err
+			return m, nil
+		}
+		logInfo("Sizes: boot %q, nix %q, atuin %q, home %q", m.config.SpaceBoot, m.config.SpaceNix, m.config.SpaceAtuin, m.config.SpaceHome)
+		m.err = nil
+		m = m.afterSizes()
+
+	case stateDatasets:
+		if m.datasetRow() != "" || strings.TrimSpace(m.input.Value()) != "" {
+			var err error
+			m, err = m.applyDataset()
+			m.err = err
+			return m, nil
+		}
+		if err := m.config.ValidateZFSLayout(); err != nil {
+			m.err = err
+			return m, nil
+		}
+		for _, d := range m.config.Datasets {
+			logInfo("Dataset: %s", d)
+		}
+		m.err = nil
+		m = m.afterDatasets()
 
 	case statePassphrase:
 		val := m.input.Value()
-		if len(val) < 8 {
-			m.err = fmt.Errorf("passphrase must be at least 8 characters")
+		if err := config.ValidatePassphrase(val); err != nil {
+			m.err = err
 			return m, nil
 		}
 		m.config.Passphrase = val
@@ -516,7 +638,6 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 		km := m.keymaps[m.selectedIdx]
 		m.config.Keymap = km.XKBLayout
 		m.config.ConsoleKeyMap = km.ConsoleMap
-		calculateSpaceAllocation(&m.config)
 		m.state = stateSSH
 		m.selectedIdx = 0
 
@@ -539,7 +660,7 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 		// Fetch SSH keys from GitHub
-		keys, err := fetchGitHubKeys(val)
+		keys, err := config.FetchGitHubKeys(context.Background(), val)
 		if err != nil {
 			m.err = fmt.Errorf("failed to fetch keys: %v", err)
 			return m, nil
@@ -553,18 +674,88 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 		m.err = nil
 		m.state = stateSummary
 
+	case stateExport:
+		path := strings.TrimSpace(m.input.Value())
+		if path == "" {
+			m.err = fmt.Errorf("enter a path for the answer file")
+			return m, nil
+		}
+		answers, err := answersFromConfig(m.config)
+		if err == nil {
+			err = config.WriteAnswers(path, answers)
+		}
+		if err != nil {
+			logError("Export answer file failed: %v", err)
+			m.err = err
+			return m, nil
+		}
+		logInfo("Exported answer file to %s", path)
+		m.notice = "Answer file saved to " + path
+		m.err = nil
+		m.state = stateSummary
+
 	case stateSummary:
+		m.notice = ""
 		m.state = stateConfirm
+		m.destroyPreview = previewDestruction(m.config)
+		m.confirmStep = 0
 		m.input.SetValue("")
-		m.input.Placeholder = "Type DESTROY to confirm"
+		m.input.Placeholder = confirmSteps(m.config, m.destroyPreview)[0].Prompt
 
 	case stateConfirm:
-		if m.input.Value() == "DESTROY" {
-			m.state = stateInstalling
-			m.installStep = 0
-			return m, tea.Batch(tick(), runInstallation(m.config))
+		steps := confirmSteps(m.config, m.destroyPreview)
+		step := steps[m.confirmStep]
+		typed := strings.TrimSpace(m.input.Value())
+		if typed != step.Word && (m.confirmStep == 0 || !strings.EqualFold(typed, step.Word)) {
+			if m.confirmStep == 0 {
+				m.err = fmt.Errorf("type %s to confirm, or press q to cancel", step.Word)
+			} else {
+				m.err = fmt.Errorf("that does not match; check the disk's label, or press Ctrl+C to cancel")
+			}
+			return m, nil
+		}
+		m.err = nil
+		if m.confirmStep++; m.confirmStep < len(steps) {
+			m.input.SetValue("")
+			m.input.Placeholder = steps[m.confirmStep].Prompt
+			return m, nil
+		}
+		logInfo("Install on %s confirmed", strings.Join(m.config.Disks, ", "))
+		m.state = stateInstalling
+		m.installStep = 0
+		m.installEvents = subscribeInstallEvents()
+		ctx, stop := newInstallContext()
+		m.stopInstall = stop
+		return m, tea.Batch(tick(), waitForInstallEvent(m.installEvents), runInstallation(ctx, m.config))
+
+	case stateResume:
+		if m.selectedIdx == 1 {
+			// Start over; the new install replaces the saved progress
+			logInfo("Not resuming the previous installation")
+			m.resume = nil
+			m.state = stateUsername
+			m.input.Placeholder = "e.g., john, alice"
+			m.input.SetValue("")
+			return m, nil
 		}
-		m.err = fmt.Errorf("type DESTROY to confirm, or press q to cancel")
+		if m.resume.Config.StorageMode.IsEncrypted() {
+			m.state = stateResumePassphrase
+			m.input.SetValue("")
+			m.input.Placeholder = "Enter disk encryption passphrase"
+			m.input.EchoMode = textinput.EchoPassword
+			m.input.EchoCharacter = '*'
+			return m, nil
+		}
+		return m.startResume("")
+
+	case stateResumePassphrase:
+		val := m.input.Value()
+		if val == "" {
+			m.err = fmt.Errorf("enter the passphrase to unlock the disks")
+			return m, nil
+		}
+		m.err = nil
+		return m.startResume(val)
 
 	case stateComplete, stateError:
 		return m, tea.Quit
@@ -580,6 +771,71 @@ func (m model) handleEnter() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// afterDisk moves on from choosing the target disk(s) to the size editor,
+// with the sizes worked out from the disks and any answer file. A layout
+// without sizes to choose goes straight past it.
+func (m model) afterDisk() model {
+	if err := calculateSpaceAllocation(&m.config); err != nil {
+		m.err = err
+		return m
+	}
+	m.answers.ApplySizes(&m.config)
+	if len(m.config.SizeFields()) == 0 {
+		return m.afterSizes()
+	}
+	m.state = stateSizes
+	m.selectedIdx = 0
+	m.err = m.config.ValidateSizes()
+	m.input.EchoMode = textinput.EchoNormal
+	m.input.EchoCharacter = 0
+	return m.focusSize()
+}
+
+// afterSizes moves on from the size editor to the dataset editor of ZFS
+// pools, with the layout from any answer file
+func (m model) afterSizes() model {
+	if !m.config.StorageMode.IsZFS() {
+		return m.afterDatasets()
+	}
+	m.answers.ApplyDatasets(&m.config)
+	m.state = stateDatasets
+	m.selectedIdx = len(m.datasetRows()) - 1
+	m.err = m.config.ValidateZFSLayout()
+	return m.focusDataset()
+}
+
+// afterDatasets moves on from the dataset editor: to the passphrase for
+// encrypted modes, otherwise to the locale
+func (m model) afterDatasets() model {
+	if m.config.StorageMode.IsEncrypted() {
+		m.state = statePassphrase
+		m.input.SetValue("")
+		m.input.Placeholder = "Enter disk encryption passphrase"
+		m.input.EchoMode = textinput.EchoPassword
+		m.input.EchoCharacter = '*'
+		return m
+	}
+	// Unencrypted modes: skip passphrase, go to locale
+	m.state = stateLocale
+	m.input.SetValue("")
+	m.input.EchoMode = textinput.EchoNormal
+	m.input.EchoCharacter = 0
+	m.selectedIdx = 0
+	return m
+}
+
+// startResume continues the saved installation in m.resume
+func (m model) startResume(passphrase string) (tea.Model, tea.Cmd) {
+	logInfo("Resuming installation of %s at %q", m.resume.Config.Hostname, m.resume.NextStep())
+	m.config = m.resume.Config
+	m.state = stateInstalling
+	m.installStep = 0
+	m.installEvents = subscribeInstallEvents()
+	ctx, stop := newInstallContext()
+	m.stopInstall = stop
+	return m, tea.Batch(tick(), waitForInstallEvent(m.installEvents), runResume(ctx, m.resume, passphrase))
+}
+
 func (m model) View() string {
 	switch m.state {
 	case stateFireTransition:
@@ -596,6 +852,8 @@ func (m model) View() string {
 		return m.viewComplete()
 	case stateError:
 		return m.viewError()
+	case stateResume, stateResumePassphrase:
+		return m.viewResume()
 	default:
 		return m.viewWizard()
 	}
@@ -0,0 +1,56 @@
+package nixgen
+
+import (
+	"fmt"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+)
+
+// AlongsideDisko renders the disko configuration for an install into free
+// space next to another operating system. Disko only sees the partition
+// the installer creates in the gap, so formatting can never reach the
+// disk's other partitions; the existing ESP is mounted by hardware.nix.
+func AlongsideDisko(c config.Config) string {
+	a := c.Alongside
+	var content Attrs
+	switch {
+	case c.StorageMode.IsZFS():
+		content = Attrs{
+			Set("type", Str("zfs")),
+			Set("pool", Str(c.ZFSPoolName)),
+		}
+	case c.StorageMode.IsBtrfs():
+		content = btrfsContent(c, a.Device())
+	default:
+		content = Attrs{
+			Set("type", Str("filesystem")),
+			Set("format", Str(c.StorageMode.RootFormat())),
+			Set("mountpoint", Str("/")),
+		}
+	}
+	if c.StorageMode.IsLUKS() {
+		content = luksContent(content)
+	}
+
+	devices := Attrs{
+		Set("disk", Attrs{
+			Set("tuinix", Attrs{
+				Set("type", Str("disk")),
+				Set("device", Str(a.Device())),
+				Set("content", content),
+			}),
+		}),
+	}
+	if c.StorageMode.IsZFS() {
+		devices = append(devices, Set("zpool", Attrs{Key(c.ZFSPoolName, zpool(c, Str("")))}))
+	}
+
+	return RenderFile([]string{
+		fmt.Sprintf("Disko configuration for tuinix - %s alongside another OS", c.StorageMode),
+		fmt.Sprintf("Only partition %s on %s is formatted; /boot is the existing ESP %s", a.Device(), c.Disk, a.ESP),
+		"Generated by tuinix installer",
+	}, Func{
+		Args: []string{"lib"},
+		Body: Attrs{Set("disko.devices", devices)},
+	})
+}
@@ -0,0 +1,104 @@
+package nixgen
+
+import (
+	"fmt"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+)
+
+// BtrfsDisko renders the disko configuration for the whole-disk Btrfs
+// modes: an ESP and one partition holding the Btrfs filesystem, inside a
+// LUKS2 container for luks-btrfs
+func BtrfsDisko(c config.Config) string {
+	// disko labels the partition disk-main-root
+	name, content := "root", btrfsContent(c, "/dev/disk/by-partlabel/disk-main-root")
+	if c.StorageMode.IsLUKS() {
+		name, content = "luks", luksContent(content)
+	}
+
+	return RenderFile([]string{
+		fmt.Sprintf("Disko configuration for tuinix - %s", c.StorageMode),
+		"Generated by tuinix installer",
+	}, Func{
+		Args: []string{"lib"},
+		Body: Attrs{
+			Set("disko.devices", Attrs{
+				Set("disk", Attrs{
+					Set("main", Attrs{
+						Set("type", Str("disk")),
+						Set("device", Str(c.DiskLink(c.Disk))),
+						Set("content", Attrs{
+							Set("type", Str("gpt")),
+							Set("partitions", Attrs{
+								Set("ESP", Attrs{
+									Set("type", Str("EF00")),
+									Set("size", Str(c.SpaceBoot)),
+									Set("content", Attrs{
+										Set("type", Str("filesystem")),
+										Set("format", Str("vfat")),
+										Set("mountpoint", Str("/boot")),
+										Set("mountOptions", List{Str("umask=0077")}),
+									}),
+								}),
+								Key(name, Attrs{
+									Set("size", Str("100%")),
+									Set("content", content),
+								}),
+							}),
+						}),
+					}),
+				}),
+			}),
+		},
+	})
+}
+
+// btrfsContent is the Btrfs filesystem of the Btrfs modes, whole-disk or
+// alongside another OS, with the blank root snapshot and quotas taken on
+// device
+func btrfsContent(c config.Config, device string) Attrs {
+	if c.StorageMode.IsLUKS() {
+		device = "/dev/mapper/" + LUKSName
+	}
+	subvolume := func(mountpoint string) Attrs {
+		return Attrs{
+			Set("mountpoint", Str(mountpoint)),
+			Set("mountOptions", List{Str("compress=zstd"), Str("noatime")}),
+		}
+	}
+	return Attrs{
+		Set("type", Str("btrfs")),
+		Set("extraArgs", List{Str("-f"), Str("-L"), Str("nixos")}),
+		Set("subvolumes", Attrs{
+			Key("/root", subvolume("/")),
+			Key("/nix", subvolume("/nix")),
+			Key("/home", subvolume("/home")),
+			Key("/log", subvolume("/var/log")),
+		}),
+		{
+			Path:    []string{"postCreateHook"},
+			Comment: "Snapshot the empty root (like the ZFS layout's root@blank) and cap\n/nix (and /home, if it has a quota) at its share of the disk",
+			Value: IndentedStr(fmt.Sprintf(`MNTPOINT=$(mktemp -d)
+mount -t btrfs %s "$MNTPOINT" -o subvol=/
+trap 'umount "$MNTPOINT"; rm -rf "$MNTPOINT"' EXIT
+btrfs subvolume snapshot -r "$MNTPOINT/root" "$MNTPOINT/root-blank"
+btrfs quota enable "$MNTPOINT"
+btrfs qgroup limit %s "$MNTPOINT/nix"
+btrfs qgroup limit %s "$MNTPOINT/home"
+`, device, c.SpaceNix, homeQuota(c))),
+		},
+	}
+}
+
+// luksContent puts content inside the LUKS2 container the encrypted modes
+// unlock as LUKSName. The passphrase is read from stdin (twice) when the
+// container is created; the initrd unlock is configured in hardware.nix.
+func luksContent(content Attrs) Attrs {
+	return Attrs{
+		Set("type", Str("luks")),
+		Set("name", Str(LUKSName)),
+		Set("extraFormatArgs", List{Str("--type"), Str("luks2")}),
+		Set("settings", Attrs{Set("allowDiscards", Bool(true))}),
+		Set("content", content),
+	}
+}
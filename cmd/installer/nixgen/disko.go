@@ -0,0 +1,197 @@
+package nixgen
+
+import (
+	"fmt"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+)
+
+// ZFSDisko renders the disko configuration for the whole-disk ZFS modes:
+// an ESP on the first disk (or on every disk, see config.ESPDisks) and
+// one pool across all of them
+func ZFSDisko(c config.Config) string {
+	poolName := c.ZFSPoolName
+
+	// Determine ZFS pool mode
+	// In disko, mode = "" means stripe (no redundancy), "mirror", "raidz",
+	// "raidz2" and "raidz3" put every disk in one vdev, and a topology
+	// lists several vdevs (striped mirrors)
+	var zfsMode Value = Str("")
+	switch c.StorageMode {
+	case config.StorageZFSMirror:
+		zfsMode = Str("mirror")
+	case config.StorageZFSRaidz:
+		zfsMode = Str("raidz")
+	case config.StorageZFSRaidz2:
+		zfsMode = Str("raidz2")
+	case config.StorageZFSRaidz3:
+		zfsMode = Str("raidz3")
+	case config.StorageZFSStripedMirror:
+		zfsMode = stripedMirrorTopology(len(c.Disks))
+	}
+
+	zfsPartition := Attrs{
+		Set("size", Str("100%")),
+		Set("content", Attrs{
+			Set("type", Str("zfs")),
+			Set("pool", Str(poolName)),
+		}),
+	}
+
+	// Generate disk entries - the ESP disks get ESP + ZFS, the rest ZFS only
+	espDisks := len(c.ESPDisks())
+	mountOptions := List{Str("umask=0077")}
+	if espDisks > 1 {
+		// Boot on from another disk's ESP when one disk is gone
+		mountOptions = append(mountOptions, Str("nofail"))
+	}
+	var disks Attrs
+	for i, disk := range c.Disks {
+		partitions := Attrs{}
+		if i < espDisks {
+			partitions = append(partitions, Set("ESP", Attrs{
+				Set("type", Str("EF00")),
+				Set("size", Str(c.SpaceBoot)),
+				Set("content", Attrs{
+					Set("type", Str("filesystem")),
+					Set("format", Str("vfat")),
+					Set("mountpoint", Str(ESPMountpoint(i))),
+					Set("mountOptions", mountOptions),
+				}),
+			}))
+		}
+		partitions = append(partitions, Set("zfs", zfsPartition))
+
+		// The names are the vdev members in stripedMirrorTopology
+		name := diskName(i)
+		if len(c.Disks) == 1 {
+			name = "main" // Partition labels disk-main-ESP and disk-main-zfs
+		}
+		disks = append(disks, Key(name, Attrs{
+			Set("type", Str("disk")),
+			Set("device", Str(c.DiskLink(disk))),
+			Set("content", Attrs{
+				Set("type", Str("gpt")),
+				Set("partitions", partitions),
+			}),
+		}))
+	}
+
+	title := fmt.Sprintf("Disko configuration for tuinix - multi-disk ZFS (%s)", c.StorageMode)
+	if !c.StorageMode.IsMultiDisk() {
+		title = fmt.Sprintf("Disko configuration for tuinix - %s", c.StorageMode)
+	}
+	return RenderFile([]string{
+		title,
+		"Generated by tuinix installer",
+	}, Func{
+		Args: []string{"lib"},
+		Body: Attrs{
+			Set("disko.devices", Attrs{
+				Set("disk", disks),
+				Set("zpool", Attrs{Key(poolName, zpool(c, zfsMode))}),
+			}),
+		},
+	})
+}
+
+// zpool is the encrypted pool with c's dataset layout, laid out as mode
+// (a disko zpool mode or topology)
+func zpool(c config.Config, mode Value) Attrs {
+	poolName := c.ZFSPoolName
+	var datasets Attrs
+	for _, d := range c.ZFSDatasets() {
+		var options Attrs
+		for _, k := range d.SortedProperties() {
+			options = append(options, Key(k, Str(d.Properties[k])))
+		}
+		dataset := Attrs{
+			Set("type", Str("zfs_fs")),
+			Set("mountpoint", Str(d.Mountpoint)),
+			Set("options", options),
+		}
+		if d.Name == "root" {
+			// Rolling back to root@blank gives an empty root filesystem
+			dataset = append(dataset, Set("postCreateHook", IndentedStr(fmt.Sprintf("zfs snapshot %s/root@blank\n", poolName))))
+		}
+		datasets = append(datasets, Key(d.Name, dataset))
+	}
+	datasets = append(datasets,
+		Key("atuin", Attrs{
+			Set("type", Str("zfs_volume")),
+			Set("size", Str(c.SpaceAtuin)),
+			Set("content", Attrs{
+				Set("type", Str("filesystem")),
+				Set("format", Str("xfs")),
+				Set("mountpoint", Str("/var/atuin")),
+				Set("mountOptions", List{Str("defaults"), Str("nofail")}),
+			}),
+		}),
+	)
+
+	return Attrs{
+		Set("type", Str("zpool")),
+		Set("mode", mode),
+		Set("options", Attrs{
+			Set("ashift", Str("12")),
+			Set("autotrim", Str("on")),
+		}),
+		Set("rootFsOptions", Attrs{
+			Set("compression", Str("zstd")),
+			Set("acltype", Str("posixacl")),
+			Set("xattr", Str("sa")),
+			Set("relatime", Str("on")),
+			Set("mountpoint", Str("none")),
+			Set("encryption", Str("aes-256-gcm")),
+			Set("keyformat", Str("passphrase")),
+			Set("keylocation", Str("prompt")),
+			Key("com.sun:auto-snapshot", Str("false")),
+		}),
+		Set("datasets", datasets),
+	}
+}
+
+// stripedMirrorTopology pairs disk0..disk(n-1) into two-way mirror vdevs,
+// the same pairs config.MirrorPairs makes. Disko resolves each member to
+// the "zfs" partition of the disk with that name.
+func stripedMirrorTopology(n int) Value {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = diskName(i)
+	}
+	var vdevs List
+	for _, pair := range config.MirrorPairs(names) {
+		vdevs = append(vdevs, Attrs{
+			Set("mode", Str("mirror")),
+			Set("members", List{Str(pair[0]), Str(pair[1])}),
+		})
+	}
+	return Attrs{
+		Set("topology", Attrs{
+			Set("type", Str("topology")),
+			Set("vdev", vdevs),
+		}),
+	}
+}
+
+// homeQuota is the quota of the home dataset or subvolume, "none" when
+// /home may use the rest of the pool
+func homeQuota(c config.Config) string {
+	if c.SpaceHome == "" {
+		return "none"
+	}
+	return c.SpaceHome
+}
+
+// ESPMountpoint is where the ESP of the i'th disk is mounted: /boot for
+// the first, /boot-disk1 and so on for the copies GRUB keeps on the others
+func ESPMountpoint(i int) string {
+	if i == 0 {
+		return "/boot"
+	}
+	return "/boot-" + diskName(i)
+}
+
+func diskName(i int) string {
+	return fmt.Sprintf("disk%d", i)
+}
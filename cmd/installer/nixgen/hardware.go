@@ -0,0 +1,137 @@
+package nixgen
+
+import "github.com/timlinux/tuinix/cmd/installer/config"
+
+// LUKSName is the device-mapper name of the LUKS container the LUKS modes
+// create
+const LUKSName = "cryptroot"
+
+// LUKSDevice is the partition holding c's LUKS container. Disko labels
+// each partition disk-<disk>-<partition>, which gives a path that does not
+// depend on the disk's kernel name; an install into free space uses the
+// GUID of the partition it created.
+func LUKSDevice(c config.Config) string {
+	if c.Alongside != nil {
+		return c.Alongside.Device()
+	}
+	return "/dev/disk/by-partlabel/disk-main-luks"
+}
+
+// HardwareNix renders hosts/<hostname>/hardware.nix: kernel modules for
+// common hardware plus the ZFS, Btrfs or LUKS boot settings the storage
+// mode needs
+func HardwareNix(c config.Config) string {
+	var hw Attrs
+	if c.StorageMode.IsZFS() {
+		hw = append(hw, Set("networking.hostId", Str(c.HostID)))
+	}
+	hw = append(hw, Set("imports", List{Raw(`modulesPath + "/installer/scan/not-detected.nix"`)}))
+
+	var boot Attrs
+	if c.StorageMode.IsZFS() {
+		boot = append(boot,
+			Set("supportedFilesystems", List{Str("zfs")}),
+			Set("zfs", Attrs{
+				Set("requestEncryptionCredentials", Bool(c.StorageMode.IsEncrypted())),
+				Set("forceImportRoot", Bool(true)),
+			}),
+		)
+	}
+	if c.StorageMode.IsBtrfs() {
+		boot = append(boot, Set("supportedFilesystems", List{Str("btrfs")}))
+	}
+	var modules List
+	for _, m := range []string{
+		"ahci", "xhci_pci", "virtio_pci", "virtio_blk", "virtio_scsi",
+		"sd_mod", "sr_mod", "nvme", "ehci_pci", "usbhid",
+		"usb_storage", "sdhci_pci",
+	} {
+		modules = append(modules, Str(m))
+	}
+	initrd := Attrs{
+		Set("availableKernelModules", modules),
+		Set("kernelModules", List{}),
+	}
+	if c.StorageMode.IsLUKS() {
+		// The initrd asks for the passphrase and opens the container
+		// before mounting /
+		initrd = append(initrd, Set("luks.devices", Attrs{
+			Key(LUKSName, Attrs{
+				Set("device", Str(LUKSDevice(c))),
+				Set("allowDiscards", Bool(true)),
+			}),
+		}))
+	}
+	boot = append(boot,
+		Set("initrd", initrd),
+		Set("kernelModules", List{Str("kvm-intel"), Str("kvm-amd")}),
+		Set("extraModulePackages", List{}),
+	)
+
+	hw = append(hw,
+		Set("boot", boot),
+		Set("hardware", Attrs{
+			Set("enableAllFirmware", Bool(true)),
+			Set("cpu.intel.updateMicrocode", Call{Fn: "lib.mkDefault", Args: []Value{Bool(true)}}),
+		}),
+		Set("powerManagement.cpuFreqGovernor", Call{Fn: "lib.mkDefault", Args: []Value{Str("powersave")}}),
+	)
+	if c.StorageMode.IsZFS() {
+		hw = append(hw, Set("services.zfs.autoScrub.enable", Bool(true)))
+	}
+	if a := c.Alongside; a != nil {
+		hw = append(hw,
+			Attr{
+				Path: []string{"fileSystems", "/boot"},
+				Value: Attrs{
+					Set("device", Str("/dev/disk/by-uuid/"+a.ESPUUID)),
+					Set("fsType", Str("vfat")),
+					Set("options", List{Str("umask=0077")}),
+				},
+				Comment: "The ESP is shared with the other OS, so disko does not manage it",
+			},
+			// Install GRUB under its own name and register it with the
+			// firmware, rather than as the removable-media fallback the
+			// other OS may rely on, and keep few kernels on the shared ESP
+			Set("boot.loader.grub.efiInstallAsRemovable", Call{Fn: "lib.mkForce", Args: []Value{Bool(false)}}),
+			Set("boot.loader.efi.canTouchEfiVariables", Bool(true)),
+			Set("boot.loader.grub.configurationLimit", Call{Fn: "lib.mkForce", Args: []Value{Int(3)}}),
+		)
+	}
+	if espDisks := len(c.ESPDisks()); espDisks > 1 {
+		// GRUB copies its kernels and itself to every mirror on each
+		// rebuild, and registers a firmware boot entry for each ESP
+		var mirrors List
+		for i := 1; i < espDisks; i++ {
+			mirrors = append(mirrors, Attrs{
+				Set("path", Str(ESPMountpoint(i))),
+				Set("efiSysMountPoint", Str(ESPMountpoint(i))),
+				Set("devices", List{Str("nodev")}),
+			})
+		}
+		hw = append(hw,
+			Attr{
+				Path:    []string{"boot", "loader", "grub", "mirroredBoots"},
+				Value:   mirrors,
+				Comment: "A copy of /boot on every other disk of the pool",
+			},
+			Set("boot.loader.grub.efiInstallAsRemovable", Call{Fn: "lib.mkForce", Args: []Value{Bool(false)}}),
+			Set("boot.loader.efi.canTouchEfiVariables", Bool(true)),
+		)
+	}
+	if c.StorageMode.IsBtrfs() {
+		hw = append(hw,
+			Attr{
+				Path:    []string{"fileSystems", "/var/log", "neededForBoot"},
+				Value:   Bool(true),
+				Comment: "Mount the /var/log subvolume early so the whole boot is logged",
+			},
+			Set("services.btrfs.autoScrub.enable", Bool(true)),
+		)
+	}
+
+	return RenderFile(nil, Func{
+		Args: []string{"config", "lib", "pkgs", "modulesPath"},
+		Body: hw,
+	})
+}
@@ -0,0 +1,326 @@
+package nixgen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Value is a Nix expression. Values built from user input (names, emails,
+// disk paths, ...) are escaped when rendered, so they can never change the
+// structure of the generated file.
+type Value interface {
+	render(b *strings.Builder, indent int)
+}
+
+// Str is a double-quoted Nix string
+type Str string
+
+// IndentedStr is a multi-line Nix indented string, used for shell hooks
+type IndentedStr string
+
+// Path is a Nix path literal such as ./disks.nix. Paths with characters a
+// path literal cannot hold are emitted as a path plus a string.
+type Path string
+
+type Bool bool
+
+type Int int64
+
+// List is a Nix list
+type List []Value
+
+// Attrs is an attribute set, rendered in the given order
+type Attrs []Attr
+
+// Attr is one binding in an attribute set. Path holds the key segments of
+// a binding like a.b.c = ...; each segment is quoted if needed. Comment is
+// written above the binding, after a blank line.
+type Attr struct {
+	Path    []string
+	Value   Value
+	Comment string
+}
+
+// Func is a function taking an attribute set, { a, b, ... }: body
+type Func struct {
+	Args []string
+	Body Value
+}
+
+// With is with scope; body
+type With struct {
+	Scope string
+	Body  Value
+}
+
+// Call applies a function to arguments, e.g. lib.mkDefault true
+type Call struct {
+	Fn   string
+	Args []Value
+}
+
+// Raw is Nix code written out as-is. Only use it for fixed code in the
+// installer source, never for user input.
+type Raw string
+
+// Set binds a dotted path of plain identifiers written in the installer
+// source, e.g. Set("services.zfs.autoScrub.enable", Bool(true))
+func Set(path string, v Value) Attr {
+	return Attr{Path: strings.Split(path, "."), Value: v}
+}
+
+// Key binds a single key that may hold any characters, such as a user
+// name or "com.sun:auto-snapshot"
+func Key(key string, v Value) Attr {
+	return Attr{Path: []string{key}, Value: v}
+}
+
+// Render returns the Nix source for v
+func Render(v Value) string {
+	var b strings.Builder
+	v.render(&b, 0)
+	return b.String()
+}
+
+// RenderFile returns a complete .nix file: a comment header followed by v
+func RenderFile(comments []string, v Value) string {
+	var b strings.Builder
+	for _, c := range comments {
+		b.WriteString("# " + oneLine(c) + "\n")
+	}
+	v.render(&b, 0)
+	b.WriteString("\n")
+	return b.String()
+}
+
+const maxInlineList = 72
+
+var (
+	identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_'-]*$`)
+	pathRe  = regexp.MustCompile(`^(\.\.?)?(/[A-Za-z0-9._+-]+)+$`)
+)
+
+var nixKeywords = map[string]bool{
+	"assert": true, "else": true, "if": true, "in": true, "inherit": true,
+	"let": true, "or": true, "rec": true, "then": true, "with": true,
+}
+
+// EscapeString escapes s for use between double quotes
+func EscapeString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				b.WriteString(`\$`)
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EscapeIndented escapes s for use inside a Nix indented string. A single
+// quote that the next character, or whatever follows s, could pair with
+// is written escaped as well.
+func EscapeIndented(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'' && (i+1 == len(s) || s[i+1] == '\'' || s[i+1] == '$'):
+			b.WriteString(`''\'`)
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			b.WriteString("''$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func pad(indent int) string {
+	return strings.Repeat("  ", indent)
+}
+
+func (s Str) render(b *strings.Builder, indent int) {
+	b.WriteString(`"` + EscapeString(string(s)) + `"`)
+}
+
+func (s IndentedStr) render(b *strings.Builder, indent int) {
+	b.WriteString("''\n")
+	for _, line := range strings.Split(strings.TrimSuffix(string(s), "\n"), "\n") {
+		// The closing '' always sits on its own line, so a trailing quote in
+		// the content can never merge with it
+		if line != "" {
+			b.WriteString(pad(indent+1) + EscapeIndented(line))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(pad(indent) + "''")
+}
+
+func (p Path) render(b *strings.Builder, indent int) {
+	s := string(p)
+	if pathRe.MatchString(s) && strings.Contains(s, "/") {
+		b.WriteString(s)
+		return
+	}
+	// Build the path from a string instead, relative to this file or /
+	base, rest := "./.", s
+	if strings.HasPrefix(s, "/") {
+		base = "/."
+	} else {
+		rest = "/" + strings.TrimPrefix(s, "./")
+	}
+	b.WriteString("(" + base + " + ")
+	Str(rest).render(b, indent)
+	b.WriteString(")")
+}
+
+func (v Bool) render(b *strings.Builder, indent int) {
+	fmt.Fprintf(b, "%t", bool(v))
+}
+
+func (v Int) render(b *strings.Builder, indent int) {
+	fmt.Fprintf(b, "%d", int64(v))
+}
+
+func (r Raw) render(b *strings.Builder, indent int) {
+	b.WriteString(string(r))
+}
+
+func (l List) render(b *strings.Builder, indent int) {
+	if len(l) == 0 {
+		b.WriteString("[ ]")
+		return
+	}
+	if inline, ok := l.inline(); ok {
+		b.WriteString(inline)
+		return
+	}
+	b.WriteString("[\n")
+	for _, v := range l {
+		b.WriteString(pad(indent + 1))
+		renderOperand(b, v, indent+1)
+		b.WriteString("\n")
+	}
+	b.WriteString(pad(indent) + "]")
+}
+
+// inline renders a short list of scalars on one line
+func (l List) inline() (string, bool) {
+	parts := []string{"["}
+	for _, v := range l {
+		switch v.(type) {
+		case Str, Path, Bool, Int, Raw:
+		default:
+			return "", false
+		}
+		var b strings.Builder
+		renderOperand(&b, v, 0)
+		parts = append(parts, b.String())
+	}
+	s := strings.Join(append(parts, "]"), " ")
+	if len(s) > maxInlineList || strings.Contains(s, "\n") {
+		return "", false
+	}
+	return s, true
+}
+
+func (a Attrs) render(b *strings.Builder, indent int) {
+	if len(a) == 0 {
+		b.WriteString("{ }")
+		return
+	}
+	b.WriteString("{\n")
+	for i, attr := range a {
+		if attr.Comment != "" {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			for _, line := range strings.Split(attr.Comment, "\n") {
+				b.WriteString(pad(indent+1) + "# " + line + "\n")
+			}
+		}
+		b.WriteString(pad(indent + 1))
+		for j, key := range attr.Path {
+			if j > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(renderKey(key))
+		}
+		b.WriteString(" = ")
+		attr.Value.render(b, indent+1)
+		b.WriteString(";\n")
+	}
+	b.WriteString(pad(indent) + "}")
+}
+
+func renderKey(key string) string {
+	if identRe.MatchString(key) && !nixKeywords[key] {
+		return key
+	}
+	return `"` + EscapeString(key) + `"`
+}
+
+func (f Func) render(b *strings.Builder, indent int) {
+	args := append(append([]string{}, f.Args...), "...")
+	b.WriteString("{ " + strings.Join(args, ", ") + " }:")
+	if indent == 0 {
+		// A whole file: put the body below the argument list
+		b.WriteString("\n\n")
+	} else {
+		b.WriteString(" ")
+	}
+	f.Body.render(b, indent)
+}
+
+func (w With) render(b *strings.Builder, indent int) {
+	b.WriteString("with " + w.Scope + "; ")
+	w.Body.render(b, indent)
+}
+
+func (c Call) render(b *strings.Builder, indent int) {
+	b.WriteString(c.Fn)
+	for _, arg := range c.Args {
+		b.WriteString(" ")
+		renderOperand(b, arg, indent)
+	}
+}
+
+// renderOperand renders v where it sits next to other values (a list
+// element or function argument), adding parentheses where Nix needs them
+func renderOperand(b *strings.Builder, v Value, indent int) {
+	needParens := false
+	switch v := v.(type) {
+	case Call, With, Func:
+		needParens = true
+	case Raw:
+		needParens = strings.ContainsAny(string(v), " \t\n")
+	}
+	if needParens {
+		b.WriteString("(")
+	}
+	v.render(b, indent)
+	if needParens {
+		b.WriteString(")")
+	}
+}
@@ -0,0 +1,266 @@
+package nixgen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Inputs that have tripped up Nix string escaping
+var awkward = []string{
+	"", "plain", `''`, `'''`, `'${`, `'${x}`, `${`, `${x}`, `$`, `$$`, `'`,
+	`a'`, `''$`, `''\`, `\`, `\n`, "\n", "a\nb", "\t\r", `"`, `"${x}"`,
+	`$'{`, `''${x}''`, `it's ${HOME}'`,
+}
+
+func TestEscapeString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, `plain`},
+		{`"`, `\"`},
+		{`\`, `\\`},
+		{"\n", `\n`},
+		{"a\r\tb", `a\r\tb`},
+		{`${x}`, `\${x}`},
+		{`$x`, `$x`},
+		{`''`, `''`},
+		{`'${`, `'\${`},
+	}
+	for _, tt := range tests {
+		if got := EscapeString(tt.in); got != tt.want {
+			t.Errorf("EscapeString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range awkward {
+		got, err := decodeString(`"` + EscapeString(in) + `"`)
+		if err != nil || got != in {
+			t.Errorf("EscapeString(%q) reads back as %q, %v", in, got, err)
+		}
+	}
+}
+
+func TestEscapeIndented(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, `plain`},
+		{`it's`, `it's`},
+		{`''`, `''\'''\'`},
+		{`'${`, `''\'''${`},
+		{`'${x}`, `''\'''${x}`},
+		{`${`, `''${`},
+		{`$x`, `$x`},
+		{`a'`, `a''\'`},
+		{`\`, `\`},
+		{"a\nb", "a\nb"},
+	}
+	for _, tt := range tests {
+		if got := EscapeIndented(tt.in); got != tt.want {
+			t.Errorf("EscapeIndented(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range awkward {
+		got, err := decodeIndented("''" + EscapeIndented(in) + "''")
+		if err != nil || got != in {
+			t.Errorf("EscapeIndented(%q) reads back as %q, %v", in, got, err)
+		}
+	}
+}
+
+func TestIndentedStr(t *testing.T) {
+	for _, in := range awkward {
+		src := Render(IndentedStr(in))
+		got, err := decodeIndented(src)
+		if err != nil {
+			t.Errorf("IndentedStr(%q) renders as %q: %v", in, src, err)
+			continue
+		}
+		// Render adds a newline after every line and indents them
+		lines := strings.Split(got, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimPrefix(lines[i], "  ")
+		}
+		got = strings.TrimSuffix(strings.Join(lines[1:], "\n"), "\n")
+		if want := strings.TrimSuffix(in, "\n"); got != want {
+			t.Errorf("IndentedStr(%q) reads back as %q", in, got)
+		}
+	}
+}
+
+func TestFillTemplate(t *testing.T) {
+	tests := []struct {
+		name, tmpl string
+		vars       map[string]string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name: "bare value",
+			tmpl: `device = {{V}};`,
+			vars: map[string]string{"V": `/dev/"x"`},
+			want: `device = "/dev/\"x\"";`,
+		},
+		{
+			name: "double-quoted string",
+			tmpl: `size = "{{V}}G";`,
+			vars: map[string]string{"V": `${x}\`},
+			want: `size = "\${x}\\G";`,
+		},
+		{
+			name: "indented string",
+			tmpl: "script = ''\n  echo {{V}}\n'';",
+			vars: map[string]string{"V": `'${x}`},
+			want: "script = ''\n  echo ''\\'''${x}\n'';",
+		},
+		{
+			name: "indented string closed right after the value",
+			tmpl: "x = ''{{V}}'';",
+			vars: map[string]string{"V": `a'`},
+			want: "x = ''a''\\''';",
+		},
+		{
+			name: "after escapes in an indented string",
+			tmpl: "x = ''''' ''${ ''\\n {{V}}'';",
+			vars: map[string]string{"V": "${"},
+			want: "x = ''''' ''${ ''\\n ''${'';",
+		},
+		{
+			name: "after an escaped quote in a string",
+			tmpl: `x = "\" {{V}}";`,
+			vars: map[string]string{"V": `"`},
+			want: `x = "\" \"";`,
+		},
+		{
+			name: "line comment",
+			tmpl: "# disk {{V}}\nx = 1;",
+			vars: map[string]string{"V": "a\nb"},
+			want: "# disk a b\nx = 1;",
+		},
+		{
+			name: "block comment",
+			tmpl: "/* {{V}} */ x = {{V}};",
+			vars: map[string]string{"V": "a\nb"},
+			want: "/* a b */ x = \"a\\nb\";",
+		},
+		{
+			name:    "unknown placeholder",
+			tmpl:    `x = "{{NOPE}}";`,
+			vars:    map[string]string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FillTemplate(tt.tmpl, tt.vars)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("FillTemplate = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FillTemplate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, in := range awkward {
+		vars := map[string]string{"V": in}
+		got, err := FillTemplate("''{{V}}''", vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, err := decodeIndented(got); err != nil || s != in {
+			t.Errorf("{{V}} = %q in an indented string reads back as %q, %v", in, s, err)
+		}
+		got, err = FillTemplate(`"{{V}}"`, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, err := decodeString(got); err != nil || s != in {
+			t.Errorf("{{V}} = %q in a string reads back as %q, %v", in, s, err)
+		}
+	}
+}
+
+// decodeString reads a whole double-quoted Nix string the way Nix does,
+// failing on an interpolation or on text after the closing quote
+func decodeString(src string) (string, error) {
+	if !strings.HasPrefix(src, `"`) {
+		return "", fmt.Errorf("no opening quote")
+	}
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '"':
+			if i != len(src)-1 {
+				return "", fmt.Errorf("string ends at byte %d", i)
+			}
+			return b.String(), nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(src[i])
+			}
+		case strings.HasPrefix(src[i:], "${"):
+			return "", fmt.Errorf("interpolation at byte %d", i)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("no closing quote")
+}
+
+// decodeIndented reads a whole Nix indented string the way Nix does,
+// without stripping indentation, failing on an interpolation or on text
+// after the closing quotes
+func decodeIndented(src string) (string, error) {
+	if !strings.HasPrefix(src, "''") {
+		return "", fmt.Errorf("no opening ''")
+	}
+	var b strings.Builder
+	for i := 2; i < len(src); i++ {
+		rest := src[i:]
+		switch {
+		case strings.HasPrefix(rest, "'''"):
+			b.WriteString("''")
+			i += 2
+		case strings.HasPrefix(rest, "''$"):
+			b.WriteByte('$')
+			i += 2
+		case strings.HasPrefix(rest, "''\\") && len(rest) > 3:
+			switch rest[3] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(rest[3])
+			}
+			i += 3
+		case strings.HasPrefix(rest, "''"):
+			if len(rest) != 2 {
+				return "", fmt.Errorf("string ends at byte %d", i)
+			}
+			return b.String(), nil
+		case strings.HasPrefix(rest, "${"):
+			return "", fmt.Errorf("interpolation at byte %d", i)
+		default:
+			b.WriteByte(rest[0])
+		}
+	}
+	return "", fmt.Errorf("no closing ''")
+}
@@ -0,0 +1,216 @@
+// Package nixgen generates the Nix files that describe an installed host:
+// hosts/<hostname>/{default,disks,hardware}.nix and users/<username>.nix,
+// written into a copy of the tuinix flake.
+package nixgen
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// HostDir is where the files for c's host live inside its work directory
+func HostDir(c config.Config) string {
+	return filepath.Join(c.WorkDir, "hosts", c.Hostname)
+}
+
+// WriteHost writes the user, host and disko files into c.WorkDir, which
+// must already hold a copy of the flake (the disko templates are read from
+// it). hashedPassword is the crypt(3) hash for the user account. It returns
+// the paths of the files written.
+func WriteHost(c config.Config, hashedPassword string) ([]string, error) {
+	hostDir := HostDir(c)
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		return nil, fmt.Errorf("create host dir: %w", err)
+	}
+
+	disksContent, err := DisksNix(c, filepath.Join(c.WorkDir, "templates"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct{ path, content string }{
+		{filepath.Join(c.WorkDir, "users", c.Username+".nix"), UserNix(c, hashedPassword)},
+		{filepath.Join(hostDir, "default.nix"), DefaultNix(c)},
+		{filepath.Join(hostDir, "disks.nix"), disksContent},
+	}
+	var written []string
+	for _, f := range files {
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return written, fmt.Errorf("write %s: %w", filepath.Base(f.path), err)
+		}
+		written = append(written, f.path)
+	}
+
+	// hardware.nix is written later by WriteHardware, after the disks are
+	// formatted and nixos-generate-config has looked at the machine
+	return written, nil
+}
+
+// WriteHardware writes hosts/<hostname>/hardware.nix and returns its path.
+// It only needs the configuration, not the formatted disks, so dry runs can
+// use it too.
+func WriteHardware(c config.Config) (string, error) {
+	path := filepath.Join(HostDir(c), "hardware.nix")
+	if err := os.WriteFile(path, []byte(HardwareNix(c)), 0644); err != nil {
+		return "", fmt.Errorf("write hardware.nix: %w", err)
+	}
+	return path, nil
+}
+
+// CheckSyntax parses each file with nix-instantiate --parse, so a broken
+// generated file fails the install before anything is built from it. It
+// does nothing when nix-instantiate cannot be run.
+func CheckSyntax(ctx context.Context, sh system.Shell, paths ...string) error {
+	if _, err := sh.Query(ctx, "nix-instantiate", "--version"); err != nil {
+		system.LogInfo("CheckSyntax: nix-instantiate not available (%v), skipping parse check", err)
+		return nil
+	}
+	for _, path := range paths {
+		if out, err := sh.Local(ctx, "nix-instantiate", "--parse", path); err != nil {
+			return fmt.Errorf("generated %s does not parse: %w\n%s", path, err, strings.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// UserNix renders users/<username>.nix
+func UserNix(c config.Config, hashedPassword string) string {
+	user := Attrs{
+		Set("isNormalUser", Bool(true)),
+		Set("description", Str(c.Fullname)),
+		Set("extraGroups", List{Str("wheel"), Str("networkmanager"), Str("audio"), Str("video"), Str("docker")}),
+		Set("home", Str("/home/"+c.Username)),
+		Set("createHome", Bool(true)),
+		Set("hashedPassword", Str(hashedPassword)),
+	}
+	if c.EnableSSH && len(c.SSHKeys) > 0 {
+		keys := List{}
+		for _, key := range c.SSHKeys {
+			keys = append(keys, Str(key))
+		}
+		user = append(user, Set("openssh.authorizedKeys.keys", keys))
+	}
+
+	homeManager := Func{
+		Args: []string{"pkgs"},
+		Body: Attrs{
+			Set("programs.git", Attrs{
+				Set("enable", Bool(true)),
+				Set("userName", Str(c.Fullname)),
+				Set("userEmail", Str(c.Email)),
+				Set("extraConfig", Attrs{
+					Set("init.defaultBranch", Str("main")),
+					Set("pull.rebase", Bool(true)),
+					Set("push.autoSetupRemote", Bool(true)),
+				}),
+			}),
+			Set("home.stateVersion", Str("24.11")),
+		},
+	}
+
+	return RenderFile([]string{
+		"User configuration for " + c.Username,
+		"Generated by tuinix installer on " + time.Now().UTC().Format("2006-01-02 15:04:05 UTC"),
+	}, Func{
+		Args: []string{"config", "lib", "pkgs"},
+		Body: Attrs{
+			{Path: []string{"users", "users", c.Username}, Value: user},
+			{Path: []string{"home-manager", "users", c.Username}, Value: homeManager},
+		},
+	})
+}
+
+// DefaultNix renders hosts/<hostname>/default.nix
+func DefaultNix(c config.Config) string {
+	host := Attrs{
+		Set("imports", List{
+			Path("./disks.nix"),
+			Path("./hardware.nix"),
+			Path("../../users/" + c.Username + ".nix"),
+			Path("../../users/admin.nix"),
+		}),
+		Set("networking.hostName", Raw("hostname")),
+		Set("system.stateVersion", Str("25.11")),
+		Set("environment.systemPackages", With{
+			Scope: "pkgs",
+			Body:  List{Raw("vim"), Raw("git"), Raw("curl"), Raw("wget"), Raw("htop"), Raw("tree")},
+		}),
+		{Path: []string{"tuinix", "zfs", "enable"}, Value: Bool(c.StorageMode.IsZFS()), Comment: "Storage"},
+	}
+	if c.StorageMode.IsZFS() {
+		host = append(host, Set("tuinix.zfs.encryption", Bool(c.StorageMode.IsEncrypted())))
+	}
+	if c.EnableSSH {
+		host = append(host,
+			Attr{Path: []string{"tuinix", "security", "ssh", "enable"}, Value: Bool(true), Comment: "SSH and firewall"},
+			Set("tuinix.security.firewall.enable", Bool(true)),
+		)
+	}
+	host = append(host,
+		Attr{Path: []string{"boot", "consoleLogLevel"}, Value: Int(3), Comment: "Quiet boot"},
+		Attr{
+			Path:    []string{"tuinix", "networking", "networkmanager", "enable"},
+			Value:   Bool(true),
+			Comment: "Enable NetworkManager for network management (provides nmtui)",
+		},
+		Attr{
+			Path:    []string{"tuinix", "networking", "iphone-tethering", "enable"},
+			Value:   Bool(true),
+			Comment: "Enable iPhone USB tethering support",
+		},
+		Attr{Path: []string{"i18n", "defaultLocale"}, Value: Str(c.Locale), Comment: "Locale and keyboard"},
+		Set("services.xserver.xkb.layout", Str(c.Keymap)),
+		Set("console.keyMap", Str(c.ConsoleKeyMap)),
+	)
+
+	return RenderFile(nil, Func{
+		Args: []string{"config", "lib", "pkgs", "inputs", "hostname"},
+		Body: host,
+	})
+}
+
+// DisksNix renders hosts/<hostname>/disks.nix for c's storage mode.
+// The XFS and ext4 modes fill in a template from templatesDir, unless the
+// install goes into free space next to another OS. Btrfs layouts and ZFS
+// pools, whose dataset layout is configurable, are generated.
+func DisksNix(c config.Config, templatesDir string) (string, error) {
+	if c.Alongside != nil {
+		return AlongsideDisko(c), nil
+	}
+	switch c.StorageMode {
+	case config.StorageXFS:
+		templateBytes, err := os.ReadFile(filepath.Join(templatesDir, "disko-xfs.nix"))
+		if err != nil {
+			return "", fmt.Errorf("read xfs disko template: %w", err)
+		}
+		return FillTemplate(string(templateBytes), map[string]string{
+			"DISK_DEVICE": c.DiskLink(c.Disk),
+			"SPACE_BOOT":  c.SpaceBoot,
+		})
+
+	case config.StorageLUKSXFS, config.StorageLUKSExt4:
+		templateBytes, err := os.ReadFile(filepath.Join(templatesDir, "disko-luks.nix"))
+		if err != nil {
+			return "", fmt.Errorf("read luks disko template: %w", err)
+		}
+		return FillTemplate(string(templateBytes), map[string]string{
+			"DISK_DEVICE": c.DiskLink(c.Disk),
+			"SPACE_BOOT":  c.SpaceBoot,
+			"ROOT_FORMAT": c.StorageMode.RootFormat(),
+		})
+
+	case config.StorageBtrfs, config.StorageLUKSBtrfs:
+		return BtrfsDisko(c), nil
+	}
+	if c.StorageMode.IsZFS() {
+		return ZFSDisko(c), nil
+	}
+	return "", fmt.Errorf("no disk layout for storage mode %s", c.StorageMode)
+}
@@ -0,0 +1,62 @@
+package nixgen
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	nix := func(stdout, err string, args ...string) system.RecordedCommand {
+		return system.RecordedCommand{
+			Command: system.Command{Name: "nix-instantiate", Args: args, Local: true},
+			Result:  system.Result{Stdout: stdout},
+			Error:   err,
+		}
+	}
+	version := nix("nix-instantiate (Nix) 2.24.10\n", "", "--version")
+	tests := []struct {
+		name    string
+		script  []system.RecordedCommand
+		wantErr string
+	}{
+		{
+			name:   "every file parses",
+			script: []system.RecordedCommand{version, nix("", "", "--parse", "a.nix"), nix("", "", "--parse", "b.nix")},
+		},
+		{
+			name: "a file does not parse",
+			script: []system.RecordedCommand{
+				version,
+				nix("", "", "--parse", "a.nix"),
+				{
+					Command: system.Command{Name: "nix-instantiate", Args: []string{"--parse", "b.nix"}, Local: true},
+					Result:  system.Result{Stderr: "error: syntax error, unexpected '}'\n"},
+					Error:   "exit status 1",
+				},
+			},
+			wantErr: "generated b.nix does not parse: exit status 1\nerror: syntax error, unexpected '}'",
+		},
+		{
+			name:   "nix-instantiate cannot be run",
+			script: []system.RecordedCommand{nix("", `exec: "nix-instantiate": executable file not found in $PATH`, "--version")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := system.NewReplayRunner(tt.script)
+			err := CheckSyntax(context.Background(), system.Shell{Runner: r}, "a.nix", "b.nix")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("CheckSyntax = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("CheckSyntax = %v, want %q", err, tt.wantErr)
+			}
+			for _, c := range r.Remaining() {
+				t.Errorf("scripted command never run: %s", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package nixgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lexical contexts a {{PLACEHOLDER}} can appear in
+const (
+	inCode = iota
+	inString
+	inIndentedString
+	inLineComment
+	inBlockComment
+)
+
+// FillTemplate replaces every {{NAME}} in a Nix template with vars[NAME],
+// escaped for where it appears: inside double-quoted or indented strings, in a
+// comment, or as a bare value (which becomes a quoted string). An unknown
+// placeholder is an error.
+func FillTemplate(tmpl string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	state := inCode
+	for i := 0; i < len(tmpl); {
+		if strings.HasPrefix(tmpl[i:], "{{") {
+			end := strings.Index(tmpl[i:], "}}")
+			if end > 0 {
+				name := tmpl[i+2 : i+end]
+				val, ok := vars[name]
+				if !ok {
+					return "", fmt.Errorf("template placeholder {{%s}} has no value", name)
+				}
+				switch state {
+				case inString:
+					b.WriteString(EscapeString(val))
+				case inIndentedString:
+					b.WriteString(EscapeIndented(val))
+				case inLineComment, inBlockComment:
+					b.WriteString(oneLine(val))
+				default:
+					Str(val).render(&b, 0)
+				}
+				i += end + 2
+				continue
+			}
+		}
+
+		rest := tmpl[i:]
+		n := 1
+		switch state {
+		case inCode:
+			switch {
+			case rest[0] == '#':
+				state = inLineComment
+			case strings.HasPrefix(rest, "/*"):
+				state, n = inBlockComment, 2
+			case rest[0] == '"':
+				state = inString
+			case strings.HasPrefix(rest, "''"):
+				state, n = inIndentedString, 2
+			}
+		case inString:
+			switch rest[0] {
+			case '\\':
+				n = min(2, len(rest))
+			case '"':
+				state = inCode
+			}
+		case inIndentedString:
+			switch {
+			case strings.HasPrefix(rest, "'''"):
+				n = 3
+			case strings.HasPrefix(rest, "''$"):
+				n = 3
+			case strings.HasPrefix(rest, "''\\"):
+				n = min(4, len(rest))
+			case strings.HasPrefix(rest, "''"):
+				state, n = inCode, 2
+			}
+		case inLineComment:
+			if rest[0] == '\n' {
+				state = inCode
+			}
+		case inBlockComment:
+			if strings.HasPrefix(rest, "*/") {
+				state, n = inCode, 2
+			}
+		}
+		b.WriteString(rest[:n])
+		i += n
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/nixgen"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// CleanupResult is the outcome of one action taken to tidy up the live
+// system after a failed or aborted installation
+type CleanupResult struct {
+	Action string
+	Err    error // nil if the action succeeded
+}
+
+// InstallError is the error Run and Resume return when a step fails. It
+// records what the cleanup that followed managed to undo.
+type InstallError struct {
+	Step    string // ID of the step that failed
+	Err     error
+	Cleanup []CleanupResult
+}
+
+func (e *InstallError) Error() string { return e.Err.Error() }
+
+func (e *InstallError) Unwrap() error { return e.Err }
+
+// rollback runs the Undo of the failed step and of every step before it,
+// newest first, so the live system is left as it was before the install:
+// nothing mounted at /mnt, no pool imported, its own hostid and no work
+// directory. It keeps going past failed actions. The saved progress is
+// kept, so the install can still be resumed.
+//
+// Cleanup must run even when the install was aborted by cancelling ctx,
+// so its commands use a context that is never cancelled.
+func (in *Installer) rollback(ctx context.Context, c config.Config, steps []Step, failed int) []CleanupResult {
+	if in.DryRun {
+		// Nothing was changed, and the work directory is the dry run's output
+		return nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	system.LogInfo("=== Cleaning up after failed installation ===")
+
+	in.cleanup = nil
+	for i := failed; i >= 0; i-- {
+		if steps[i].Undo == nil {
+			continue
+		}
+		system.LogInfo("Undoing step %d: %s", i+1, steps[i].Name)
+		if err := steps[i].Undo(in, ctx, c); err != nil {
+			system.LogError("Undo of %s incomplete: %v", steps[i].ID, err)
+		}
+	}
+	in.saveProgress(c)
+	return in.cleanup
+}
+
+// undoAction runs one cleanup action, reporting its result in the log, on
+// the event stream and in the InstallError
+func (in *Installer) undoAction(name string, action func() error) error {
+	err := action()
+	res := CleanupResult{Action: name, Err: err}
+	ev := Event{Type: EventCleanup, Name: name}
+	if err != nil {
+		system.LogError("Cleanup: %s failed: %v", name, err)
+		ev.Error = err.Error()
+	} else {
+		system.LogInfo("Cleanup: %s", name)
+	}
+	in.cleanup = append(in.cleanup, res)
+	in.emit(ev)
+	return err
+}
+
+// undoHostConfig removes the work directory with the generated flake. The
+// saved progress keeps a copy of the generated files for Resume.
+func (in *Installer) undoHostConfig(ctx context.Context, c config.Config) error {
+	return in.undoAction("Remove "+c.WorkDir, func() error {
+		return os.RemoveAll(c.WorkDir)
+	})
+}
+
+// undoFormat releases the target disks: it unmounts /mnt, exports the pool
+// or closes the LUKS container and gives the live system its own hostid back
+func (in *Installer) undoFormat(ctx context.Context, c config.Config) error {
+	var errs []error
+	if _, err := in.sh.Query(ctx, "findmnt", "-n", "/mnt"); err == nil {
+		errs = append(errs, in.undoAction("Unmount /mnt", func() error {
+			_, err := in.sh.Exec(ctx, "umount", "-R", "/mnt")
+			return err
+		}))
+	}
+	in.progress.Mounted = false
+
+	if c.StorageMode.IsZFS() {
+		if _, err := in.sh.Query(ctx, "zpool", "list", "-H", "-o", "name", c.ZFSPoolName); err == nil {
+			errs = append(errs, in.undoAction("Export pool "+c.ZFSPoolName, func() error {
+				_, err := in.sh.Exec(ctx, "zpool", "export", c.ZFSPoolName)
+				return err
+			}))
+		}
+		in.progress.Pool = ""
+	}
+
+	if c.StorageMode.IsLUKS() {
+		if _, err := in.host().Stat("/dev/mapper/" + nixgen.LUKSName); err == nil {
+			errs = append(errs, in.undoAction("Close LUKS container "+nixgen.LUKSName, func() error {
+				_, err := in.sh.Exec(ctx, "cryptsetup", "close", nixgen.LUKSName)
+				return err
+			}))
+		}
+	}
+
+	if in.progress.HostIDChanged {
+		errs = append(errs, in.undoAction("Restore the live system's hostid", func() error {
+			return in.restoreLiveHostID(ctx)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// undoHardwareConfig removes nixos-generate-config's scratch output
+func (in *Installer) undoHardwareConfig(ctx context.Context, c config.Config) error {
+	return in.undoAction("Remove /tmp/nixos-config", func() error {
+		_, err := in.sh.Exec(ctx, "rm", "-rf", "/tmp/nixos-config")
+		return err
+	})
+}
+
+// setHostID gives the live system the install's hostid, so the pool is
+// created (or imported) as belonging to the new system. The live system's
+// own hostid is saved first, once, for restoreLiveHostID.
+func (in *Installer) setHostID(ctx context.Context, c config.Config) error {
+	if !in.progress.HostIDChanged {
+		// /etc/hostid holds the ID as a native-endian uint32, little-endian
+		// on every platform tuinix supports
+		in.progress.LiveHostID = ""
+		if data, err := in.host().ReadFile("/etc/hostid"); err == nil && len(data) >= 4 {
+			in.progress.LiveHostID = fmt.Sprintf("%08x", binary.LittleEndian.Uint32(data))
+		}
+		system.LogInfo("Live system hostid: %q", in.progress.LiveHostID)
+	}
+
+	in.sh.Exec(ctx, "rm", "-f", "/etc/hostid")
+	in.progress.HostIDChanged = true
+	if _, err := in.sh.Exec(ctx, "zgenhostid", c.HostID); err != nil {
+		return fmt.Errorf("zgenhostid: %w", err)
+	}
+	return nil
+}
+
+// restoreLiveHostID puts back the hostid setHostID replaced, or removes
+// /etc/hostid if the live system had none
+func (in *Installer) restoreLiveHostID(ctx context.Context) error {
+	if _, err := in.sh.Exec(ctx, "rm", "-f", "/etc/hostid"); err != nil {
+		return err
+	}
+	if id := strings.TrimSpace(in.progress.LiveHostID); id != "" {
+		if _, err := in.sh.Exec(ctx, "zgenhostid", id); err != nil {
+			return err
+		}
+	}
+	in.progress.HostIDChanged = false
+	return nil
+}
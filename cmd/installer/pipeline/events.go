@@ -0,0 +1,44 @@
+package pipeline
+
+import "strings"
+
+// Event types reported while an installation runs
+const (
+	EventInstallStarted  = "install_started"
+	EventStepStarted     = "step_started"
+	EventStepFinished    = "step_finished"
+	EventStepSkipped     = "step_skipped"
+	EventStepFailed      = "step_failed"
+	EventCommand         = "command"
+	EventInstallFinished = "install_finished"
+	EventInstallFailed   = "install_failed"
+	EventCleanup         = "cleanup"
+)
+
+// Longest command output excerpt carried by an event
+const eventOutputLimit = 2000
+
+// Event is one progress report from Installer.Run. Its JSON form is the
+// installer's machine-readable progress stream. Step indexes start at 1,
+// matching the "Step N" lines in the install log.
+type Event struct {
+	Time        string  `json:"time"`
+	Type        string  `json:"type"`
+	Index       int     `json:"index,omitempty"`
+	Total       int     `json:"total,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Destructive bool    `json:"destructive,omitempty"`
+	Duration    float64 `json:"duration_seconds,omitempty"`
+	Command     string  `json:"command,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	Output      string  `json:"output,omitempty"`
+}
+
+// outputExcerpt keeps the tail of a command's output, where errors usually are
+func outputExcerpt(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > eventOutputLimit {
+		output = "..." + output[len(output)-eventOutputLimit:]
+	}
+	return output
+}
@@ -0,0 +1,252 @@
+// Package pipeline runs a tuinix installation: it generates the host
+// flake, formats the disks with disko, installs NixOS and hands the flake
+// over to the new system.
+//
+// A minimal client builds a config.Config, checks it and runs it:
+//
+//	in := &pipeline.Installer{
+//		Progress: func(ev pipeline.Event) { log.Println(ev.Type, ev.Name) },
+//	}
+//	if err := in.Run(ctx, c); err != nil {
+//		// the failing step was reported as an EventStepFailed
+//	}
+//
+// The interactive installer is one such client.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// Installer runs installations. The zero value runs real commands and
+// reports no progress. An Installer runs one installation at a time.
+type Installer struct {
+	// Runner executes every external command; nil means system.ExecRunner
+	Runner system.Runner
+
+	// Host reads the live system's sysfs, /proc, /dev and /etc/hostid;
+	// nil means the live system itself
+	Host system.Host
+
+	// Progress, if set, receives every Event synchronously from the
+	// goroutine calling Run, so it should return quickly
+	Progress func(Event)
+
+	// DryRun generates the host files but only records the commands that
+	// would change the machine. Planned returns them after Run.
+	DryRun bool
+
+	// LogFile, if set, is copied into the new user's home directory
+	LogFile string
+
+	// StateFile, if set, records the installation's Progress after every
+	// step so that Resume can continue it after a failure
+	StateFile string
+
+	sh         system.Shell
+	plan       *system.DryRunRunner
+	progress   *Progress
+	cleanup    []CleanupResult // Results of the rollback in progress
+	lastOutput string          // Output excerpt of the most recent command
+}
+
+// Planned returns the commands a dry run recorded instead of running
+func (in *Installer) Planned() []system.Command {
+	if in.plan == nil {
+		return nil
+	}
+	return in.plan.Recorded
+}
+
+func (in *Installer) emit(ev Event) {
+	ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if in.Progress != nil {
+		in.Progress(ev)
+	}
+}
+
+// commandDone reports each command on the progress stream and remembers
+// its output for a step_failed event
+func (in *Installer) commandDone(c system.Command, res system.Result, err error) {
+	ev := Event{Type: EventCommand, Command: system.FormatCommand(c.Name, c.Args), Output: outputExcerpt(res.Combined())}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	in.lastOutput = ev.Output
+	in.emit(ev)
+}
+
+// stepTracker emits the progress events for one run of the pipeline
+type stepTracker struct {
+	in      *Installer
+	steps   []Step
+	started time.Time
+}
+
+func (t *stepTracker) start(i int) {
+	t.started = time.Now()
+	t.in.emit(Event{
+		Type:        EventStepStarted,
+		Index:       i + 1,
+		Total:       len(t.steps),
+		Name:        t.steps[i].Name,
+		Destructive: t.steps[i].Destructive,
+	})
+}
+
+func (t *stepTracker) skip(i int) {
+	t.in.emit(Event{Type: EventStepSkipped, Index: i + 1, Total: len(t.steps), Name: t.steps[i].Name})
+}
+
+func (t *stepTracker) finish(i int) {
+	t.in.emit(Event{
+		Type:     EventStepFinished,
+		Index:    i + 1,
+		Total:    len(t.steps),
+		Name:     t.steps[i].Name,
+		Duration: time.Since(t.started).Seconds(),
+	})
+}
+
+// fail reports a failed step
+func (t *stepTracker) fail(i int, err error) {
+	t.in.emit(Event{
+		Type:     EventStepFailed,
+		Index:    i + 1,
+		Total:    len(t.steps),
+		Name:     t.steps[i].Name,
+		Duration: time.Since(t.started).Seconds(),
+		Error:    err.Error(),
+		Output:   t.in.lastOutput,
+	})
+}
+
+// Run installs c, running every step in order and stopping at the first
+// failure. Cancelling ctx stops the running command and fails its step.
+func (in *Installer) Run(ctx context.Context, c config.Config) error {
+	if err := c.Validate(); err != nil {
+		err = fmt.Errorf("invalid configuration: %w", err)
+		in.emit(Event{Type: EventInstallFailed, Error: err.Error()})
+		return err
+	}
+
+	in.start(c)
+	in.progress = &Progress{}
+	system.LogInfo("=== Starting installation ===")
+	in.logConfig(c)
+
+	tracker := &stepTracker{in: in, steps: Steps(c)}
+	in.emit(Event{Type: EventInstallStarted, Total: len(tracker.steps)})
+	return in.runSteps(ctx, c, tracker)
+}
+
+// Resume continues the installation recorded in p, which must be
+// Resumable. It restores the generated flake, re-imports and unlocks the
+// pool with passphrase, remounts /mnt and then runs the steps that did not
+// complete; the completed ones are reported as skipped.
+func (in *Installer) Resume(ctx context.Context, p *Progress, passphrase string) error {
+	if !p.Resumable() {
+		err := fmt.Errorf("there is no formatted, unfinished installation to resume")
+		in.emit(Event{Type: EventInstallFailed, Error: err.Error()})
+		return err
+	}
+	c := p.Config
+	c.Passphrase = passphrase
+
+	in.start(c)
+	in.progress = p
+	p.Failed = ""
+	system.LogInfo("=== Resuming installation at %q ===", p.NextStep())
+	in.logConfig(c)
+
+	tracker := &stepTracker{in: in, steps: Steps(c)}
+	in.emit(Event{Type: EventInstallStarted, Total: len(tracker.steps)})
+	if err := in.reopenTarget(ctx, c, p); err != nil {
+		system.LogError("reopenTarget failed: %v", err)
+		err = fmt.Errorf("reopen the formatted disks: %w", err)
+		return in.failed(ctx, c, tracker.steps, len(tracker.steps)-1, &InstallError{Err: err})
+	}
+	in.saveProgress(c)
+	return in.runSteps(ctx, c, tracker)
+}
+
+// failed cleans up after the failure ierr describes, undoing steps[:last+1],
+// and reports the end of the installation
+func (in *Installer) failed(ctx context.Context, c config.Config, steps []Step, last int, ierr *InstallError) error {
+	ierr.Cleanup = in.rollback(ctx, c, steps, last)
+	in.emit(Event{Type: EventInstallFailed, Error: ierr.Err.Error()})
+	return ierr
+}
+
+// start prepares the command shell for one run of the pipeline
+func (in *Installer) start(c config.Config) {
+	runner := in.Runner
+	if runner == nil {
+		runner = system.ExecRunner{}
+	}
+	in.plan = nil
+	if in.DryRun {
+		in.plan = &system.DryRunRunner{Next: runner}
+		runner = in.plan
+	}
+	in.sh = system.Shell{Runner: runner, OnCommand: in.commandDone}
+	in.lastOutput = ""
+}
+
+// host returns in.Host, or the live system if it is nil
+func (in *Installer) host() system.Host {
+	if in.Host == nil {
+		return system.RootHost("")
+	}
+	return in.Host
+}
+
+func (in *Installer) logConfig(c config.Config) {
+	system.LogInfo("Config: Username=%s, Hostname=%s, Disk=%s, StorageMode=%s, EnableSSH=%v", c.Username, c.Hostname, c.Disk, c.StorageMode, c.EnableSSH)
+	if c.StorageMode.IsMultiDisk() {
+		system.LogInfo("Config: Disks=%v", c.Disks)
+	}
+	system.LogInfo("Config: ProjectRoot=%s, WorkDir=%s", c.ProjectRoot, c.WorkDir)
+}
+
+// runSteps runs the tracker's steps that have not already completed,
+// saving progress after each one
+func (in *Installer) runSteps(ctx context.Context, c config.Config, tracker *stepTracker) error {
+	for i, step := range tracker.steps {
+		if in.progress.done(step.ID) {
+			system.LogInfo("Step %d: %s already complete, skipping", i+1, step.Name)
+			tracker.skip(i)
+			continue
+		}
+		system.LogInfo("Step %d: %s...", i+1, step.Name)
+		tracker.start(i)
+		err := step.Run(in, ctx, c)
+		if ctx.Err() != nil {
+			// Stopped: report why, even if the step carried on past its
+			// cancelled commands and returned nil
+			err = context.Cause(ctx)
+		}
+		if err != nil {
+			system.LogError("Step %d (%s) failed: %v", i+1, step.ID, err)
+			in.progress.Failed = step.ID
+			in.saveProgress(c)
+			err = fmt.Errorf("%s: %w", step.Name, err)
+			tracker.fail(i, err)
+			return in.failed(ctx, c, tracker.steps, i, &InstallError{Step: step.ID, Err: err})
+		}
+		in.progress.Completed = append(in.progress.Completed, step.ID)
+		in.saveProgress(c)
+		tracker.finish(i)
+		system.LogInfo("Step %d complete", i+1)
+	}
+
+	in.clearProgress()
+	system.LogInfo("=== Installation complete ===")
+	in.emit(Event{Type: EventInstallFinished})
+	return nil
+}
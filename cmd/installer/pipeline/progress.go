@@ -0,0 +1,230 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/nixgen"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// Progress is what Installer saves to its StateFile after every step, so an
+// installation that fails after the disks were formatted can be resumed
+// instead of started over
+type Progress struct {
+	// Config is the installation's configuration without the account
+	// password and disk passphrase, which are never written to disk
+	Config config.Config `json:"config"`
+
+	// Completed lists the IDs of the steps that finished
+	Completed []string `json:"completed"`
+
+	// Failed is the ID of the step that failed, if any
+	Failed string `json:"failed,omitempty"`
+
+	// Pool is the ZFS pool left imported on the live system, if any
+	Pool string `json:"pool,omitempty"`
+
+	// Mounted reports whether the target is mounted at /mnt
+	Mounted bool `json:"mounted"`
+
+	// HostIDChanged is set while the live system runs with the install's
+	// hostid; LiveHostID is its own ("" if it had none) for cleanup to
+	// restore
+	HostIDChanged bool   `json:"hostid_changed,omitempty"`
+	LiveHostID    string `json:"live_hostid,omitempty"`
+
+	// Files holds the generated host and user files, by path relative to
+	// the work directory, so they survive the work directory being removed
+	Files map[string]string `json:"files,omitempty"`
+
+	Updated string `json:"updated"`
+}
+
+// LoadProgress reads a progress file written by Installer. A missing file
+// returns nil and no error.
+func LoadProgress(path string) (*Progress, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read progress file: %w", err)
+	}
+	var p Progress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse progress file %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Resumable reports whether Resume can continue p: the disks were
+// formatted, so starting over would throw that work away, and some steps
+// are still to run
+func (p *Progress) Resumable() bool {
+	if p == nil || !p.done("format-disks") {
+		return false
+	}
+	for _, s := range Steps(p.Config) {
+		if !p.done(s.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStep names the first step Resume would run
+func (p *Progress) NextStep() string {
+	for _, s := range Steps(p.Config) {
+		if !p.done(s.ID) {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+func (p *Progress) done(id string) bool {
+	for _, c := range p.Completed {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+// saveProgress records the installation's state in in.StateFile. Failing
+// to save never fails the install; it only costs the ability to resume.
+func (in *Installer) saveProgress(c config.Config) {
+	if in.StateFile == "" || in.progress == nil {
+		return
+	}
+	p := in.progress
+	p.Config = c
+	p.Config.Password = ""
+	p.Config.Passphrase = ""
+	// Merge rather than replace: cleanup removes the work directory, and
+	// the saved copies are then the only ones left
+	if p.Files == nil {
+		p.Files = map[string]string{}
+	}
+	for rel, content := range hostFiles(c) {
+		p.Files[rel] = content
+	}
+	p.Updated = time.Now().UTC().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err == nil {
+		err = os.WriteFile(in.StateFile, append(data, '\n'), 0600)
+	}
+	if err != nil {
+		system.LogError("Failed to save progress to %s: %v", in.StateFile, err)
+	}
+}
+
+// clearProgress removes the progress file once there is nothing to resume
+func (in *Installer) clearProgress() {
+	if in.StateFile == "" {
+		return
+	}
+	if err := os.Remove(in.StateFile); err != nil && !os.IsNotExist(err) {
+		system.LogError("Failed to remove progress file %s: %v", in.StateFile, err)
+	}
+}
+
+// hostFiles reads the generated files for c's host and user from the work
+// directory
+func hostFiles(c config.Config) map[string]string {
+	files := map[string]string{}
+	paths, _ := filepath.Glob(filepath.Join(nixgen.HostDir(c), "*.nix"))
+	paths = append(paths, filepath.Join(c.WorkDir, "users", c.Username+".nix"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		rel, _ := filepath.Rel(c.WorkDir, path)
+		files[rel] = string(data)
+	}
+	return files
+}
+
+// reopenTarget brings the live system back to where the failed install
+// left it: the work directory holds the generated flake, the live system
+// has the install's hostid, the pool is imported and unlocked and the
+// target is mounted at /mnt. LUKS containers are opened with the passphrase
+// first.
+func (in *Installer) reopenTarget(ctx context.Context, c config.Config, p *Progress) error {
+	if _, err := os.Stat(filepath.Join(c.WorkDir, "flake.nix")); err != nil {
+		system.LogInfo("reopenTarget: restoring work directory %s", c.WorkDir)
+		if err := os.MkdirAll(c.WorkDir, 0755); err != nil {
+			return fmt.Errorf("create work dir: %w", err)
+		}
+		if _, err := in.sh.Local(ctx, "cp", "-rL", c.ProjectRoot+"/.", c.WorkDir+"/"); err != nil {
+			return fmt.Errorf("copy project from %s to %s: %w", c.ProjectRoot, c.WorkDir, err)
+		}
+	}
+	for rel, content := range p.Files {
+		path := filepath.Join(c.WorkDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("create %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return fmt.Errorf("restore %s: %w", rel, err)
+		}
+	}
+
+	if c.StorageMode.IsZFS() {
+		system.LogInfo("reopenTarget: setting hostid %s", c.HostID)
+		if err := in.setHostID(ctx, c); err != nil {
+			return err
+		}
+
+		if _, err := in.sh.Query(ctx, "zpool", "list", "-H", "-o", "name", c.ZFSPoolName); err != nil {
+			system.LogInfo("reopenTarget: importing pool %s", c.ZFSPoolName)
+			if _, err := in.sh.Exec(ctx, "zpool", "import", "-f", "-N", "-R", "/mnt", c.ZFSPoolName); err != nil {
+				return fmt.Errorf("import pool %s: %w", c.ZFSPoolName, err)
+			}
+		}
+		in.progress.Pool = c.ZFSPoolName
+
+		if c.StorageMode.IsEncrypted() {
+			status, _ := in.sh.Query(ctx, "zfs", "get", "-H", "-o", "value", "keystatus", c.ZFSPoolName)
+			if strings.TrimSpace(status) != "available" {
+				system.LogInfo("reopenTarget: unlocking pool %s", c.ZFSPoolName)
+				if _, err := in.sh.ExecInput(ctx, c.Passphrase+"\n", "zfs", "load-key", c.ZFSPoolName); err != nil {
+					return fmt.Errorf("unlock pool %s: %w", c.ZFSPoolName, err)
+				}
+			}
+		}
+	}
+
+	if c.StorageMode.IsLUKS() {
+		if _, err := in.host().Stat("/dev/mapper/" + nixgen.LUKSName); err != nil {
+			system.LogInfo("reopenTarget: opening LUKS container %s", nixgen.LUKSDevice(c))
+			if _, err := in.sh.ExecInput(ctx, c.Passphrase, "cryptsetup", "open", "--key-file=-", nixgen.LUKSDevice(c), nixgen.LUKSName); err != nil {
+				return fmt.Errorf("open LUKS container: %w", err)
+			}
+		}
+	}
+
+	if _, err := in.sh.Query(ctx, "findmnt", "-n", "/mnt"); err != nil {
+		diskoConfig := filepath.Join(nixgen.HostDir(c), "disks.nix")
+		system.LogInfo("reopenTarget: mounting the target with disko --mode mount")
+		if _, err := in.sh.Exec(ctx, "disko", "--mode", "mount", diskoConfig); err != nil {
+			return fmt.Errorf("mount target: %w", err)
+		}
+	}
+	if c.Alongside != nil {
+		if err := in.mountESP(ctx, c); err != nil {
+			return err
+		}
+	}
+	in.progress.Mounted = true
+	return nil
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+)
+
+// Step is one entry in the install pipeline. Run walks the registry in
+// order and performs every step whose When accepts the configuration, so
+// the progress display, the event stream and the install log all number
+// steps from the same list.
+type Step struct {
+	// ID identifies the step in the install log; it does not change
+	// between releases
+	ID string
+
+	// Name is shown in the progress display and the event stream
+	Name string
+
+	// When reports whether the step applies to c; nil means always
+	When func(c config.Config) bool
+
+	// Run performs the step
+	Run func(in *Installer, ctx context.Context, c config.Config) error
+
+	// Undo reverses Run after a later step fails; nil when the step
+	// leaves nothing behind that needs reversing
+	Undo func(in *Installer, ctx context.Context, c config.Config) error
+
+	// Destructive steps overwrite the target disks. Stopping one part way
+	// through can leave the disks unusable.
+	Destructive bool
+}
+
+// registry lists every install step in the order Run performs them
+var registry = []Step{
+	{
+		ID:   "generate-host-config",
+		Name: "Generating host configuration",
+		Run:  (*Installer).generateHostConfig,
+		Undo: (*Installer).undoHostConfig,
+	},
+	{
+		ID:          "release-disks",
+		Name:        "Releasing old storage on the disk(s)",
+		When:        erasesDisks,
+		Run:         (*Installer).releaseDisks,
+		Destructive: true,
+	},
+	{
+		ID:          "format-disks",
+		Name:        "Formatting disk(s) with ZFS",
+		When:        isZFS,
+		Run:         (*Installer).formatDisk,
+		Undo:        (*Installer).undoFormat,
+		Destructive: true,
+	},
+	{
+		ID:          "format-disks",
+		Name:        "Formatting disk with LUKS encryption",
+		When:        isLUKS,
+		Run:         (*Installer).formatDisk,
+		Undo:        (*Installer).undoFormat,
+		Destructive: true,
+	},
+	{
+		ID:          "format-disks",
+		Name:        "Formatting disk with Btrfs",
+		When:        modeIs(config.StorageBtrfs),
+		Run:         (*Installer).formatDisk,
+		Undo:        (*Installer).undoFormat,
+		Destructive: true,
+	},
+	{
+		ID:          "format-disks",
+		Name:        "Formatting disk with XFS",
+		When:        modeIs(config.StorageXFS),
+		Run:         (*Installer).formatDisk,
+		Undo:        (*Installer).undoFormat,
+		Destructive: true,
+	},
+	{
+		ID:   "generate-hardware-config",
+		Name: "Generating hardware configuration",
+		Run:  (*Installer).generateHardwareConfig,
+		Undo: (*Installer).undoHardwareConfig,
+	},
+	{
+		ID:   "install-nixos",
+		Name: "Installing NixOS",
+		Run:  (*Installer).installNixOS,
+	},
+	{
+		ID:   "configure-zfs-boot",
+		Name: "Configuring ZFS boot",
+		When: isZFS,
+		Run:  (*Installer).configureZFSBoot,
+	},
+	{
+		ID:   "copy-flake",
+		Name: "Copying flake to new system",
+		Run:  (*Installer).copyFlake,
+	},
+	{
+		ID:   "setup-user-flake",
+		Name: "Setting up user flake",
+		Run:  (*Installer).setupUserFlake,
+	},
+	{
+		// Copied while /mnt is still mounted, before finalization unmounts it
+		ID:   "copy-install-log",
+		Name: "Copying install log",
+		Run:  (*Installer).copyInstallLog,
+	},
+	{
+		ID:   "finalize-zfs-pool",
+		Name: "Finalizing ZFS pool",
+		When: isZFS,
+		Run:  (*Installer).finalizeZFSPool,
+	},
+}
+
+func erasesDisks(c config.Config) bool { return c.Alongside == nil }
+
+func isZFS(c config.Config) bool { return c.StorageMode.IsZFS() }
+
+func isLUKS(c config.Config) bool { return c.StorageMode.IsLUKS() }
+
+func modeIs(mode config.StorageMode) func(config.Config) bool {
+	return func(c config.Config) bool { return c.StorageMode == mode }
+}
+
+// Steps returns the steps Run performs for c, in order
+func Steps(c config.Config) []Step {
+	var steps []Step
+	for _, s := range registry {
+		if s.When == nil || s.When(c) {
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
+
+// StepNames lists the names of the steps Run performs for c, in order
+func StepNames(c config.Config) []string {
+	var names []string
+	for _, s := range Steps(c) {
+		names = append(names, s.Name)
+	}
+	return names
+}
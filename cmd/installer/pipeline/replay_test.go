@@ -0,0 +1,409 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// The replay tests run whole installs against the fixtures in testdata:
+// command recordings in the format of the installer's --record flag, with
+// the flake checkout and work directory written as @ROOT@ and @WORK@ and
+// timestamps as @TIME@. Every command an install runs must match the next
+// recorded one exactly, so a fixture pins down the full command sequence
+// of a mode.
+
+var timestampRe = regexp.MustCompile(`\d{4}-\d\d-\d\d \d\d:\d\d:\d\d UTC`)
+
+// fixtureRunner writes the test's own paths and times in commands back as
+// the fixtures' placeholders before passing them on. It also really copies
+// the flake checkout, since the later steps read the copy.
+type fixtureRunner struct {
+	next       system.Runner
+	root, work string
+}
+
+func (r *fixtureRunner) Run(ctx context.Context, c system.Command) (system.Result, error) {
+	if c.Name == "cp" && len(c.Args) == 3 && c.Args[0] == "-rL" {
+		if err := copyTree(strings.TrimSuffix(c.Args[1], "/."), c.Args[2]); err != nil {
+			return system.Result{}, err
+		}
+	}
+	c.Args = r.placeholders(c.Args)
+	c.Env = r.placeholders(c.Env)
+	return r.next.Run(ctx, c)
+}
+
+func (r *fixtureRunner) placeholders(args []string) []string {
+	if args == nil {
+		return nil
+	}
+	out := make([]string, len(args))
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, r.work, "@WORK@")
+		arg = strings.ReplaceAll(arg, r.root, "@ROOT@")
+		out[i] = timestampRe.ReplaceAllString(arg, "@TIME@")
+	}
+	return out
+}
+
+// copyTree copies the directory src into dst, like cp -r src/. dst/
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// replayTest is an install set up to run against one fixture
+type replayTest struct {
+	in     *Installer
+	c      config.Config
+	replay *system.ReplayRunner
+	host   string // Directory standing in for the live system's /
+}
+
+// newReplayTest prepares an install of c against testdata/fixture: a flake
+// checkout holding the repository's disko templates, an empty work
+// directory and a live system whose disks hold nothing
+func newReplayTest(t *testing.T, fixture string, change func(c *config.Config)) *replayTest {
+	t.Helper()
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "flake")
+	if err := copyTree("../../../templates", filepath.Join(root, "templates")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "flake.nix"), "{ outputs = _: { }; }\n")
+	writeFile(t, filepath.Join(root, "users/admin.nix"), "{ }\n")
+
+	host := filepath.Join(dir, "host")
+	writeFile(t, filepath.Join(host, "etc/hostid"), "\x0c\xb1\xba\x00")
+	writeFile(t, filepath.Join(host, "proc/swaps"), "Filename\tType\tSize\tUsed\tPriority\n")
+	for _, dev := range []string{"vda", "vda1", "vda2"} {
+		if err := os.MkdirAll(filepath.Join(host, sysClassBlock, dev, "holders"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script, err := system.LoadReplayScript(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := system.NewReplayRunner(script)
+
+	c := config.Config{
+		Username:      "tim",
+		Fullname:      "Tim Sutton",
+		Email:         "tim@example.org",
+		PasswordHash:  "$6$saltsalt$hash",
+		Hostname:      "tuinix-test",
+		Disk:          "/dev/vda",
+		Disks:         []string{"/dev/vda"},
+		DiskLinks:     map[string]string{"/dev/vda": "/dev/disk/by-id/virtio-tuinix0"},
+		HostID:        "8425e349",
+		Passphrase:    "correct horse battery",
+		StorageMode:   config.StorageZFSEncryptedSingle,
+		Locale:        "en_US.UTF-8",
+		Keymap:        "us",
+		ConsoleKeyMap: "us",
+		SpaceTotalGB:  100,
+		SpaceBoot:     "5G",
+		SpaceNix:      "20G",
+		SpaceAtuin:    "1G",
+		ZFSPoolName:   "NIXROOT",
+		ProjectRoot:   root,
+		WorkDir:       filepath.Join(dir, "work"),
+	}
+	if change != nil {
+		change(&c)
+	}
+
+	return &replayTest{
+		in: &Installer{
+			Runner: &fixtureRunner{next: replay, root: root, work: c.WorkDir},
+			Host:   system.RootHost(host),
+		},
+		c:      c,
+		replay: replay,
+		host:   host,
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkReplayed fails the test if the install stopped short of the end of
+// its fixture
+func (rt *replayTest) checkReplayed(t *testing.T) {
+	t.Helper()
+	for _, c := range rt.replay.Remaining() {
+		t.Errorf("recorded command never run: %s", c)
+	}
+}
+
+// readHostFile returns a generated file of the host being installed
+func (rt *replayTest) readHostFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(rt.c.WorkDir, "hosts", rt.c.Hostname, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// onDisks switches an install to mode on the first n of /dev/vda,
+// /dev/vdb, ..., each with a by-id link
+func onDisks(mode config.StorageMode, n int) func(c *config.Config) {
+	return func(c *config.Config) {
+		c.StorageMode = mode
+		c.Disks = nil
+		c.DiskLinks = map[string]string{}
+		for i := 0; i < n; i++ {
+			d := "/dev/vd" + string(rune('a'+i))
+			c.Disks = append(c.Disks, d)
+			c.DiskLinks[d] = fmt.Sprintf("/dev/disk/by-id/virtio-tuinix%d", i)
+		}
+	}
+}
+
+// replayInstalls are the installs TestReplayInstall replays: every storage
+// mode, and the variants that change its command sequence
+var replayInstalls = []struct {
+	name, fixture string
+	change        func(c *config.Config)
+	disks         []string // Expected in disks.nix
+	hardware      []string // Expected in hardware.nix
+}{
+	{
+		name:    "encrypted ZFS",
+		fixture: "zfs.jsonl",
+		disks:   []string{`device = "/dev/disk/by-id/virtio-tuinix0"`, `NIXROOT = {`, `encryption = "aes-256-gcm"`},
+	},
+	{
+		name:    "XFS",
+		fixture: "xfs.jsonl",
+		change: func(c *config.Config) {
+			c.StorageMode = config.StorageXFS
+			c.SpaceNix, c.SpaceAtuin = "", ""
+		},
+		disks: []string{`disk = "/dev/disk/by-id/virtio-tuinix0"`, `format = "xfs"`},
+	},
+	{
+		name:    "LUKS-encrypted XFS",
+		fixture: "luks-xfs.jsonl",
+		change: func(c *config.Config) {
+			c.StorageMode = config.StorageLUKSXFS
+			c.SpaceNix, c.SpaceAtuin = "", ""
+		},
+		disks: []string{`"/dev/disk/by-id/virtio-tuinix0"`, `type = "luks"`, `format = "xfs"`},
+	},
+	{
+		name:    "LUKS-encrypted ext4",
+		fixture: "luks-ext4.jsonl",
+		change: func(c *config.Config) {
+			c.StorageMode = config.StorageLUKSExt4
+			c.SpaceNix, c.SpaceAtuin = "", ""
+		},
+		disks: []string{`"/dev/disk/by-id/virtio-tuinix0"`, `type = "luks"`, `format = "ext4"`},
+	},
+	{
+		name:    "Btrfs",
+		fixture: "btrfs.jsonl",
+		change: func(c *config.Config) {
+			c.StorageMode = config.StorageBtrfs
+			c.SpaceAtuin = ""
+		},
+		disks: []string{`device = "/dev/disk/by-id/virtio-tuinix0"`, `type = "btrfs"`, `btrfs qgroup limit 20G "$MNTPOINT/nix"`},
+	},
+	{
+		name:    "LUKS-encrypted Btrfs",
+		fixture: "luks-btrfs.jsonl",
+		change: func(c *config.Config) {
+			c.StorageMode = config.StorageLUKSBtrfs
+			c.SpaceAtuin = ""
+		},
+		disks: []string{`device = "/dev/disk/by-id/virtio-tuinix0"`, `type = "luks"`, `type = "btrfs"`},
+	},
+	{
+		name:    "LUKS-encrypted Btrfs alongside another OS",
+		fixture: "alongside.jsonl",
+		change: func(c *config.Config) {
+			c.StorageMode = config.StorageLUKSBtrfs
+			c.SpaceBoot, c.SpaceAtuin = "", ""
+			c.Alongside = &config.Alongside{
+				Start:      42993664,
+				Sectors:    75497472,
+				SectorSize: 512,
+				PartUUID:   "6a8d2f41-93c3-4b1e-8f0d-5c2e7b9a1d34",
+				ESP:        "/dev/vda1",
+				ESPUUID:    "A1B2-C3D4",
+				ESPBytes:   512 << 20,
+			}
+			c.SpaceTotalGB = c.Alongside.SizeGB()
+		},
+		disks: []string{`device = "/dev/disk/by-partuuid/6a8d2f41-93c3-4b1e-8f0d-5c2e7b9a1d34"`, `type = "luks"`, `type = "btrfs"`},
+	},
+	{
+		name:    "ZFS stripe",
+		fixture: "zfs-stripe.jsonl",
+		change:  onDisks(config.StorageZFSStripe, 2),
+		disks:   []string{`"/dev/disk/by-id/virtio-tuinix0"`, `"/dev/disk/by-id/virtio-tuinix1"`, `mode = ""`},
+	},
+	{
+		name:    "ZFS mirror",
+		fixture: "zfs-mirror.jsonl",
+		change:  onDisks(config.StorageZFSMirror, 2),
+		disks:   []string{`"/dev/disk/by-id/virtio-tuinix1"`, `mode = "mirror"`},
+	},
+	{
+		name:    "ZFS striped mirrors",
+		fixture: "zfs-raid10.jsonl",
+		change:  onDisks(config.StorageZFSStripedMirror, 4),
+		disks:   []string{`"/dev/disk/by-id/virtio-tuinix3"`, `mode = "mirror"`},
+	},
+	{
+		name:    "ZFS striped mirrors with an ESP on every disk",
+		fixture: "zfs-raid10-esps.jsonl",
+		change: func(c *config.Config) {
+			onDisks(config.StorageZFSStripedMirror, 4)(c)
+			c.RedundantESP = true
+			c.SpaceBoot = "1G"
+		},
+		disks:    []string{`"/dev/disk/by-id/virtio-tuinix3"`, `mode = "mirror"`},
+		hardware: []string{"mirroredBoots"},
+	},
+	{
+		name:    "ZFS raidz",
+		fixture: "zfs-raidz.jsonl",
+		change:  onDisks(config.StorageZFSRaidz, 3),
+		disks:   []string{`"/dev/disk/by-id/virtio-tuinix2"`, `mode = "raidz"`},
+	},
+	{
+		name:    "ZFS raidz2",
+		fixture: "zfs-raidz2.jsonl",
+		change:  onDisks(config.StorageZFSRaidz2, 4),
+		disks:   []string{`"/dev/disk/by-id/virtio-tuinix3"`, `mode = "raidz2"`},
+	},
+	{
+		name:    "ZFS raidz3",
+		fixture: "zfs-raidz3.jsonl",
+		change:  onDisks(config.StorageZFSRaidz3, 5),
+		disks:   []string{`"/dev/disk/by-id/virtio-tuinix4"`, `mode = "raidz3"`},
+	},
+}
+
+func TestReplayInstall(t *testing.T) {
+	covered := map[config.StorageMode]bool{}
+	for _, tt := range replayInstalls {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReplayTest(t, tt.fixture, tt.change)
+			covered[rt.c.StorageMode] = true
+			var steps []string
+			rt.in.Progress = func(ev Event) {
+				if ev.Type == EventStepFinished {
+					steps = append(steps, ev.Name)
+				}
+			}
+			if err := rt.in.Run(context.Background(), rt.c); err != nil {
+				t.Fatal(err)
+			}
+			rt.checkReplayed(t)
+
+			if want := StepNames(rt.c); strings.Join(steps, "\n") != strings.Join(want, "\n") {
+				t.Errorf("finished steps %q, want %q", steps, want)
+			}
+			for file, wants := range map[string][]string{"disks.nix": tt.disks, "hardware.nix": tt.hardware} {
+				content := rt.readHostFile(t, file)
+				for _, want := range wants {
+					if !strings.Contains(content, want) {
+						t.Errorf("%s lacks %s:\n%s", file, want, content)
+					}
+				}
+			}
+			rt.readHostFile(t, "default.nix")
+		})
+	}
+	for _, mode := range config.StorageModes {
+		if !covered[mode] {
+			t.Errorf("no replayed install for %s", mode)
+		}
+	}
+}
+
+func TestReplayRollback(t *testing.T) {
+	// nixos-install fails on a ZFS install: the pool is exported and
+	// unmounted, the live system gets its own hostid back and the work
+	// directory goes
+	rt := newReplayTest(t, "rollback.jsonl", nil)
+	var cleanup []string
+	rt.in.Progress = func(ev Event) {
+		if ev.Type == EventCleanup {
+			cleanup = append(cleanup, ev.Name)
+		}
+	}
+	err := rt.in.Run(context.Background(), rt.c)
+	rt.checkReplayed(t)
+
+	var ierr *InstallError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("Run = %v, want an InstallError", err)
+	}
+	if ierr.Step != "install-nixos" {
+		t.Errorf("failed step %q, want install-nixos", ierr.Step)
+	}
+	for _, res := range ierr.Cleanup {
+		if res.Err != nil {
+			t.Errorf("cleanup %s: %v", res.Action, res.Err)
+		}
+	}
+	want := []string{
+		"Remove /tmp/nixos-config",
+		"Unmount /mnt",
+		"Export pool NIXROOT",
+		"Restore the live system's hostid",
+		"Remove " + rt.c.WorkDir,
+	}
+	if strings.Join(cleanup, "\n") != strings.Join(want, "\n") {
+		t.Errorf("cleanup actions %q, want %q", cleanup, want)
+	}
+	if _, err := os.Stat(rt.c.WorkDir); !os.IsNotExist(err) {
+		t.Errorf("work directory left behind: %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/nixgen"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+func (in *Installer) generateHostConfig(ctx context.Context, c config.Config) error {
+	system.LogInfo("generateHostConfig: starting")
+	workDir := c.WorkDir
+	system.LogInfo("generateHostConfig: removing old workDir %s", workDir)
+	os.RemoveAll(workDir)
+
+	// Create work directory
+	system.LogInfo("generateHostConfig: creating workDir %s", workDir)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return fmt.Errorf("create work dir: %w", err)
+	}
+
+	// Check if project root exists
+	system.LogInfo("generateHostConfig: checking ProjectRoot %s", c.ProjectRoot)
+	if _, err := os.Stat(c.ProjectRoot); os.IsNotExist(err) {
+		return fmt.Errorf("project root does not exist: %s", c.ProjectRoot)
+	}
+
+	// List contents of project root
+	entries, _ := os.ReadDir(c.ProjectRoot)
+	system.LogInfo("generateHostConfig: ProjectRoot contents: %d entries", len(entries))
+	for _, e := range entries {
+		system.LogInfo("  - %s", e.Name())
+	}
+
+	// Copy project files, dereferencing symlinks with -L
+	system.LogInfo("generateHostConfig: copying project files...")
+	if _, err := in.sh.Local(ctx, "cp", "-rL", c.ProjectRoot+"/.", workDir+"/"); err != nil {
+		return fmt.Errorf("copy project from %s to %s: %w", c.ProjectRoot, workDir, err)
+	}
+	system.LogInfo("generateHostConfig: copy complete")
+
+	// Hash the user password, unless the answer file supplied a hash
+	hashedPassword := c.PasswordHash
+	if hashedPassword == "" {
+		system.LogInfo("generateHostConfig: hashing user password")
+		var err error
+		hashedPassword, err = HashPassword(ctx, in.sh, c.Password)
+		if err != nil {
+			return fmt.Errorf("hash password: %w", err)
+		}
+		system.LogInfo("generateHostConfig: password hashed successfully")
+	} else {
+		system.LogInfo("generateHostConfig: using pre-hashed password from answer file")
+	}
+
+	system.LogInfo("generateHostConfig: writing %s", nixgen.HostDir(c))
+	files, err := nixgen.WriteHost(c, hashedPassword)
+	if err != nil {
+		return err
+	}
+	return nixgen.CheckSyntax(ctx, in.sh, files...)
+}
+
+// HashPassword generates a SHA-512 crypt hash using mkpasswd
+func HashPassword(ctx context.Context, sh system.Shell, password string) (string, error) {
+	res, err := sh.Run(ctx, system.Command{Name: "mkpasswd", Args: []string{"-m", "sha-512", "--stdin"}, Stdin: password, Local: true})
+	if err != nil {
+		system.LogError("mkpasswd failed: %v, stderr: %s", err, res.Stderr)
+		return "", fmt.Errorf("mkpasswd failed: %w", err)
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+func (in *Installer) formatDisk(ctx context.Context, c config.Config) error {
+	system.LogInfo("formatDisk: starting")
+	diskoConfig := filepath.Join(nixgen.HostDir(c), "disks.nix")
+	system.LogInfo("formatDisk: diskoConfig = %s", diskoConfig)
+
+	// Check if disko config exists
+	if _, err := os.Stat(diskoConfig); os.IsNotExist(err) {
+		return fmt.Errorf("disko config does not exist: %s", diskoConfig)
+	}
+
+	// Log disko config contents
+	diskoContent, _ := os.ReadFile(diskoConfig)
+	system.LogInfo("formatDisk: disks.nix contents:\n%s", string(diskoContent))
+
+	if c.StorageMode.IsZFS() {
+		system.LogInfo("formatDisk: setting hostid %s", c.HostID)
+		if err := in.setHostID(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	// Unmount partitions on all target disks
+	for _, disk := range c.Disks {
+		system.LogInfo("formatDisk: unmounting partitions on %s", disk)
+		lsblkOutput, _ := in.sh.Exec(ctx, "lsblk", "-nr", "-o", "NAME", disk)
+		partitions := strings.Split(lsblkOutput, "\n")
+		for i, part := range partitions {
+			if i == 0 || part == "" {
+				continue
+			}
+			partPath := "/dev/" + strings.TrimSpace(part)
+			system.LogInfo("formatDisk: unmounting %s", partPath)
+			in.sh.Exec(ctx, "umount", partPath)
+		}
+	}
+
+	if c.StorageMode.IsZFS() {
+		system.LogInfo("formatDisk: exporting all zpools")
+		in.sh.Exec(ctx, "zpool", "export", "-a")
+	}
+	if c.StorageMode.IsLUKS() {
+		// A container left open by an earlier attempt would stop disko
+		// opening the new one
+		system.LogInfo("formatDisk: closing any open %s container", nixgen.LUKSName)
+		in.sh.Exec(ctx, "cryptsetup", "close", nixgen.LUKSName)
+	}
+
+	system.LogInfo("formatDisk: running disko --mode disko %s", diskoConfig)
+	var passInput string
+	if c.StorageMode.IsEncrypted() {
+		// Pipe the passphrase to disko's stdin for ZFS or LUKS encryption
+		// Both prompt for the passphrase twice (enter + confirm), so we send it twice
+		system.LogInfo("formatDisk: piping passphrase for encryption")
+		passInput = c.Passphrase + "\n" + c.Passphrase + "\n"
+	}
+
+	if c.Alongside != nil {
+		if err := in.formatAlongside(ctx, c, passInput, diskoConfig); err != nil {
+			return err
+		}
+	} else if _, err := in.sh.ExecInput(ctx, passInput, "disko", "--mode", "disko", diskoConfig); err != nil {
+		system.LogError("formatDisk: disko failed: %v", err)
+		return fmt.Errorf("disko failed: %w", err)
+	}
+	system.LogInfo("formatDisk: disko completed successfully")
+	in.progress.Mounted = true
+	if c.StorageMode.IsZFS() {
+		in.progress.Pool = c.ZFSPoolName
+	}
+
+	return nil
+}
+
+// formatAlongside creates the partition for an install into free space and
+// formats only that. disko --mode disko would wipe whole disks first, so
+// the partition is formatted and mounted in two separate passes instead.
+func (in *Installer) formatAlongside(ctx context.Context, c config.Config, passInput, diskoConfig string) error {
+	a := c.Alongside
+	if _, err := in.host().Stat(a.Device()); err == nil {
+		// Made by an earlier attempt at this install
+		system.LogInfo("formatAlongside: partition %s already exists", a.Device())
+	} else {
+		end := a.Start + a.Sectors - 1
+		system.LogInfo("formatAlongside: creating partition at sectors %d-%d of %s", a.Start, end, c.Disk)
+		if _, err := in.sh.Exec(ctx, "sgdisk",
+			fmt.Sprintf("--new=0:%d:%d", a.Start, end),
+			"--typecode=0:8300",
+			"--partition-guid=0:"+a.PartUUID,
+			"--change-name=0:tuinix",
+			c.Disk); err != nil {
+			return fmt.Errorf("create partition on %s: %w", c.Disk, err)
+		}
+		in.sh.Exec(ctx, "partprobe", c.Disk)
+		in.sh.Exec(ctx, "udevadm", "settle")
+	}
+
+	// mkfs refuses to overwrite the signatures of an earlier attempt
+	if _, err := in.sh.Exec(ctx, "wipefs", "-a", a.Device()); err != nil {
+		return fmt.Errorf("wipe %s: %w", a.Device(), err)
+	}
+	if _, err := in.sh.ExecInput(ctx, passInput, "disko", "--mode", "format", diskoConfig); err != nil {
+		system.LogError("formatAlongside: disko failed: %v", err)
+		return fmt.Errorf("disko failed: %w", err)
+	}
+	if _, err := in.sh.Exec(ctx, "disko", "--mode", "mount", diskoConfig); err != nil {
+		return fmt.Errorf("mount target: %w", err)
+	}
+	return in.mountESP(ctx, c)
+}
+
+// mountESP mounts the existing ESP an install into free space shares with
+// the other OS at /mnt/boot
+func (in *Installer) mountESP(ctx context.Context, c config.Config) error {
+	if _, err := in.sh.Query(ctx, "findmnt", "-n", "/mnt/boot"); err == nil {
+		return nil
+	}
+	in.sh.Exec(ctx, "mkdir", "-p", "/mnt/boot")
+	if _, err := in.sh.Exec(ctx, "mount", "-o", "umask=0077", c.Alongside.ESP, "/mnt/boot"); err != nil {
+		return fmt.Errorf("mount ESP %s: %w", c.Alongside.ESP, err)
+	}
+	return nil
+}
+
+func (in *Installer) generateHardwareConfig(ctx context.Context, c config.Config) error {
+	in.sh.Exec(ctx, "mkdir", "-p", "/tmp/nixos-config")
+	if _, err := in.sh.Exec(ctx, "nixos-generate-config", "--root", "/mnt", "--dir", "/tmp/nixos-config"); err != nil {
+		return fmt.Errorf("nixos-generate-config: %w", err)
+	}
+
+	path, err := nixgen.WriteHardware(c)
+	if err != nil {
+		return err
+	}
+	return nixgen.CheckSyntax(ctx, in.sh, path)
+}
+
+func (in *Installer) installNixOS(ctx context.Context, c config.Config) error {
+	nixConfig := `
+extra-substituters = https://cache.nixos.org/
+extra-trusted-public-keys = cache.nixos.org-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY=
+max-jobs = auto
+cores = 0
+keep-outputs = true
+keep-derivations = true
+`
+
+	flakeRef := fmt.Sprintf("%s#%s", c.WorkDir, c.Hostname)
+	if _, err := in.sh.Run(ctx, system.Command{
+		Name: "nixos-install",
+		Args: []string{"--flake", flakeRef, "--no-root-passwd"},
+		Env:  []string{"NIX_CONFIG=" + nixConfig},
+	}); err != nil {
+		return fmt.Errorf("nixos-install: %w", err)
+	}
+
+	return nil
+}
+
+func (in *Installer) configureZFSBoot(ctx context.Context, c config.Config) error {
+	bootfsPath := fmt.Sprintf("%s/root", c.ZFSPoolName)
+	if _, err := in.sh.Exec(ctx, "zpool", "set", "bootfs="+bootfsPath, c.ZFSPoolName); err != nil {
+		return fmt.Errorf("set bootfs: %w", err)
+	}
+
+	output, err := in.sh.Exec(ctx, "zpool", "get", "-H", "-o", "value", "bootfs", c.ZFSPoolName)
+	if err != nil {
+		return fmt.Errorf("get bootfs: %w", err)
+	}
+	if in.DryRun {
+		// Nothing was set, so there is nothing to verify
+		return nil
+	}
+
+	if strings.TrimSpace(output) != bootfsPath {
+		return fmt.Errorf("bootfs not set correctly, got: %s", output)
+	}
+
+	return nil
+}
+
+func (in *Installer) copyFlake(ctx context.Context, c config.Config) error {
+	targetDir := "/mnt/etc/tuinix"
+	if _, err := in.sh.Exec(ctx, "mkdir", "-p", targetDir); err != nil {
+		return fmt.Errorf("create target dir: %w", err)
+	}
+
+	if _, err := in.sh.Exec(ctx, "cp", "-r", c.WorkDir+"/.", targetDir+"/"); err != nil {
+		return fmt.Errorf("copy flake: %w", err)
+	}
+
+	if _, err := in.sh.Exec(ctx, "chown", "-R", "root:root", targetDir); err != nil {
+		return fmt.Errorf("chown: %w", err)
+	}
+
+	return nil
+}
+
+func (in *Installer) setupUserFlake(ctx context.Context, c config.Config) error {
+	userDir := fmt.Sprintf("/mnt/home/%s/tuinix", c.Username)
+	hostDir := nixgen.HostDir(c)
+	usersDir := filepath.Join(c.WorkDir, "users")
+	repoURL := "https://github.com/timlinux/tuinix.git"
+
+	userHome := fmt.Sprintf("/mnt/home/%s", c.Username)
+	in.sh.Exec(ctx, "mkdir", "-p", userHome)
+
+	in.sh.Exec(ctx, "rm", "-rf", userDir)
+
+	if _, err := in.sh.Exec(ctx, "git", "clone", "--depth", "1", repoURL, userDir); err != nil {
+		return fmt.Errorf("git clone: %w", err)
+	}
+
+	destHostDir := filepath.Join(userDir, "hosts", c.Hostname)
+	in.sh.Exec(ctx, "mkdir", "-p", filepath.Dir(destHostDir))
+	if _, err := in.sh.Exec(ctx, "cp", "-r", hostDir, destHostDir); err != nil {
+		return fmt.Errorf("copy host config: %w", err)
+	}
+
+	userNixSrc := filepath.Join(usersDir, c.Username+".nix")
+	userNixDst := filepath.Join(userDir, "users", c.Username+".nix")
+	if _, err := in.sh.Exec(ctx, "cp", userNixSrc, userNixDst); err != nil {
+		return fmt.Errorf("copy user config: %w", err)
+	}
+
+	in.sh.Exec(ctx, "git", "-C", userDir, "config", "user.name", c.Fullname)
+	in.sh.Exec(ctx, "git", "-C", userDir, "config", "user.email", c.Email)
+
+	in.sh.Exec(ctx, "git", "-C", userDir, "add", "hosts/"+c.Hostname, "users/"+c.Username+".nix")
+
+	commitMsg := fmt.Sprintf(`Add host and user configuration for %s
+
+Generated by tuinix installer on %s
+Host: %s
+User: %s (%s <%s>)
+Host ID: %s
+Disk: %s
+Locale: %s
+Keymap: %s`,
+		c.Hostname,
+		time.Now().UTC().Format("2006-01-02 15:04:05 UTC"),
+		c.Hostname,
+		c.Username, c.Fullname, c.Email,
+		c.HostID,
+		c.Disk,
+		c.Locale,
+		c.Keymap)
+
+	in.sh.Exec(ctx, "git", "-C", userDir, "commit", "-m", commitMsg)
+
+	in.sh.Exec(ctx, "chown", "-R", "1000:100", userHome)
+
+	in.sh.Exec(ctx, "nixos-enter", "--root", "/mnt", "--command",
+		fmt.Sprintf("ln -sf /home/%s/tuinix /etc/tuinix-user", c.Username))
+
+	return nil
+}
+
+func (in *Installer) finalizeZFSPool(ctx context.Context, c config.Config) error {
+	in.sh.Exec(ctx, "umount", "-R", "/mnt")
+
+	if _, err := in.sh.Exec(ctx, "zpool", "export", c.ZFSPoolName); err != nil {
+		return fmt.Errorf("export pool: %w", err)
+	}
+
+	if _, err := in.sh.Exec(ctx, "zpool", "import", "-f", c.ZFSPoolName); err != nil {
+		return fmt.Errorf("import pool: %w", err)
+	}
+
+	if _, err := in.sh.Exec(ctx, "zpool", "export", c.ZFSPoolName); err != nil {
+		return fmt.Errorf("final export: %w", err)
+	}
+	in.progress.Pool = ""
+	in.progress.Mounted = false
+
+	return nil
+}
+
+// copyInstallLog copies the install log to the user's home directory on the
+// new system. A missing log copy never fails the install, so it only logs
+// errors.
+func (in *Installer) copyInstallLog(ctx context.Context, c config.Config) error {
+	if in.LogFile == "" {
+		return nil
+	}
+	targetDir := fmt.Sprintf("/mnt/home/%s", c.Username)
+	targetFile := filepath.Join(targetDir, "tuinix-install.log")
+	system.LogInfo("Copying install log from %s to %s", in.LogFile, targetFile)
+
+	if _, err := in.sh.Exec(ctx, "cp", in.LogFile, targetFile); err != nil {
+		system.LogError("Failed to copy install log: %v", err)
+		return nil
+	}
+	// Set ownership to the user
+	in.sh.Exec(ctx, "chown", "1000:100", targetFile)
+	system.LogInfo("Install log copied successfully")
+	return nil
+}
@@ -0,0 +1,261 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// sysClassBlock is where the kernel lists every block device, with the
+// devices built on top of each one in its holders directory
+const sysClassBlock = "/sys/class/block"
+
+// releaseDisks frees the target disks of everything an earlier system
+// left running on them, then clears its metadata, so disko finds idle,
+// blank disks: it unmounts and stops the LVM volume groups, md arrays and
+// dm-crypt mappings that hold the disks and their partitions, exports
+// ZFS pools on them, and wipes old ZFS labels and other signatures.
+func (in *Installer) releaseDisks(ctx context.Context, c config.Config) error {
+	done := &released{holders: map[string]bool{}, vgs: map[string]bool{}}
+	for _, d := range c.Disks {
+		tree, err := disk.Tree(ctx, in.sh, d)
+		if err != nil {
+			if in.DryRun {
+				// The disks of a dry run may belong to another machine
+				system.LogInfo("releaseDisks: cannot read %s (%v); planning a plain wipe", d, err)
+				in.sh.Exec(ctx, "wipefs", "-a", d)
+				continue
+			}
+			return fmt.Errorf("read %s: %w", d, err)
+		}
+
+		// Everything built on the disk goes first, deepest first
+		for _, dev := range devicesOf(*tree) {
+			if err := in.releaseHolders(ctx, filepath.Base(dev.Path), done); err != nil {
+				return err
+			}
+		}
+		if err := in.exportPools(ctx, *tree); err != nil {
+			return err
+		}
+
+		// Then the old metadata, partitions before the partition table
+		devs := devicesOf(*tree)
+		for i := len(devs) - 1; i >= 0; i-- {
+			dev := devs[i]
+			if err := in.unmountAll(ctx, dev.Path); err != nil {
+				return err
+			}
+			if dev.FSType == "zfs_member" {
+				system.LogInfo("releaseDisks: clearing ZFS label of pool %s on %s", dev.Label, dev.Path)
+				if _, err := in.sh.Exec(ctx, "zpool", "labelclear", "-f", dev.Path); err != nil {
+					return fmt.Errorf("clear ZFS label on %s: %w", dev.Path, err)
+				}
+			}
+			system.LogInfo("releaseDisks: wiping signatures on %s", dev.Path)
+			if _, err := in.sh.Exec(ctx, "wipefs", "-a", dev.Path); err != nil {
+				return fmt.Errorf("wipe %s: %w", dev.Path, err)
+			}
+		}
+	}
+	in.sh.Exec(ctx, "udevadm", "settle")
+	return nil
+}
+
+// devicesOf lists the disk and its partitions, leaving out the mappings
+// and arrays that lsblk shows beneath them; releaseHolders finds those
+// through sysfs
+func devicesOf(tree disk.BlockDevice) []disk.BlockDevice {
+	devs := []disk.BlockDevice{tree}
+	for _, child := range tree.Children {
+		if child.Type == "part" {
+			devs = append(devs, child)
+		}
+	}
+	return devs
+}
+
+// released records what releaseHolders has stopped so far: a device can
+// hold several partitions, and deactivating a volume group takes all of
+// its logical volumes with it
+type released struct {
+	holders map[string]bool
+	vgs     map[string]bool
+}
+
+// releaseHolders stops every device built on the block device called
+// name, deepest first: LVM volume groups are deactivated, md arrays
+// stopped and dm-crypt mappings closed, after unmounting them. Holders
+// are all unmounted before any is stopped, as a volume group can only be
+// deactivated once none of its logical volumes is in use.
+func (in *Installer) releaseHolders(ctx context.Context, name string, done *released) error {
+	var stop []string
+	for _, holder := range in.holders(name) {
+		if done.holders[holder] {
+			continue
+		}
+		if err := in.releaseHolders(ctx, holder, done); err != nil {
+			return err
+		}
+		if err := in.unmountAll(ctx, in.holderDevice(holder)); err != nil {
+			return err
+		}
+		stop = append(stop, holder)
+	}
+
+	for _, holder := range stop {
+		if _, err := in.host().Stat(filepath.Join(sysClassBlock, holder)); err != nil {
+			continue // Gone with a volume group deactivated for another holder
+		}
+		done.holders[holder] = true
+		dev := in.holderDevice(holder)
+		switch uuid := in.sysfsValue(holder, "dm/uuid"); {
+		case strings.HasPrefix(holder, "md"):
+			system.LogInfo("releaseDisks: stopping md array %s on %s", dev, name)
+			if _, err := in.sh.Exec(ctx, "mdadm", "--stop", dev); err != nil {
+				return fmt.Errorf("stop md array %s: %w", dev, err)
+			}
+		case strings.HasPrefix(uuid, "CRYPT-"):
+			system.LogInfo("releaseDisks: closing dm-crypt mapping %s on %s", dev, name)
+			if _, err := in.sh.Exec(ctx, "cryptsetup", "close", filepath.Base(dev)); err != nil {
+				return fmt.Errorf("close dm-crypt mapping %s: %w", dev, err)
+			}
+		case strings.HasPrefix(uuid, "LVM-"):
+			vg, err := in.sh.Query(ctx, "lvs", "--noheadings", "-o", "vg_name", dev)
+			vg = strings.TrimSpace(vg)
+			if err != nil || vg == "" {
+				return fmt.Errorf("find the volume group of %s: %w", dev, err)
+			}
+			if done.vgs[vg] {
+				continue
+			}
+			done.vgs[vg] = true
+			system.LogInfo("releaseDisks: deactivating LVM volume group %s on %s", vg, name)
+			if _, err := in.sh.Exec(ctx, "vgchange", "-an", vg); err != nil {
+				return fmt.Errorf("deactivate volume group %s: %w", vg, err)
+			}
+		case strings.HasPrefix(holder, "dm-"):
+			system.LogInfo("releaseDisks: removing device-mapper device %s on %s", dev, name)
+			if _, err := in.sh.Exec(ctx, "dmsetup", "remove", filepath.Base(dev)); err != nil {
+				return fmt.Errorf("remove %s: %w", dev, err)
+			}
+		default:
+			return fmt.Errorf("%s holds %s and cannot be released automatically", holder, name)
+		}
+	}
+	return nil
+}
+
+// holderDevice is the device node of a holder: /dev/mapper/NAME for
+// device-mapper devices, /dev/md127 and the like for the rest
+func (in *Installer) holderDevice(holder string) string {
+	if strings.HasPrefix(holder, "dm-") {
+		return "/dev/mapper/" + in.sysfsValue(holder, "dm/name")
+	}
+	return "/dev/" + holder
+}
+
+// exportPools exports the imported ZFS pools that have a member on tree
+func (in *Installer) exportPools(ctx context.Context, tree disk.BlockDevice) error {
+	for _, dev := range devicesOf(tree) {
+		if dev.FSType != "zfs_member" || dev.Label == "" {
+			continue
+		}
+		if _, err := in.sh.Query(ctx, "zpool", "list", "-H", "-o", "name", dev.Label); err != nil {
+			continue // Not imported
+		}
+		system.LogInfo("releaseDisks: exporting ZFS pool %s on %s", dev.Label, dev.Path)
+		if _, err := in.sh.Exec(ctx, "zpool", "export", "-f", dev.Label); err != nil {
+			return fmt.Errorf("export ZFS pool %s: %w", dev.Label, err)
+		}
+	}
+	return nil
+}
+
+// unmountAll unmounts every mount of dev and turns off swap on it
+func (in *Installer) unmountAll(ctx context.Context, dev string) error {
+	out, _ := in.sh.Query(ctx, "findmnt", "-rn", "-o", "TARGET", "-S", dev)
+	targets := strings.Split(strings.TrimSpace(out), "\n")
+	// Mounts nested under another one come later, and have to go first
+	for i := len(targets) - 1; i >= 0; i-- {
+		if targets[i] == "" {
+			continue
+		}
+		target := unescapeMountPoint(targets[i])
+		system.LogInfo("releaseDisks: unmounting %s from %s", dev, target)
+		if _, err := in.sh.Exec(ctx, "umount", target); err != nil {
+			return fmt.Errorf("unmount %s from %s: %w", dev, target, err)
+		}
+	}
+	// The kernel lists swap on a device-mapper device as /dev/dm-N, not
+	// under the /dev/mapper name
+	swaps, _ := in.host().ReadFile("/proc/swaps")
+	for _, line := range strings.Split(string(swaps), "\n") {
+		if f := strings.Fields(line); len(f) > 0 && in.sameFile(f[0], dev) {
+			system.LogInfo("releaseDisks: turning off swap on %s", dev)
+			if _, err := in.sh.Exec(ctx, "swapoff", dev); err != nil {
+				return fmt.Errorf("turn off swap on %s: %w", dev, err)
+			}
+		}
+	}
+	return nil
+}
+
+// unescapeMountPoint undoes the \xNN escapes findmnt -r writes for spaces
+// and other awkward characters in a mount point
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// sameFile reports whether the paths a and b lead to the same file
+func (in *Installer) sameFile(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ra, err := in.host().EvalSymlinks(a)
+	if err != nil {
+		return false
+	}
+	rb, err := in.host().EvalSymlinks(b)
+	return err == nil && ra == rb
+}
+
+// holders lists the devices the kernel reports as built on the block
+// device called name, such as dm-0 or md127
+func (in *Installer) holders(name string) []string {
+	entries, err := in.host().ReadDir(filepath.Join(sysClassBlock, name, "holders"))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// sysfsValue reads one attribute of a block device from sysfs
+func (in *Installer) sysfsValue(name, attr string) string {
+	data, err := in.host().ReadFile(filepath.Join(sysClassBlock, name, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/system"
+)
+
+// An old install on /dev/vda: volume group vg0 on vda2 and vda3, holding
+// the logical volumes root (dm-0) and swap (dm-1, in use as swap), and a
+// dm-crypt mapping cryptdata (dm-2) on vda4
+const oldInstallTree = `{"blockdevices":[{"name":"vda","path":"/dev/vda","type":"disk","size":107374182400,"children":[
+	{"name":"vda1","path":"/dev/vda1","type":"part","size":536870912,"fstype":"vfat"},
+	{"name":"vda2","path":"/dev/vda2","type":"part","size":53687091200,"fstype":"LVM2_member"},
+	{"name":"vda3","path":"/dev/vda3","type":"part","size":26843545600,"fstype":"LVM2_member"},
+	{"name":"vda4","path":"/dev/vda4","type":"part","size":26306674688,"fstype":"crypto_LUKS"}]}]}`
+
+// fakeOldInstall writes the sysfs, /dev and /proc/swaps of the old
+// install under a new directory and returns it
+func fakeOldInstall(t *testing.T) string {
+	t.Helper()
+	host := t.TempDir()
+	block := filepath.Join(host, sysClassBlock)
+	for _, dev := range []string{"vda", "vda1", "vda2", "vda3", "vda4"} {
+		if err := os.MkdirAll(filepath.Join(block, dev, "holders"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dm := range []struct{ dev, name, uuid string }{
+		{"dm-0", "vg0-root", "LVM-Kx3v0root"},
+		{"dm-1", "vg0-swap", "LVM-Kx3v0swap"},
+		{"dm-2", "cryptdata", "CRYPT-LUKS2-5b1e0c2f-cryptdata"},
+	} {
+		writeFile(t, filepath.Join(block, dm.dev, "dm/name"), dm.name+"\n")
+		writeFile(t, filepath.Join(block, dm.dev, "dm/uuid"), dm.uuid+"\n")
+		if err := os.MkdirAll(filepath.Join(block, dm.dev, "holders"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(host, "dev", dm.dev), "")
+		symlink(t, "../"+dm.dev, filepath.Join(host, "dev/mapper", dm.name))
+	}
+	for _, h := range []struct{ holder, dev string }{
+		{"dm-0", "vda2"}, {"dm-1", "vda2"}, {"dm-1", "vda3"}, {"dm-2", "vda4"},
+	} {
+		symlink(t, "../../"+h.holder, filepath.Join(block, h.dev, "holders", h.holder))
+	}
+	writeFile(t, filepath.Join(host, "proc/swaps"),
+		"Filename\tType\tSize\tUsed\tPriority\n/dev/dm-1                               partition\t4194300\t0\t-2\n")
+	return host
+}
+
+func symlink(t *testing.T, target, name string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hookRunner calls hook after each command it passes on
+type hookRunner struct {
+	next system.Runner
+	hook func(c system.Command)
+}
+
+func (r hookRunner) Run(ctx context.Context, c system.Command) (system.Result, error) {
+	res, err := r.next.Run(ctx, c)
+	r.hook(c)
+	return res, err
+}
+
+// query scripts a read-only command and what it printed
+func query(stdout string, name string, args ...string) system.RecordedCommand {
+	return system.RecordedCommand{
+		Command: system.Command{Name: name, Args: args, Local: true},
+		Result:  system.Result{Stdout: stdout},
+	}
+}
+
+// failing scripts a read-only command that exits with an error
+func failing(name string, args ...string) system.RecordedCommand {
+	return system.RecordedCommand{
+		Command: system.Command{Name: name, Args: args, Local: true},
+		Error:   "exit status 1",
+	}
+}
+
+// run scripts a command that changes the system
+func run(name string, args ...string) system.RecordedCommand {
+	return system.RecordedCommand{Command: system.Command{Name: name, Args: args}}
+}
+
+func TestReleaseDisks(t *testing.T) {
+	lsblk := query(oldInstallTree, "lsblk", "--json", "-o", "NAME,PATH,TYPE,SIZE,MODEL,SERIAL,FSTYPE,LABEL,PARTTYPE,PARTLABEL,MOUNTPOINT", "--bytes", "/dev/vda")
+	findmnt := func(dev string) system.RecordedCommand {
+		return failing("findmnt", "-rn", "-o", "TARGET", "-S", dev)
+	}
+	// Every logical volume is unmounted, nested mounts first, or swapped off before the volume
+	// group goes, which it does once; then the disk is wiped
+	unmountVG := []system.RecordedCommand{
+		lsblk,
+		query(`/media/old\x20root
+/media/old\x20root/var\x5ctmp
+`, "findmnt", "-rn", "-o", "TARGET", "-S", "/dev/mapper/vg0-root"),
+		run("umount", `/media/old root/var\tmp`),
+		run("umount", "/media/old root"),
+		findmnt("/dev/mapper/vg0-swap"),
+		run("swapoff", "/dev/mapper/vg0-swap"),
+		query("  vg0\n", "lvs", "--noheadings", "-o", "vg_name", "/dev/mapper/vg0-root"),
+		run("vgchange", "-an", "vg0"),
+	}
+	closeAndWipe := []system.RecordedCommand{
+		findmnt("/dev/mapper/cryptdata"),
+		run("cryptsetup", "close", "cryptdata"),
+		findmnt("/dev/vda4"), run("wipefs", "-a", "/dev/vda4"),
+		findmnt("/dev/vda3"), run("wipefs", "-a", "/dev/vda3"),
+		findmnt("/dev/vda2"), run("wipefs", "-a", "/dev/vda2"),
+		findmnt("/dev/vda1"), run("wipefs", "-a", "/dev/vda1"),
+		findmnt("/dev/vda"), run("wipefs", "-a", "/dev/vda"),
+		run("udevadm", "settle"),
+	}
+
+	tests := []struct {
+		name   string
+		vanish bool // Whether the logical volumes leave sysfs with vg0
+		script []system.RecordedCommand
+	}{
+		{
+			name:   "logical volumes gone with their volume group",
+			vanish: true,
+			script: append(append([]system.RecordedCommand{}, unmountVG...), closeAndWipe...),
+		},
+		{
+			// As when the commands are only planned: swap is still
+			// found in vg0, which is not deactivated a second time
+			name: "logical volumes still listed",
+			script: append(append(append([]system.RecordedCommand{}, unmountVG...),
+				query("  vg0\n", "lvs", "--noheadings", "-o", "vg_name", "/dev/mapper/vg0-swap")),
+				closeAndWipe...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := fakeOldInstall(t)
+			replay := system.NewReplayRunner(tt.script)
+			in := &Installer{
+				Runner: hookRunner{next: replay, hook: func(c system.Command) {
+					if !tt.vanish || c.Name != "vgchange" {
+						return
+					}
+					block := filepath.Join(host, sysClassBlock)
+					for _, name := range []string{"dm-0", "dm-1", "vda2/holders/dm-0", "vda2/holders/dm-1", "vda3/holders/dm-1"} {
+						os.RemoveAll(filepath.Join(block, name))
+					}
+				}},
+				Host: system.RootHost(host),
+			}
+			c := config.Config{Disk: "/dev/vda", Disks: []string{"/dev/vda"}}
+			in.start(c)
+			if err := in.releaseDisks(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range replay.Remaining() {
+				t.Errorf("scripted command never run: %s", c)
+			}
+		})
+	}
+}
+
+func TestReleaseDisksUnmountFails(t *testing.T) {
+	umount := run("umount", "/media/old root")
+	umount.Error = "exit status 32"
+	replay := system.NewReplayRunner([]system.RecordedCommand{
+		query(oldInstallTree, "lsblk", "--json", "-o", "NAME,PATH,TYPE,SIZE,MODEL,SERIAL,FSTYPE,LABEL,PARTTYPE,PARTLABEL,MOUNTPOINT", "--bytes", "/dev/vda"),
+		query(`/media/old\x20root
+`, "findmnt", "-rn", "-o", "TARGET", "-S", "/dev/mapper/vg0-root"),
+		umount,
+	})
+	in := &Installer{Runner: replay, Host: system.RootHost(fakeOldInstall(t))}
+	c := config.Config{Disk: "/dev/vda", Disks: []string{"/dev/vda"}}
+	in.start(c)
+	err := in.releaseDisks(context.Background(), c)
+	if err == nil || !strings.Contains(err.Error(), "unmount /dev/mapper/vg0-root from /media/old root") {
+		t.Errorf("releaseDisks = %v, want the failed unmount", err)
+	}
+	for _, c := range replay.Remaining() {
+		t.Errorf("scripted command never run: %s", c)
+	}
+}
+
+func TestUnescapeMountPoint(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/mnt", "/mnt"},
+		{`/media/USB\x20DISK`, "/media/USB DISK"},
+		{`/media/a\x5cb\x09c`, "/media/a\\b\tc"},
+		{`/media/caf\xc3\xa9`, "/media/café"},
+		{`/media/bad\xzz`, `/media/bad\xzz`},
+		{`/media/short\x2`, `/media/short\x2`},
+	}
+	for _, tt := range tests {
+		if got := unescapeMountPoint(tt.in); got != tt.want {
+			t.Errorf("unescapeMountPoint(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
+)
+
+// plainSession asks the wizard's questions as numbered line prompts on
+// stdin/stdout, for serial consoles and logged sessions where the
+// alt-screen TUI is unusable
+type plainSession struct {
+	in      *bufio.Reader
+	out     io.Writer
+	answers *config.Answers
+
+	mu          sync.Mutex
+	step        string // Install step now running
+	destructive bool   // Whether that step is writing the target disks
+}
+
+// How long after a first stop signal a second one stops the installation
+const stopConfirmWindow = 10 * time.Second
+
+func runPlain(answers *config.Answers) error {
+	if answers == nil {
+		answers = &config.Answers{}
+	}
+	p := &plainSession{
+		in:      bufio.NewReader(os.Stdin),
+		out:     os.Stdout,
+		answers: answers,
+	}
+
+	if prev := resumableInstall(); prev != nil {
+		resume, err := p.askResume(prev)
+		if err != nil {
+			return err
+		}
+		if resume {
+			passphrase, err := p.askResumePassphrase(prev)
+			if err != nil {
+				return err
+			}
+			p.logf("Resuming installation (%d of %d steps already done)", len(prev.Completed), len(pipeline.Steps(prev.Config)))
+			p.followProgress()
+			ctx, release := p.watchSignals()
+			defer release()
+			return p.finish(prev.Config, newInstaller().Resume(ctx, prev, passphrase))
+		}
+		logInfo("Not resuming the previous installation")
+	}
+
+	c := defaultConfig()
+	answers.ApplyDefaults(&c)
+	if err := p.ask(&c); err != nil {
+		return err
+	}
+
+	p.printSummary(c)
+	previews := previewDestruction(c)
+	for _, pr := range previews {
+		p.printf("\n%s\n", previewHeader(pr))
+		for _, line := range previewItems(pr) {
+			p.printf("    %s\n", line)
+		}
+	}
+	if answers.ConfirmDestroy {
+		p.logf("Destruction confirmed by answer file")
+	} else {
+		steps := confirmSteps(c, previews)
+		if _, err := p.askText(stateConfirm, steps[0].Prompt, "", false, func(s string) error {
+			if s != steps[0].Word {
+				return fmt.Errorf("type %s to confirm, or press Ctrl+C to cancel", steps[0].Word)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, step := range steps[1:] {
+			for {
+				line, err := p.readLine(step.Prompt+": ", false)
+				if err != nil {
+					return err
+				}
+				if strings.EqualFold(strings.TrimSpace(line), step.Word) {
+					break
+				}
+				p.printf("! that does not match; check the disk's label, or press Ctrl+C to cancel\n")
+			}
+		}
+	}
+
+	names := pipeline.StepNames(c)
+	p.logf("Starting installation (%d steps)", len(names))
+	p.followProgress()
+	ctx, release := p.watchSignals()
+	defer release()
+	return p.finish(c, newInstaller().Run(ctx, c))
+}
+
+// followProgress prints a line for each step of the installation
+func (p *plainSession) followProgress() {
+	installEvents.subscribe(func(ev pipeline.Event) {
+		switch ev.Type {
+		case pipeline.EventStepStarted:
+			p.mu.Lock()
+			p.step, p.destructive = ev.Name, ev.Destructive
+			p.mu.Unlock()
+			p.logf("[%d/%d] %s: started", ev.Index, ev.Total, ev.Name)
+		case pipeline.EventStepSkipped:
+			p.logf("[%d/%d] %s: already done", ev.Index, ev.Total, ev.Name)
+		case pipeline.EventStepFinished:
+			p.logf("[%d/%d] %s: done (%.0fs)", ev.Index, ev.Total, ev.Name, ev.Duration)
+		case pipeline.EventStepFailed:
+			p.logf("[%d/%d] %s: failed after %.0fs", ev.Index, ev.Total, ev.Name, ev.Duration)
+			if ev.Output != "" {
+				p.printf("%s\n", ev.Output)
+			}
+		case pipeline.EventCleanup:
+			if ev.Error != "" {
+				p.logf("Cleanup: %s: failed: %s", ev.Name, ev.Error)
+			} else {
+				p.logf("Cleanup: %s: done", ev.Name)
+			}
+		}
+	})
+}
+
+// watchSignals returns the context for an installation. The first stop
+// signal only says whether the running step can be stopped safely; a
+// second within stopConfirmWindow stops it, and the pipeline cleans up.
+func (p *plainSession) watchSignals() (context.Context, func()) {
+	ctx, stop := newInstallContext()
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, stopSignals...)
+	done := make(chan struct{})
+
+	go func() {
+		var asked time.Time
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-ch:
+				if !asked.IsZero() && time.Since(asked) < stopConfirmWindow {
+					logInfo("User stopped the installation")
+					p.logf("Stopping the installation and cleaning up...")
+					stop(errStoppedByUser)
+					continue
+				}
+				asked = time.Now()
+				p.mu.Lock()
+				step, destructive := p.step, p.destructive
+				p.mu.Unlock()
+				logInfo("Received %s during %q", sig, step)
+				if destructive {
+					p.logf("! It is NOT safe to stop now: %q is writing the disks, which would need formatting again", step)
+				} else {
+					p.logf("It is safe to stop now: %q will be stopped and the live system cleaned up", step)
+				}
+				p.logf("Press Ctrl+C again within %.0f seconds to stop the installation", stopConfirmWindow.Seconds())
+			}
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(ch)
+		close(done)
+		stop(nil)
+	}
+}
+
+// finish reports how the installation of c ended and returns err
+func (p *plainSession) finish(c config.Config, err error) error {
+	if err != nil {
+		p.logf("Installation failed: %v", err)
+		p.logf("Full log: %s", logFile)
+		if prev := resumableInstall(); prev != nil {
+			p.logf("Progress saved: run the installer again (without rebooting) to resume from %q", prev.NextStep())
+		}
+		return err
+	}
+
+	p.logf("Installation complete")
+	p.logf("Log in as %s after reboot; your flake is at /home/%s/tuinix", c.Username, c.Username)
+	p.logf("Remove installation media and reboot")
+	return nil
+}
+
+// askResume describes an unfinished installation and asks whether to
+// continue it
+func (p *plainSession) askResume(prev *pipeline.Progress) (bool, error) {
+	p.printf("\nResume installation\n")
+	p.printf("A previous installation of %s (%s on %s) did not finish.\n",
+		prev.Config.Hostname, prev.Config.StorageMode, strings.Join(prev.Config.Disks, ", "))
+	p.printf("%d of %d steps are done; the next is %q.\n", len(prev.Completed), len(pipeline.Steps(prev.Config)), prev.NextStep())
+	for {
+		line, err := p.readLine("Resume it without formatting the disks again? [Y/n]: ", false)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "", "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		p.printf("! answer y or n\n")
+	}
+}
+
+// askResumePassphrase asks for the passphrase that unlocks an encrypted
+// pool or LUKS container, using the answer file's if it has one
+func (p *plainSession) askResumePassphrase(prev *pipeline.Progress) (string, error) {
+	if !prev.Config.StorageMode.IsEncrypted() {
+		return "", nil
+	}
+	if p.answers.Passphrase != "" {
+		p.printf("Passphrase: %s (from answer file)\n", strings.Repeat("*", len(p.answers.Passphrase)))
+		return p.answers.Passphrase, nil
+	}
+	for {
+		val, err := p.readLine(unlockPrompt(prev.Config), true)
+		if err != nil {
+			return "", err
+		}
+		if val != "" {
+			return val, nil
+		}
+	}
+}
+
+// ask fills c with one prompt per wizard step, in wizard order
+func (p *plainSession) ask(c *config.Config) error {
+	a := p.answers
+	var err error
+
+	p.logf("Checking network connectivity...")
+	if networkAvailable() {
+		p.logf("Connected to the internet")
+	} else if dryRun {
+		p.logf("No internet connection; continuing since this is a dry run")
+	} else {
+		return fmt.Errorf("no internet connection detected; configure your network and run the installer again")
+	}
+
+	if c.Username, err = p.askText(stateUsername, "Username", a.Username, false, config.ValidateUsername); err != nil {
+		return err
+	}
+	if c.Fullname, err = p.askText(stateFullname, "Full name", a.Fullname, false, config.ValidateFullname); err != nil {
+		return err
+	}
+	if c.Email, err = p.askText(stateEmail, "Email", a.Email, false, config.ValidateEmail); err != nil {
+		return err
+	}
+
+	if a.PasswordHash != "" {
+		p.header(statePassword)
+		p.printf("Using password hash from answer file\n")
+		c.PasswordHash = a.PasswordHash
+	} else {
+		if c.Password, err = p.askText(statePassword, "Password", a.Password, true, config.ValidatePassword); err != nil {
+			return err
+		}
+		if _, err = p.askText(statePasswordConfirm, "Confirm password", a.Password, true, func(s string) error {
+			if s != c.Password {
+				return fmt.Errorf("passwords do not match")
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if c.Hostname, err = p.askText(stateHostname, "Hostname", a.Hostname, false, config.ValidateHostname); err != nil {
+		return err
+	}
+
+	modeLabels := make([]string, len(config.StorageModes))
+	for i, mode := range config.StorageModes {
+		modeLabels[i] = fmt.Sprintf("%s\n       %s", mode, mode.Description())
+	}
+	modeIdx := -1
+	if a.StorageMode != "" {
+		mode, _ := config.ParseStorageMode(a.StorageMode)
+		modeIdx = storageModeIndex(mode)
+	}
+	if modeIdx, err = p.askChoice(stateStorageMode, modeLabels, modeIdx); err != nil {
+		return err
+	}
+	c.StorageMode = config.StorageModes[modeIdx]
+
+	if err := p.askDisks(c); err != nil {
+		return err
+	}
+	c.HostID = config.GenerateHostID()
+	if err := p.askSizes(c); err != nil {
+		return err
+	}
+	if err := p.askDatasets(c); err != nil {
+		return err
+	}
+
+	if c.StorageMode.IsEncrypted() {
+		if c.Passphrase, err = p.askText(statePassphrase, "Passphrase", a.Passphrase, true, config.ValidatePassphrase); err != nil {
+			return err
+		}
+		if _, err = p.askText(statePassphraseConfirm, "Confirm passphrase", a.Passphrase, true, func(s string) error {
+			if s != c.Passphrase {
+				return fmt.Errorf("passphrases do not match")
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	localeIdx, err := p.askChoice(stateLocale, config.Locales, indexOf(config.Locales, a.Locale))
+	if err != nil {
+		return err
+	}
+	c.Locale = config.Locales[localeIdx]
+
+	keymapLabels := make([]string, len(config.Keymaps))
+	for i, km := range config.Keymaps {
+		keymapLabels[i] = km.Label
+	}
+	keymapIdx, err := p.askChoice(stateKeymap, keymapLabels, keymapIndex(config.Keymaps, a.Keymap))
+	if err != nil {
+		return err
+	}
+	c.Keymap = config.Keymaps[keymapIdx].XKBLayout
+	c.ConsoleKeyMap = config.Keymaps[keymapIdx].ConsoleMap
+
+	sshIdx := -1
+	if a.EnableSSH != nil {
+		sshIdx = 1
+		if *a.EnableSSH {
+			sshIdx = 0
+		}
+	}
+	if sshIdx, err = p.askChoice(stateSSH, []string{"Yes - Enable SSH", "No - Disable SSH"}, sshIdx); err != nil {
+		return err
+	}
+	c.EnableSSH = sshIdx == 0
+
+	if c.EnableSSH {
+		if c.GitHubUser, err = p.askText(stateGitHubUser, "GitHub username", a.GitHubUser, false, func(s string) error {
+			if s == "" {
+				return fmt.Errorf("GitHub username is required for SSH key setup")
+			}
+			keys, err := config.FetchGitHubKeys(context.Background(), s)
+			if err != nil {
+				return fmt.Errorf("failed to fetch keys: %v", err)
+			}
+			if len(keys) == 0 {
+				return fmt.Errorf("no public SSH keys found for GitHub user %q", s)
+			}
+			c.SSHKeys = keys
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// askDisks picks the target disk(s) for the chosen storage mode
+func (p *plainSession) askDisks(c *config.Config) error {
+	if dryRun && len(p.answers.Disks) > 0 {
+		// Dry runs may plan for another machine, so take the disks as given
+		p.header(stateDisk)
+		if err := config.ValidateDiskSelection(c.StorageMode, p.answers.Disks); err != nil {
+			return fmt.Errorf("answer file: %w", err)
+		}
+		p.printf("Disks: %s (from answer file)\n", strings.Join(p.answers.Disks, " "))
+		c.Disks = p.answers.Disks
+		c.Disk = c.Disks[0]
+		if p.answers.FreeSpace {
+			// Gaps can only be found on this machine's disks
+			return p.askFreeSpace(c)
+		}
+		return p.askBootDisks(c)
+	}
+
+	disks, hidden, err := getAvailableDisks()
+	if err != nil {
+		return err
+	}
+	if len(disks) == 0 {
+		return fmt.Errorf("no disk to install to was found%s", hiddenSummary(hidden))
+	}
+	labels := make([]string, len(disks))
+	for i, d := range disks {
+		labels[i] = fmt.Sprintf("%-14s %8s  %s\n       %s", d.Path, d.Size, diskKind(d), strings.Join(diskDetails(d), "\n       "))
+	}
+
+	var preset []int
+	for _, path := range p.answers.Disks {
+		if idx := diskIndex(disks, path); idx >= 0 {
+			preset = append(preset, idx)
+		} else {
+			p.printf("! %v\n", missingDisk(path, hidden))
+			preset = nil
+			break
+		}
+	}
+
+	mode := c.StorageMode
+	if !mode.IsMultiDisk() {
+		presetIdx := -1
+		if len(preset) == 1 {
+			presetIdx = preset[0]
+		}
+		for {
+			idx, err := p.askChoice(stateDisk, labels, presetIdx)
+			if err != nil {
+				return err
+			}
+			ok, err := p.acceptDisks(mode, []disk.Info{disks[idx]}, idx == presetIdx)
+			if err != nil {
+				return err
+			}
+			presetIdx = -1
+			if !ok {
+				continue
+			}
+			c.Disk = disks[idx].Path
+			c.Disks = []string{c.Disk}
+			c.DiskLinks = diskLinks(disks, c.Disks)
+			return p.askFreeSpace(c)
+		}
+	}
+
+	if len(disks) < mode.MinDisks() {
+		return fmt.Errorf("%s requires at least %d disks, but only %d found%s", mode, mode.MinDisks(), len(disks), hiddenSummary(hidden))
+	}
+
+	p.header(stateDiskMulti)
+	for i, label := range labels {
+		p.printf("  %d) %s\n", i+1, label)
+	}
+	if h := hiddenSummary(hidden); h != "" {
+		p.printf("%s\n", strings.TrimPrefix(h, "; "))
+	}
+	for {
+		var selected []string
+		fromAnswers := preset != nil
+		if preset != nil {
+			for _, idx := range preset {
+				selected = append(selected, disks[idx].Path)
+			}
+			preset = nil
+			p.printf("Disks: %s (from answer file)\n", strings.Join(selected, " "))
+		} else {
+			line, err := p.readLine("Disk numbers, separated by spaces: ", false)
+			if err != nil {
+				return err
+			}
+			selected = nil
+			for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+				n, err := strconv.Atoi(field)
+				if err != nil || n < 1 || n > len(disks) {
+					selected = nil
+					p.printf("! %q is not a disk number between 1 and %d\n", field, len(disks))
+					break
+				}
+				selected = append(selected, disks[n-1].Path)
+			}
+			if selected == nil {
+				continue
+			}
+		}
+		if err := config.ValidateDiskSelection(mode, selected); err != nil {
+			p.printf("! %v\n", err)
+			continue
+		}
+		var infos []disk.Info
+		for _, path := range selected {
+			infos = append(infos, disks[diskIndex(disks, path)])
+		}
+		if ok, err := p.acceptDisks(mode, infos, fromAnswers); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		c.Disks = selected
+		c.Disk = selected[0] // First disk is the boot disk
+		c.DiskLinks = diskLinks(disks, c.Disks)
+		return p.askBootDisks(c)
+	}
+}
+
+// askBootDisks asks whether a redundant pool gets an ESP on every disk
+func (p *plainSession) askBootDisks(c *config.Config) error {
+	c.RedundantESP = false
+	if !c.StorageMode.HasRedundancy() {
+		return nil
+	}
+	preset := -1
+	if p.answers.RedundantESP != nil {
+		preset = 1
+		if *p.answers.RedundantESP {
+			preset = 0
+		}
+	} else if len(p.answers.Disks) > 0 {
+		preset = 1 // Answer files from before this question
+	}
+	idx, err := p.askChoice(stateBootDisks, []string{
+		fmt.Sprintf("ESP on every disk (recommended) - boots from any of the %d disks", len(c.Disks)),
+		"ESP on the first disk only - booting needs " + c.Disk,
+	}, preset)
+	if err != nil {
+		return err
+	}
+	c.RedundantESP = idx == 0
+	return nil
+}
+
+// acceptDisks prints the pre-flight problems of the selected disks and
+// reports whether to go on with them: never when a problem rules one out,
+// and after warnings only if the answer file chose the disks or the user
+// agrees
+func (p *plainSession) acceptDisks(mode config.StorageMode, selected []disk.Info, fromAnswers bool) (bool, error) {
+	checks := checkDisks(mode, selected)
+	for _, d := range selected {
+		for _, c := range checks[d.Path] {
+			p.printf("%s: %s\n", d.Path, c)
+		}
+	}
+	blocking, warnings := countChecks(checks)
+	switch {
+	case blocking > 0:
+		p.printf("! %d problem(s) marked x rule out the selected disk(s); choose others\n", blocking)
+		return false, nil
+	case warnings == 0 || fromAnswers:
+		return true, nil
+	}
+	line, err := p.readLine("Use these disks anyway? [y/N]: ", false)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(strings.TrimSpace(line), "y"), nil
+}
+
+// askFreeSpace offers to install into free space next to the partitions
+// already on c.Disk, when there is room for that
+func (p *plainSession) askFreeSpace(c *config.Config) error {
+	c.Alongside = nil
+	layout := readFreeSpace(c.Disk)
+	if layout == nil {
+		if p.answers.FreeSpace {
+			return fmt.Errorf("%s has no free space to install into (see the install log)", c.Disk)
+		}
+		return nil
+	}
+
+	p.printf("Existing partitions on %s:\n", c.Disk)
+	for _, part := range layout.Partitions {
+		p.printf("    %s\n", describePartition(layout, part))
+	}
+	preset := -1
+	if len(p.answers.Disks) > 0 {
+		preset = 0
+		if p.answers.FreeSpace {
+			preset = largestGapChoice(layout)
+		}
+	}
+	idx, err := p.askChoice(stateFreeSpace, freeSpaceChoices(layout), preset)
+	if err != nil {
+		return err
+	}
+	if idx > 0 {
+		c.Alongside = newAlongside(layout, layout.Gaps[idx-1])
+	}
+	return nil
+}
+
+// askSizes works out the sizes for c's disks and lets the user change
+// them until they fit. Answer files that name the disks are taken as they
+// are, unless their sizes do not fit.
+func (p *plainSession) askSizes(c *config.Config) error {
+	if err := calculateSpaceAllocation(c); err != nil {
+		return err
+	}
+	p.answers.ApplySizes(c)
+	fields := c.SizeFields()
+	if len(fields) == 0 {
+		return nil
+	}
+	p.header(stateSizes)
+	p.printf("%s available on %s\n", config.FormatSize(c.SpaceTotalGB<<10), diskSummary(*c))
+	if len(p.answers.Disks) > 0 {
+		err := c.ResolveSizes()
+		if err == nil {
+			for _, f := range fields {
+				p.printf("  %-12s %s\n", f.Label(), sizeOrNone(c.Size(f)))
+			}
+			return nil
+		}
+		p.printf("! answer file: %v\n", err)
+	}
+	p.printf("Enter keeps a size; sizes take M, G, T or %% of the pool; none removes the /home quota\n")
+	for {
+		for _, f := range fields {
+			line, err := p.readLine(fmt.Sprintf("  %-12s [%s]: ", f.Label(), sizeOrNone(c.Size(f))), false)
+			if err != nil {
+				return err
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				c.SetSize(f, line)
+			}
+		}
+		if err := c.ResolveSizes(); err != nil {
+			p.printf("! %v\n", strings.ReplaceAll(err.Error(), "\n", "\n! "))
+			continue
+		}
+		return nil
+	}
+}
+
+// askDatasets shows a ZFS pool's dataset layout and takes changes to it
+// one line at a time until an empty line. Answer files that name the
+// disks or the datasets are taken as they are, unless the layout does not
+// fit.
+func (p *plainSession) askDatasets(c *config.Config) error {
+	if !c.StorageMode.IsZFS() {
+		return nil
+	}
+	p.answers.ApplyDatasets(c)
+	p.header(stateDatasets)
+	for _, d := range c.ZFSDatasets() {
+		p.printf("  %-10s %-16s %s\n", d.Name, d.Mountpoint, datasetProperties(d))
+	}
+	if len(p.answers.Disks) > 0 || p.answers.Datasets != nil {
+		err := c.ValidateZFSLayout()
+		if err == nil {
+			return nil
+		}
+		p.printf("! answer file: %v\n", strings.ReplaceAll(err.Error(), "\n", "\n! "))
+	}
+	p.printf("Enter name /mountpoint property=value..., e.g. docker /var/lib/docker recordsize=16K\n")
+	p.printf("property= removes a property, -name removes a dataset, an empty line continues\n")
+	for {
+		line, err := p.readLine("  dataset: ", false)
+		if err != nil {
+			return err
+		}
+		next := *c
+		switch line = strings.TrimSpace(line); {
+		case line == "":
+			if err := c.ValidateZFSLayout(); err != nil {
+				p.printf("! %v\n", strings.ReplaceAll(err.Error(), "\n", "\n! "))
+				continue
+			}
+			return nil
+		case strings.HasPrefix(line, "-"):
+			if !next.RemoveDataset(line[1:]) {
+				p.printf("! no changes to dataset %s\n", line[1:])
+				continue
+			}
+		default:
+			d, err := config.ParseDataset(line)
+			if err != nil {
+				p.printf("! %v\n", err)
+				continue
+			}
+			next.SetDataset(d)
+		}
+		if err := next.ValidateZFSLayout(); err != nil {
+			p.printf("! %v\n", strings.ReplaceAll(err.Error(), "\n", "\n! "))
+			continue
+		}
+		*c = next
+		p.printf("  ok: %s\n", datasetSummary(*c))
+	}
+}
+
+// askText prompts until validate accepts the input. A preset answer is
+// used without prompting if it passes validation.
+func (p *plainSession) askText(state installState, prompt, preset string, hidden bool, validate func(string) error) (string, error) {
+	p.header(state)
+	if preset != "" {
+		err := validate(preset)
+		if err == nil {
+			shown := preset
+			if hidden {
+				shown = strings.Repeat("*", len(preset))
+			}
+			p.printf("%s: %s (from answer file)\n", prompt, shown)
+			return preset, nil
+		}
+		p.printf("! answer file: %v\n", err)
+	}
+	for {
+		val, err := p.readLine(prompt+": ", hidden)
+		if err != nil {
+			return "", err
+		}
+		if !hidden {
+			val = strings.TrimSpace(val)
+		}
+		if err := validate(val); err != nil {
+			p.printf("! %v\n", err)
+			continue
+		}
+		return val, nil
+	}
+}
+
+// askChoice shows a numbered list and returns the chosen index. A preset
+// index (>= 0) is used without prompting.
+func (p *plainSession) askChoice(state installState, options []string, preset int) (int, error) {
+	p.header(state)
+	for i, opt := range options {
+		p.printf("  %d) %s\n", i+1, opt)
+	}
+	if preset >= 0 && preset < len(options) {
+		p.printf("Choice: %d (from answer file)\n", preset+1)
+		return preset, nil
+	}
+	for {
+		line, err := p.readLine(fmt.Sprintf("Choice [1-%d]: ", len(options)), false)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil || n < 1 || n > len(options) {
+			p.printf("! enter a number between 1 and %d\n", len(options))
+			continue
+		}
+		return n - 1, nil
+	}
+}
+
+func (p *plainSession) printSummary(c config.Config) {
+	p.header(stateSummary)
+	p.printf("  Username:  %s\n", c.Username)
+	p.printf("  Full name: %s\n", c.Fullname)
+	p.printf("  Email:     %s\n", c.Email)
+	p.printf("  Hostname:  %s\n", c.Hostname)
+	p.printf("  Storage:   %s\n", c.StorageMode)
+	p.printf("  Disk(s):   %s\n", diskSummary(c))
+	for _, line := range diskLinkLines(c) {
+		p.printf("    %s\n", line)
+	}
+	p.printf("  Host ID:   %s\n", c.HostID)
+	p.printf("  Locale:    %s\n", c.Locale)
+	p.printf("  Keyboard:  %s\n", c.Keymap)
+	if c.EnableSSH {
+		p.printf("  SSH:       Enabled (GitHub: %s, %d key(s))\n", c.GitHubUser, len(c.SSHKeys))
+	} else {
+		p.printf("  SSH:       Disabled\n")
+	}
+	p.printf("  /boot:     %s\n", bootSummary(c))
+	if c.StorageMode.HasNixVolume() {
+		p.printf("  /nix:      %s\n", c.SpaceNix)
+		p.printf("  /home:     %s\n", homeSummary(c))
+		if c.StorageMode.IsZFS() {
+			p.printf("  Datasets:  %s\n", datasetSummary(c))
+		}
+	} else {
+		p.printf("  /:         %s\n", rootSummary(c))
+	}
+	if c.Alongside != nil {
+		create, keep := alongsidePlan(c)
+		p.printf("\nWill be created on %s:\n", c.Disk)
+		for _, line := range create {
+			p.printf("    %s\n", line)
+		}
+		p.printf("Will be left alone:\n")
+		for _, line := range keep {
+			p.printf("    %s\n", line)
+		}
+		return
+	}
+	p.printf("\n! ALL DATA ON %s WILL BE DESTROYED\n", strings.Join(c.Disks, ", "))
+}
+
+// header prints the numbered title of a wizard step
+func (p *plainSession) header(state installState) {
+	step := wizardSteps[state]
+	p.printf("\n[%d/%d] %s\n", step.stepNum, totalSteps, step.title)
+}
+
+func (p *plainSession) readLine(prompt string, hidden bool) (string, error) {
+	p.printf("%s", prompt)
+	if hidden && term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		p.printf("\n")
+		if err != nil {
+			return "", fmt.Errorf("read input: %w", err)
+		}
+		return string(b), nil
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("read input: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (p *plainSession) printf(format string, args ...interface{}) {
+	fmt.Fprintf(p.out, format, args...)
+}
+
+// logf prints a timestamped progress line
+func (p *plainSession) logf(format string, args ...interface{}) {
+	p.printf("%s %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, args...))
+}
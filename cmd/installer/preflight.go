@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+)
+
+// raidzSpreadPct is how much larger than the smallest member a raidz disk
+// may be before its unused space is pointed out
+const raidzSpreadPct = 10
+
+// diskCheck is a problem the pre-flight checks found on a selected disk
+type diskCheck struct {
+	Blocking bool // The disk cannot be installed to
+	Message  string
+}
+
+// String is how the disk lists show c
+func (c diskCheck) String() string {
+	if c.Blocking {
+		return "x " + c.Message
+	}
+	return "! " + c.Message
+}
+
+// checkDisks runs the pre-flight checks on the disks selected for mode:
+// SMART health, sector sizes, raidz members of very different sizes, USB
+// members of a pool and disks too small for the layout. It returns the
+// problems found, by disk path.
+func checkDisks(mode config.StorageMode, disks []disk.Info) map[string][]diskCheck {
+	ctx := context.Background()
+	checks := map[string][]diskCheck{}
+	add := func(d disk.Info, blocking bool, format string, args ...interface{}) {
+		c := diskCheck{Blocking: blocking, Message: fmt.Sprintf(format, args...)}
+		logInfo("Pre-flight check %s: %s", d.Path, c)
+		checks[d.Path] = append(checks[d.Path], c)
+	}
+
+	minMiB := mode.MinDiskMiB(len(disks))
+	var smallest int64
+	for _, d := range disks {
+		if smallest == 0 || d.Bytes < smallest {
+			smallest = d.Bytes
+		}
+	}
+
+	for _, d := range disks {
+		if d.Bytes>>20 < minMiB {
+			add(d, true, "too small: %s needs at least %s on each disk", mode, config.FormatSize(minMiB))
+		}
+		if d.PhysicalSectorSize > d.LogicalSectorSize && d.LogicalSectorSize > 0 {
+			add(d, false, "%d-byte sectors emulated on %d-byte physical sectors; smaller writes are read, changed and rewritten",
+				d.LogicalSectorSize, d.PhysicalSectorSize)
+		}
+		if mode.IsRaidz() && d.Bytes > smallest*(100+raidzSpreadPct)/100 {
+			add(d, false, "raidz uses only %s of it, the size of the smallest disk; %s stays unused",
+				disk.FormatBytes(smallest), disk.FormatBytes(d.Bytes-smallest))
+		}
+		if mode.IsMultiDisk() && d.Transport == "usb" {
+			add(d, false, "attached over USB; USB bridges drop out under load and can fault the pool")
+		}
+		checkSMART(ctx, d, add)
+	}
+	return checks
+}
+
+// checkSMART adds the problems d's SMART health shows
+func checkSMART(ctx context.Context, d disk.Info, add func(d disk.Info, blocking bool, format string, args ...interface{})) {
+	s, err := disk.ReadSMART(ctx, shell(), d.Path)
+	switch {
+	case err != nil:
+		logInfo("No SMART health for %s: %v", d.Path, err)
+		return
+	case s == nil:
+		logInfo("smartctl is not installed; SMART health not checked for %s", d.Path)
+		return
+	}
+	if !s.Passed {
+		add(d, true, "SMART health check FAILED: the disk expects to fail soon")
+	}
+	if s.CriticalWarning != 0 {
+		add(d, true, "SMART critical warning 0x%02x (spare space, temperature, reliability or read-only)", s.CriticalWarning)
+	}
+	var bad []string
+	if s.Reallocated > 0 {
+		bad = append(bad, fmt.Sprintf("%d reallocated", s.Reallocated))
+	}
+	if s.Pending > 0 {
+		bad = append(bad, fmt.Sprintf("%d pending", s.Pending))
+	}
+	if s.Uncorrectable > 0 {
+		bad = append(bad, fmt.Sprintf("%d uncorrectable", s.Uncorrectable))
+	}
+	if len(bad) > 0 {
+		add(d, false, "SMART: %s sectors; the disk is wearing out", strings.Join(bad, ", "))
+	}
+	if s.MediaErrors > 0 {
+		add(d, false, "SMART: %d media errors", s.MediaErrors)
+	}
+	if s.PercentUsed >= 100 {
+		add(d, false, "SMART: %d%% of the rated write endurance used", s.PercentUsed)
+	}
+}
+
+// countChecks counts the blocking problems and the warnings in checks
+func countChecks(checks map[string][]diskCheck) (blocking, warnings int) {
+	for _, list := range checks {
+		for _, c := range list {
+			if c.Blocking {
+				blocking++
+			} else {
+				warnings++
+			}
+		}
+	}
+	return blocking, warnings
+}
+
+// checkSelection runs the pre-flight checks on the selected disks and
+// reports whether the wizard may move on. A blocking problem stops it.
+// Warnings stop it once, so they can be read; Enter again with the same
+// disks accepts them, as does an answer file.
+func (m model) checkSelection(selected []disk.Info) (model, bool) {
+	var paths []string
+	for _, d := range selected {
+		paths = append(paths, d.Path)
+	}
+	key := strings.Join(paths, " ")
+	accept := m.acceptChecks
+	m.acceptChecks = false
+	if key != m.checkedDisks {
+		m.diskChecks = checkDisks(m.config.StorageMode, selected)
+		m.checkedDisks = key
+		m.checksSeen = false
+	}
+
+	blocking, warnings := countChecks(m.diskChecks)
+	switch {
+	case blocking > 0:
+		m.err = fmt.Errorf("%d problem(s) marked x rule out the selected disk(s); choose others", blocking)
+		return m, false
+	case warnings > 0 && !m.checksSeen && !accept:
+		m.checksSeen = true
+		m.err = fmt.Errorf("%d warning(s) about the selected disk(s); press Enter again to use them anyway", warnings)
+		return m, false
+	}
+	return m, true
+}
+
+// selectedDiskInfos lists the disks chosen on the disk screen
+func (m model) selectedDiskInfos() []disk.Info {
+	if m.state == stateDisk {
+		return []disk.Info{m.disks[m.selectedIdx]}
+	}
+	var selected []disk.Info
+	for i, sel := range m.diskSelected {
+		if sel {
+			selected = append(selected, m.disks[i])
+		}
+	}
+	return selected
+}
@@ -5,6 +5,11 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+	"github.com/timlinux/tuinix/cmd/installer/nixgen"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
 )
 
 // renderHeader creates the consistent header with logo and title
@@ -70,7 +75,7 @@ func (m model) renderRightPanel(stepNum int) string {
 	var content string
 
 	switch m.state {
-	case stateUsername, stateFullname, stateEmail, statePassword, statePasswordConfirm, stateHostname, statePassphrase, statePassphraseConfirm, stateGitHubUser, stateConfirm:
+	case stateUsername, stateFullname, stateEmail, statePassword, statePasswordConfirm, stateHostname, statePassphrase, statePassphraseConfirm, stateGitHubUser, stateExport, stateConfirm:
 		inputBox := lipgloss.NewStyle().
 			Border(lipgloss.NormalBorder()).
 			BorderForeground(colorNixBlue).
@@ -83,11 +88,19 @@ func (m model) renderRightPanel(stepNum int) string {
 		}
 
 		hint := grayStyle.Render("\nEnter to continue | Ctrl+C to quit")
+		if m.state == stateExport {
+			hint = grayStyle.Render("\nEnter to save | Esc to go back")
+		}
 		content = inputBox + errText + hint
+		if m.state == stateConfirm && m.config.Alongside != nil {
+			content = m.renderAlongsidePlan() + "\n\n" + content
+		} else if m.state == stateConfirm {
+			content = m.renderDestroyPreview() + "\n\n" + content
+		}
 
 	case stateStorageMode:
 		var modeList strings.Builder
-		for i, mode := range storageModes {
+		for i, mode := range config.StorageModes {
 			cursor := "  "
 			style := lipgloss.NewStyle().Foreground(colorOffWhite)
 			if i == m.selectedIdx {
@@ -96,7 +109,7 @@ func (m model) renderRightPanel(stepNum int) string {
 			}
 			modeList.WriteString(style.Render(cursor + mode.String()))
 			modeList.WriteString("\n")
-			modeList.WriteString(grayStyle.Render("   " + storageModeDescriptions[mode]))
+			modeList.WriteString(grayStyle.Render("   " + mode.Description()))
 			modeList.WriteString("\n")
 		}
 		hint := grayStyle.Render("\nUp/Down to select | Enter to confirm")
@@ -104,30 +117,66 @@ func (m model) renderRightPanel(stepNum int) string {
 
 	case stateDisk:
 		var diskList strings.Builder
-		for i, disk := range m.disks {
+		for i, d := range m.disks {
 			cursor := "  "
 			style := lipgloss.NewStyle().Foreground(colorOffWhite)
 			if i == m.selectedIdx {
 				cursor = "> "
 				style = style.Foreground(colorOrange).Bold(true)
 			}
-			line := fmt.Sprintf("%s%-10s %8s", cursor, disk.Path, disk.Size)
+			line := fmt.Sprintf("%s%-14s %8s  %s", cursor, d.Path, d.Size, diskKind(d))
 			diskList.WriteString(style.Render(line))
 			diskList.WriteString("\n")
-			if disk.Model != "" {
-				diskList.WriteString(grayStyle.Render("   " + disk.Model))
+			for _, detail := range diskDetails(d) {
+				diskList.WriteString(grayStyle.Render("   " + detail))
 				diskList.WriteString("\n")
 			}
+			for _, c := range m.diskChecks[d.Path] {
+				diskList.WriteString(checkStyle(c).Render("   " + c.String()))
+				diskList.WriteString("\n")
+			}
+		}
+		diskList.WriteString(grayStyle.Render(strings.TrimPrefix(hiddenSummary(m.hiddenDisks), "; ")))
+
+		warning := errorStyle.Render("! ALL DATA WILL BE DESTROYED!") + "\n" +
+			grayStyle.Render("  (unless you then choose free space next to another OS)")
+		var errText string
+		if m.err != nil {
+			errText = "\n" + errorStyle.Render("! "+m.err.Error())
+		}
+		hint := grayStyle.Render("\nUp/Down to select | Enter to confirm")
+		content = warning + "\n\n" + diskList.String() + errText + hint
+
+	case stateFreeSpace:
+		var optList strings.Builder
+		for i, opt := range freeSpaceChoices(m.layout) {
+			cursor := "  "
+			style := lipgloss.NewStyle().Foreground(colorOffWhite)
+			if i == m.selectedIdx {
+				cursor = "> "
+				style = style.Foreground(colorOrange).Bold(true)
+			}
+			optList.WriteString(style.Render(cursor + opt))
+			optList.WriteString("\n")
+		}
+
+		var parts strings.Builder
+		for _, p := range m.layout.Partitions {
+			parts.WriteString(grayStyle.Render("  " + describePartition(m.layout, p)))
+			parts.WriteString("\n")
 		}
 
-		warning := errorStyle.Render("! ALL DATA WILL BE DESTROYED!")
+		warning := warningStyle.Render("Existing partitions on " + m.config.Disk + ":")
+		if m.selectedIdx == 0 {
+			warning = errorStyle.Render("! These partitions WILL BE DESTROYED:")
+		}
 		hint := grayStyle.Render("\nUp/Down to select | Enter to confirm")
-		content = warning + "\n\n" + diskList.String() + hint
+		content = optList.String() + "\n" + warning + "\n" + parts.String() + hint
 
 	case stateDiskMulti:
 		var diskList strings.Builder
 		selectedCount := 0
-		for i, disk := range m.disks {
+		for i, d := range m.disks {
 			cursor := "  "
 			check := "[ ]"
 			style := lipgloss.NewStyle().Foreground(colorOffWhite)
@@ -139,16 +188,23 @@ func (m model) renderRightPanel(stepNum int) string {
 				check = "[x]"
 				selectedCount++
 			}
-			line := fmt.Sprintf("%s%s %-10s %8s", cursor, check, disk.Path, disk.Size)
+			line := fmt.Sprintf("%s%s %-14s %8s  %s", cursor, check, d.Path, d.Size, diskKind(d))
 			diskList.WriteString(style.Render(line))
 			diskList.WriteString("\n")
-			if disk.Model != "" {
-				diskList.WriteString(grayStyle.Render("      " + disk.Model))
+			for _, detail := range diskDetails(d) {
+				diskList.WriteString(grayStyle.Render("      " + detail))
 				diskList.WriteString("\n")
 			}
+			if m.diskSelected[i] {
+				for _, c := range m.diskChecks[d.Path] {
+					diskList.WriteString(checkStyle(c).Render("      " + c.String()))
+					diskList.WriteString("\n")
+				}
+			}
 		}
+		diskList.WriteString(grayStyle.Render(strings.TrimPrefix(hiddenSummary(m.hiddenDisks), "; ")))
 
-		minDisks := m.config.StorageMode.minDisks()
+		minDisks := m.config.StorageMode.MinDisks()
 		status := fmt.Sprintf("Selected: %d (min %d)", selectedCount, minDisks)
 		statusStyle := lipgloss.NewStyle().Foreground(colorDimGray)
 		if selectedCount >= minDisks {
@@ -163,6 +219,36 @@ func (m model) renderRightPanel(stepNum int) string {
 		hint := grayStyle.Render("\nSpace to toggle | Up/Down to move | Enter to confirm")
 		content = warning + "\n" + statusStyle.Render(status) + "\n\n" + diskList.String() + errText + hint
 
+	case stateSizes:
+		content = m.renderSizes()
+
+	case stateDatasets:
+		content = m.renderDatasets()
+
+	case stateBootDisks:
+		options := []struct {
+			label string
+			desc  string
+		}{
+			{"ESP on every disk (recommended)", fmt.Sprintf("Boots from any of the %d disks; GRUB keeps the copies in sync", len(m.config.Disks))},
+			{"ESP on the first disk only", "Booting needs " + m.config.Disk},
+		}
+		var optList strings.Builder
+		for i, opt := range options {
+			cursor := "  "
+			style := lipgloss.NewStyle().Foreground(colorOffWhite)
+			if i == m.selectedIdx {
+				cursor = "> "
+				style = style.Foreground(colorOrange).Bold(true)
+			}
+			optList.WriteString(style.Render(cursor + opt.label))
+			optList.WriteString("\n")
+			optList.WriteString(grayStyle.Render("   " + opt.desc))
+			optList.WriteString("\n")
+		}
+		hint := grayStyle.Render("\nUp/Down to select | Enter to confirm")
+		content = optList.String() + hint
+
 	case stateSSH:
 		sshOptions := []struct {
 			label string
@@ -222,23 +308,30 @@ func (m model) renderRightPanel(stepNum int) string {
 
 		// Build disk info section
 		var diskInfo string
-		if m.config.StorageMode.isMultiDisk() {
-			diskInfo = infoStyle.Render(fmt.Sprintf("  Disks:     %s", strings.Join(m.config.Disks, ", ")))
+		if m.config.StorageMode.IsMultiDisk() {
+			diskInfo = infoStyle.Render(fmt.Sprintf("  Disks:     %s", diskSummary(m.config)))
 		} else {
-			diskInfo = infoStyle.Render(fmt.Sprintf("  Disk:      %s", m.config.Disk))
+			diskInfo = infoStyle.Render(fmt.Sprintf("  Disk:      %s", diskSummary(m.config)))
+		}
+
+		for _, line := range diskLinkLines(m.config) {
+			diskInfo += "\n" + grayStyle.Render("    "+line)
 		}
 
 		// Build storage allocation section
 		var allocSection string
-		if m.config.StorageMode.isZFS() {
+		if m.config.StorageMode.HasNixVolume() {
 			allocSection = promptStyle.Render("Disk Allocation") + "\n" +
-				infoStyle.Render(fmt.Sprintf("  /boot:      %s", m.config.SpaceBoot)) + "\n" +
+				infoStyle.Render(fmt.Sprintf("  /boot:      %s", bootSummary(m.config))) + "\n" +
 				infoStyle.Render(fmt.Sprintf("  /nix:       %s", m.config.SpaceNix)) + "\n" +
-				infoStyle.Render(fmt.Sprintf("  /home:      remainder"))
+				infoStyle.Render(fmt.Sprintf("  /home:      %s", homeSummary(m.config)))
+			if m.config.StorageMode.IsZFS() {
+				allocSection += "\n" + infoStyle.Render(fmt.Sprintf("  Datasets:   %s", datasetSummary(m.config)))
+			}
 		} else {
 			allocSection = promptStyle.Render("Disk Allocation") + "\n" +
-				infoStyle.Render(fmt.Sprintf("  /boot:      %s", m.config.SpaceBoot)) + "\n" +
-				infoStyle.Render("  /:          remainder (XFS)")
+				infoStyle.Render(fmt.Sprintf("  /boot:      %s", bootSummary(m.config))) + "\n" +
+				infoStyle.Render("  /:          "+rootSummary(m.config))
 		}
 
 		sshStatus := "Disabled"
@@ -250,6 +343,11 @@ func (m model) renderRightPanel(stepNum int) string {
 				infoStyle.Render(fmt.Sprintf("  SSH keys:  %d key(s) imported", len(m.config.SSHKeys)))
 		}
 
+		var notice string
+		if m.notice != "" {
+			notice = successStyle.Render(m.notice) + "\n"
+		}
+
 		content = promptStyle.Render("User Account") + "\n" +
 			infoStyle.Render(fmt.Sprintf("  Username:  %s", m.config.Username)) + "\n" +
 			infoStyle.Render(fmt.Sprintf("  Full name: %s", m.config.Fullname)) + "\n" +
@@ -264,33 +362,154 @@ func (m model) renderRightPanel(stepNum int) string {
 			infoStyle.Render(fmt.Sprintf("  SSH:       %s", sshStatus)) +
 			sshExtra + "\n\n" +
 			allocSection + "\n\n" +
-			grayStyle.Render("Enter to proceed | Ctrl+C to cancel")
+			notice +
+			grayStyle.Render("Enter to proceed | e to export answer file | Ctrl+C to cancel")
 	}
 
 	return content
 }
 
+// renderAlongsidePlan lists the partitions an install into free space
+// creates and the ones it leaves alone, for the confirmation screen
+func (m model) renderAlongsidePlan() string {
+	create, keep := alongsidePlan(m.config)
+	var b strings.Builder
+	b.WriteString(successStyle.Render("Will be created on " + m.config.Disk + ":"))
+	for _, line := range create {
+		b.WriteString("\n" + lipgloss.NewStyle().Foreground(colorOffWhite).Render("  "+line))
+	}
+	b.WriteString("\n\n" + promptStyle.Render("Will be left alone:"))
+	for _, line := range keep {
+		b.WriteString("\n" + grayStyle.Render("  "+line))
+	}
+	return b.String()
+}
+
+// renderDestroyPreview lists what is on each disk that is about to be
+// erased, for the confirmation screen, and which serial number is asked
+// for now
+func (m model) renderDestroyPreview() string {
+	var b strings.Builder
+	b.WriteString(errorStyle.Render("! These disks WILL BE ERASED:"))
+	steps := confirmSteps(m.config, m.destroyPreview)
+	for i, p := range m.destroyPreview {
+		style := lipgloss.NewStyle().Foreground(colorOffWhite)
+		if m.confirmStep > 0 && i == m.confirmStep-1 {
+			style = style.Foreground(colorOrange).Bold(true)
+		}
+		b.WriteString("\n\n" + style.Render(previewHeader(p)))
+		for _, line := range previewItems(p) {
+			b.WriteString("\n" + grayStyle.Render("  "+line))
+		}
+	}
+	if len(steps) > 1 {
+		b.WriteString("\n\n" + promptStyle.Render(fmt.Sprintf("Step %d of %d: %s", m.confirmStep+1, len(steps), steps[m.confirmStep].Prompt)))
+	}
+	return b.String()
+}
+
 func (m model) getInstallStepNames() []string {
-	if m.config.StorageMode.isZFS() {
-		return []string{
-			"Generating host configuration",
-			"Formatting disk(s) with ZFS",
-			"Generating hardware configuration",
-			"Installing NixOS",
-			"Configuring ZFS boot",
-			"Copying flake to new system",
-			"Setting up user flake",
-			"Copying install log",
-			"Finalizing ZFS pool",
+	return pipeline.StepNames(m.config)
+}
+
+// rootSummary describes the root partition of the XFS, ext4 and LUKS modes
+func rootSummary(c config.Config) string {
+	if c.StorageMode.IsLUKS() {
+		return "remainder (" + c.StorageMode.RootFormat() + " in LUKS2)"
+	}
+	return "remainder (XFS)"
+}
+
+// unlockPrompt asks for the passphrase of an encrypted install's pool or
+// LUKS container
+func unlockPrompt(c config.Config) string {
+	if c.StorageMode.IsLUKS() {
+		return "Passphrase for LUKS container " + nixgen.LUKSName + ": "
+	}
+	return "Passphrase for pool " + c.ZFSPoolName + ": "
+}
+
+// diskKind describes how d is attached and what it is, e.g. "nvme SSD"
+func diskKind(d disk.Info) string {
+	media := "SSD"
+	if d.Rotational {
+		media = "HDD"
+	}
+	if d.Transport == "" {
+		return media
+	}
+	return d.Transport + " " + media
+}
+
+// diskDetails are the lines shown under a disk in the disk lists: model
+// and serial, sector sizes, what is on it and whether any of it is mounted
+func diskDetails(d disk.Info) []string {
+	var lines []string
+	var ident []string
+	if d.Model != "" {
+		ident = append(ident, d.Model)
+	}
+	if d.Serial != "" {
+		ident = append(ident, "S/N "+d.Serial)
+	}
+	if len(ident) > 0 {
+		lines = append(lines, strings.Join(ident, ", "))
+	}
+	sectors := fmt.Sprintf("%d-byte sectors", d.LogicalSectorSize)
+	if d.PhysicalSectorSize != d.LogicalSectorSize {
+		sectors = fmt.Sprintf("%d-byte sectors (%d physical)", d.LogicalSectorSize, d.PhysicalSectorSize)
+	}
+	contents := "empty"
+	switch fs := d.Filesystems(); {
+	case d.FSType != "":
+		contents = d.FSType + " on the whole disk"
+	case len(fs) > 0:
+		contents = fmt.Sprintf("%d partition(s): %s", len(d.Partitions), strings.Join(fs, ", "))
+	case len(d.Partitions) > 0:
+		contents = fmt.Sprintf("%d partition(s)", len(d.Partitions))
+	}
+	if stable := d.StablePath(); stable != d.Path {
+		lines = append(lines, stable)
+	}
+	lines = append(lines, sectors+", "+contents)
+	if reason := d.Hidden(); reason != "" {
+		lines = append(lines, "! "+reason)
+	}
+	return lines
+}
+
+// checkStyle shows blocking pre-flight problems as errors and the rest
+// as warnings
+func checkStyle(c diskCheck) lipgloss.Style {
+	if c.Blocking {
+		return errorStyle
+	}
+	return warningStyle
+}
+
+// diskLinkLines pair each of c's disks with the stable name disks.nix
+// uses for it, for the summary screens
+func diskLinkLines(c config.Config) []string {
+	var lines []string
+	for _, d := range c.Disks {
+		if link := c.DiskLink(d); link != d {
+			lines = append(lines, fmt.Sprintf("%s = %s", d, link))
+		} else {
+			lines = append(lines, d+" (no /dev/disk link; kernel name used)")
 		}
 	}
-	return []string{
-		"Generating host configuration",
-		"Formatting disk with XFS",
-		"Generating hardware configuration",
-		"Installing NixOS",
-		"Copying flake to new system",
-		"Setting up user flake",
-		"Copying install log",
+	return lines
+}
+
+// hiddenSummary lists the disks left out of the disk list and why, for
+// the disk screens and errors
+func hiddenSummary(hidden []disk.Info) string {
+	if len(hidden) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, d := range hidden {
+		parts = append(parts, fmt.Sprintf("%s (%s)", d.Path, d.Hidden()))
 	}
+	return "; not shown: " + strings.Join(parts, ", ") + " - use --all-disks to list them"
 }
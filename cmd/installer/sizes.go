@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/timlinux/tuinix/cmd/installer/config"
+)
+
+// sizeField is the size the editor's input is on
+func (m model) sizeField() config.SizeField {
+	return m.config.SizeFields()[m.selectedIdx]
+}
+
+// focusSize puts the value of the selected size into the input
+func (m model) focusSize() model {
+	f := m.sizeField()
+	m.input.SetValue(m.config.Size(f))
+	m.input.CursorEnd()
+	m.input.Placeholder = "e.g. 40G or 10%"
+	if f == config.SizeHome {
+		m.input.Placeholder = "empty for no quota"
+	}
+	return m
+}
+
+// handleSizeKey moves between the sizes of the size editor. Anything
+// else is left to the input.
+func (m model) handleSizeKey(key string) (model, bool) {
+	n := len(m.config.SizeFields())
+	switch key {
+	case "up", "shift+tab":
+		m.config.SetSize(m.sizeField(), m.input.Value())
+		m.selectedIdx = (m.selectedIdx + n - 1) % n
+	case "down", "tab":
+		m.config.SetSize(m.sizeField(), m.input.Value())
+		m.selectedIdx = (m.selectedIdx + 1) % n
+	default:
+		return m, false
+	}
+	return m.focusSize(), true
+}
+
+// renderSizes is the size editor: a bar of how the space is shared out,
+// the editable sizes and what is wrong with them
+func (m model) renderSizes() string {
+	u, _ := m.config.SpaceUse()
+
+	var fields strings.Builder
+	for i, f := range m.config.SizeFields() {
+		label := fmt.Sprintf("%-12s", f.Label())
+		if i == m.selectedIdx {
+			fields.WriteString(lipgloss.NewStyle().Foreground(colorOrange).Bold(true).Render("  "+label) + " " + m.input.View())
+		} else {
+			fields.WriteString(lipgloss.NewStyle().Foreground(colorOffWhite).Render("  "+label) + " " + sizeOrNone(m.config.Size(f)))
+		}
+		fields.WriteString("\n")
+	}
+
+	total := fmt.Sprintf("%s available on %s", config.FormatSize(u.Total), diskSummary(m.config))
+	if m.config.Alongside != nil {
+		total += ", /boot on the shared ESP"
+	}
+
+	var errText string
+	if m.err != nil {
+		for _, line := range strings.Split(m.err.Error(), "\n") {
+			errText += "\n" + errorStyle.Render("! "+line)
+		}
+	}
+	hint := grayStyle.Render("\nUp/Down or Tab to move | Enter to confirm")
+	return grayStyle.Render(total) + "\n\n" +
+		m.renderSpaceBar(u, m.width/2-8) + "\n\n" +
+		fields.String() + errText + hint
+}
+
+// renderSpaceBar draws u as a bar width cells wide with a legend below it.
+// Every part that has any space gets at least one cell.
+func (m model) renderSpaceBar(u config.SpaceUse, width int) string {
+	if width < 20 {
+		width = 20
+	}
+	type part struct {
+		label string
+		size  int64
+		color lipgloss.Color
+	}
+	rest := "/"
+	if m.config.StorageMode.HasNixVolume() && u.Home == 0 {
+		rest = "/ and /home"
+	}
+	parts := []part{
+		{"/boot", u.Boot, colorEarth},
+		{"/nix", u.Nix, colorNixBlue},
+		{"atuin", u.Atuin, colorAmber},
+		{"/home", u.Home, colorGreen},
+		{rest, max(u.Free(), 0), colorDimGray},
+	}
+
+	var used int64
+	for _, p := range parts {
+		used += p.size
+	}
+	if u.Total > used {
+		used = u.Total
+	}
+	if used == 0 {
+		return ""
+	}
+
+	var bar, legend strings.Builder
+	cells := 0
+	for i, p := range parts {
+		if p.size == 0 {
+			continue
+		}
+		n := int(p.size * int64(width) / used)
+		if n == 0 {
+			n = 1
+		}
+		if i == len(parts)-1 && cells+n < width {
+			n = width - cells // Rounding leftovers go to the free space
+		}
+		cells += n
+		style := lipgloss.NewStyle().Foreground(p.color)
+		bar.WriteString(style.Render(strings.Repeat("█", n)))
+		legend.WriteString(style.Render("■ ") + grayStyle.Render(fmt.Sprintf("%s %s  ", p.label, config.FormatSize(p.size))))
+	}
+	if u.Free() < config.MinRootMiB {
+		legend.WriteString(errorStyle.Render("/ needs " + config.FormatSize(config.MinRootMiB)))
+	}
+	return bar.String() + "\n" + legend.String()
+}
+
+// homeSummary describes the space /home gets, for the summary screens
+func homeSummary(c config.Config) string {
+	if c.SpaceHome == "" {
+		return "remainder"
+	}
+	return c.SpaceHome + " quota"
+}
+
+// sizeOrNone shows an unset size (a /home without a quota) as none
+func sizeOrNone(size string) string {
+	if size == "" {
+		return "none"
+	}
+	return size
+}
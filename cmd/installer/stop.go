@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// errStoppedByUser is why an installation the user stopped ended
+var errStoppedByUser = errors.New("installation stopped by the user")
+
+// stopSignals ask the installer to stop. While an installation runs they
+// open the stop confirmation instead of killing the installer outright.
+var stopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// signalMsg delivers a stop signal to the TUI
+type signalMsg struct{ sig os.Signal }
+
+// forwardSignals sends stop signals to p as messages. Bubble Tea's own
+// handler would quit at once and leave the install running unwatched.
+func forwardSignals(p *tea.Program) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, stopSignals...)
+	go func() {
+		for sig := range ch {
+			logInfo("Received %s", sig)
+			p.Send(signalMsg{sig: sig})
+		}
+	}()
+}
+
+// newInstallContext returns the context an installation runs under and
+// the function that stops it
+func newInstallContext() (context.Context, context.CancelCauseFunc) {
+	return context.WithCancelCause(context.Background())
+}
+
+// requestStop opens the stop confirmation, or quits if nothing is
+// installing. A second request while the dialog is open is ignored, so
+// the installer never quits silently in the middle of a step.
+func (m model) requestStop() (tea.Model, tea.Cmd) {
+	if m.state != stateInstalling {
+		return m, tea.Quit
+	}
+	if !m.stopping {
+		m.confirmStop = true
+	}
+	return m, nil
+}
+
+// handleStopKey answers the stop confirmation
+func (m model) handleStopKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "y", "Y":
+		m.confirmStop = false
+		m.stopping = true
+		logInfo("User stopped the installation during %q", m.installStepName)
+		m.stopInstall(errStoppedByUser)
+	case "n", "N", "esc":
+		m.confirmStop = false
+	}
+	return m, nil
+}
+
+// renderStopDialog shows whether the running step can be stopped safely
+func (m model) renderStopDialog() string {
+	box := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 2).
+		Width(m.width / 2)
+
+	if m.stopping {
+		return box.BorderForeground(colorOrange).Render(
+			warningStyle.Render("Stopping the installation...") + "\n" +
+				grayStyle.Render("Waiting for the running command to exit, then cleaning up."))
+	}
+
+	var safety string
+	if m.installDestructive {
+		safety = errorStyle.Bold(true).Render("It is NOT safe to stop now.") + "\n" +
+			lipgloss.NewStyle().Foreground(colorOffWhite).Render(
+				"\""+m.installStepName+"\" is writing the disks. Stopping leaves them "+
+					"half-formatted: nothing on them will be usable until they are "+
+					"formatted again by a new installation.")
+	} else {
+		safety = successStyle.Render("It is safe to stop now.") + "\n" +
+			lipgloss.NewStyle().Foreground(colorOffWhite).Render(
+				"\""+m.installStepName+"\" will be stopped and the live system cleaned up. "+
+					"If the disks were already formatted, you can resume later.")
+	}
+	return box.BorderForeground(colorRed).Render(
+		titleStyle.Render("Stop the installation?") + "\n\n" +
+			safety + "\n\n" +
+			grayStyle.Render("y to stop | n or Esc to keep installing"))
+}
@@ -0,0 +1,55 @@
+package system
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Host reads the files of the live system that the installer looks at
+// directly rather than through a command: sysfs, /proc, device nodes and
+// /etc/hostid. Paths are absolute, as on the live system.
+type Host interface {
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	EvalSymlinks(name string) (string, error)
+}
+
+// RootHost is a Host whose / is the directory it names, so tests can
+// stand in a fake sysfs or /dev. Symlinks in it must be relative. The
+// empty RootHost is the live system itself.
+type RootHost string
+
+func (r RootHost) path(name string) string {
+	if r == "" {
+		return name
+	}
+	return filepath.Join(string(r), name)
+}
+
+func (r RootHost) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(r.path(name))
+}
+
+func (r RootHost) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(r.path(name))
+}
+
+func (r RootHost) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(r.path(name))
+}
+
+// EvalSymlinks resolves the symlinks in name as filepath.EvalSymlinks
+// does, returning a path on the Host
+func (r RootHost) EvalSymlinks(name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(r.path(name))
+	if err != nil || r == "" {
+		return resolved, err
+	}
+	rel, err := filepath.Rel(string(r), resolved)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("/", rel), nil
+}
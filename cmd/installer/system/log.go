@@ -0,0 +1,20 @@
+package system
+
+import "log"
+
+// Logger receives the diagnostic messages of every installer package.
+// Messages are dropped while it is nil, so embedding programs that do not
+// want the log need not set it.
+var Logger *log.Logger
+
+func LogInfo(format string, args ...interface{}) {
+	if Logger != nil {
+		Logger.Printf("[INFO] "+format, args...)
+	}
+}
+
+func LogError(format string, args ...interface{}) {
+	if Logger != nil {
+		Logger.Printf("[ERROR] "+format, args...)
+	}
+}
@@ -0,0 +1,242 @@
+// Package system runs the external programs the installer depends on
+// (lsblk, disko, zpool, nixos-install, ...). Every call goes through a
+// Runner, so a whole install can be executed, planned, recorded or
+// replayed without changing the code that issues the commands.
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Command is one invocation of an external program
+type Command struct {
+	Name  string   `json:"name"`
+	Args  []string `json:"args"`
+	Stdin string   `json:"stdin,omitempty"`
+	Env   []string `json:"env,omitempty"`   // Added to the installer's own environment
+	Local bool     `json:"local,omitempty"` // Only reads the live system or writes the work dir
+}
+
+func (c Command) String() string {
+	s := FormatCommand(c.Name, c.Args)
+	if c.Stdin != "" {
+		s += " < (stdin)"
+	}
+	return s
+}
+
+// Result is what a command printed
+type Result struct {
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// Combined returns stdout followed by stderr
+func (r Result) Combined() string {
+	return r.Stdout + r.Stderr
+}
+
+// Runner executes external programs. Implementations must stop the
+// program when ctx is cancelled.
+type Runner interface {
+	Run(ctx context.Context, cmd Command) (Result, error)
+}
+
+// How long a cancelled command's process group gets to exit after SIGTERM
+// before it is killed
+const killGrace = 10 * time.Second
+
+// ExecRunner runs commands for real. Each command gets its own process
+// group, so cancelling ctx stops everything it started (disko's sgdisk and
+// mkfs, nixos-install's nix build, ...) and not just the command itself.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, c Command) (Result, error) {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	if c.Stdin != "" {
+		cmd.Stdin = strings.NewReader(c.Stdin)
+	}
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	done := make(chan struct{})
+	defer close(done)
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		LogInfo("Stopping %s (process group %d)", c.Name, pgid)
+		go func() {
+			select {
+			case <-done:
+			case <-time.After(killGrace):
+				syscall.Kill(-pgid, syscall.SIGKILL)
+			}
+		}()
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+	// Stop waiting for output from stray processes that escaped the group
+	cmd.WaitDelay = 2 * killGrace
+
+	err := cmd.Run()
+	return Result{Stdout: stdout.String(), Stderr: stderr.String()}, err
+}
+
+// DryRunRunner runs local commands and records everything else without
+// running it, so the plan for a real install can be printed
+type DryRunRunner struct {
+	Next     Runner
+	Recorded []Command
+}
+
+func (r *DryRunRunner) Run(ctx context.Context, c Command) (Result, error) {
+	if c.Local {
+		return r.Next.Run(ctx, c)
+	}
+	LogInfo("Dry run, not running: %s", c)
+	r.Recorded = append(r.Recorded, c)
+	return Result{}, nil
+}
+
+// RecordedCommand is one line of a recording: the exact command, what it
+// printed and how it ended
+type RecordedCommand struct {
+	Command Command `json:"command"`
+	Result  Result  `json:"result"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// RecordingRunner passes commands to the next runner and writes each one,
+// with its stdin, environment and result, as a JSON line. The recording
+// contains passphrases, so keep it private.
+type RecordingRunner struct {
+	next Runner
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewRecordingRunner(next Runner, w io.Writer) *RecordingRunner {
+	return &RecordingRunner{next: next, enc: json.NewEncoder(w)}
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, c Command) (Result, error) {
+	res, err := r.next.Run(ctx, c)
+	rec := RecordedCommand{Command: c, Result: res}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if encErr := r.enc.Encode(rec); encErr != nil {
+		LogError("Recording command failed: %v", encErr)
+	}
+	return res, err
+}
+
+// ReplayRunner answers commands from a script, usually a recording. Each
+// command must match the next scripted one exactly; a mismatch is an error,
+// so a replay shows precisely where an install flow diverged.
+type ReplayRunner struct {
+	mu     sync.Mutex
+	script []RecordedCommand
+	pos    int
+}
+
+func NewReplayRunner(script []RecordedCommand) *ReplayRunner {
+	return &ReplayRunner{script: script}
+}
+
+// LoadReplayScript reads a recording written by RecordingRunner
+func LoadReplayScript(path string) ([]RecordedCommand, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open replay script: %w", err)
+	}
+	defer f.Close()
+
+	var script []RecordedCommand
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec RecordedCommand
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		script = append(script, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read replay script: %w", err)
+	}
+	return script, nil
+}
+
+func (r *ReplayRunner) Run(ctx context.Context, c Command) (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pos >= len(r.script) {
+		return Result{}, fmt.Errorf("replay: unexpected command %s (script has %d commands)", c, len(r.script))
+	}
+	want := r.script[r.pos]
+	if !sameCommand(want.Command, c) {
+		return Result{}, fmt.Errorf("replay: command %d is %s, script expects %s", r.pos+1, c, want.Command)
+	}
+	r.pos++
+	if want.Error != "" {
+		return want.Result, errors.New(want.Error)
+	}
+	return want.Result, nil
+}
+
+// Remaining reports scripted commands that were never run
+func (r *ReplayRunner) Remaining() []Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var left []Command
+	for _, rec := range r.script[r.pos:] {
+		left = append(left, rec.Command)
+	}
+	return left
+}
+
+// Report logs any scripted commands the replay never reached. It does
+// nothing on a nil runner, so callers need not check whether replay is on.
+func (r *ReplayRunner) Report() {
+	if r == nil {
+		return
+	}
+	for _, c := range r.Remaining() {
+		LogError("Replay: scripted command never run: %s", c)
+	}
+}
+
+func sameCommand(a, b Command) bool {
+	return a.Name == b.Name && a.Stdin == b.Stdin &&
+		reflect.DeepEqual(normalizeArgs(a.Args), normalizeArgs(b.Args)) &&
+		reflect.DeepEqual(normalizeArgs(a.Env), normalizeArgs(b.Env))
+}
+
+func normalizeArgs(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
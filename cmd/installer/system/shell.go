@@ -0,0 +1,81 @@
+package system
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Shell issues commands through a Runner, logging each one and its
+// outcome. The helpers mirror the kinds of command the installer runs.
+type Shell struct {
+	Runner Runner
+
+	// OnCommand, if set, is called after every command with its result
+	OnCommand func(c Command, res Result, err error)
+}
+
+// Run passes a command to the runner, logging it and reporting it to
+// OnCommand
+func (s Shell) Run(ctx context.Context, c Command) (Result, error) {
+	cmdStr := c.Name + " " + strings.Join(c.Args, " ")
+	LogInfo("Running command: %s", cmdStr)
+
+	res, err := s.Runner.Run(ctx, c)
+	output := res.Combined()
+	if err != nil {
+		LogError("Command failed: %s\nError: %v\nOutput: %s", cmdStr, err, output)
+	} else {
+		LogInfo("Command succeeded: %s", cmdStr)
+		if output != "" {
+			LogInfo("Output: %s", output)
+		}
+	}
+	if s.OnCommand != nil {
+		s.OnCommand(c, res, err)
+	}
+	return res, err
+}
+
+// Exec runs a command that changes the target system (disks, /mnt, the
+// live system's hostid) and returns its combined output. A DryRunRunner
+// records it instead of running it.
+func (s Shell) Exec(ctx context.Context, name string, args ...string) (string, error) {
+	res, err := s.Run(ctx, Command{Name: name, Args: args})
+	return res.Combined(), err
+}
+
+// ExecInput is Exec with data piped to the command's stdin. The input is
+// never logged since it usually carries a passphrase.
+func (s Shell) ExecInput(ctx context.Context, stdin string, name string, args ...string) (string, error) {
+	res, err := s.Run(ctx, Command{Name: name, Args: args, Stdin: stdin})
+	return res.Combined(), err
+}
+
+// Local runs a command that only touches the installer's own work
+// directory or the live system (never the target disks). It runs even in
+// a dry run.
+func (s Shell) Local(ctx context.Context, name string, args ...string) (string, error) {
+	res, err := s.Run(ctx, Command{Name: name, Args: args, Local: true})
+	return res.Combined(), err
+}
+
+// Query runs a read-only local command and returns only its stdout, for
+// output that is parsed (lsblk, blkid, ...)
+func (s Shell) Query(ctx context.Context, name string, args ...string) (string, error) {
+	res, err := s.Run(ctx, Command{Name: name, Args: args, Local: true})
+	return res.Stdout, err
+}
+
+// FormatCommand renders argv for display, quoting arguments that contain
+// whitespace or quotes so the line could be pasted into a shell
+func FormatCommand(name string, args []string) string {
+	parts := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'$`\\") {
+			arg = strconv.Quote(arg)
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,67 +1,19 @@
 package main
 
 import (
+	"context"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	"github.com/charmbracelet/harmonica"
 	"github.com/charmbracelet/lipgloss"
-)
-
-// Storage mode determines disk layout strategy
-type storageMode int
 
-const (
-	storageZFSEncryptedSingle storageMode = iota // Encrypted ZFS, single disk (original)
-	storageXFS                                   // XFS unencrypted, single disk (max performance)
-	storageZFSStripe                             // Encrypted ZFS stripe, multi-disk (combined space)
-	storageZFSRaidz                              // Encrypted ZFS raidz, multi-disk (1 disk fault tolerance)
-	storageZFSRaidz2                             // Encrypted ZFS raidz2, multi-disk (2 disk fault tolerance)
+	"github.com/timlinux/tuinix/cmd/installer/config"
+	"github.com/timlinux/tuinix/cmd/installer/disk"
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
 )
 
-func (s storageMode) String() string {
-	switch s {
-	case storageZFSEncryptedSingle:
-		return "Encrypted ZFS (single disk)"
-	case storageXFS:
-		return "XFS unencrypted (max performance)"
-	case storageZFSStripe:
-		return "Encrypted ZFS stripe (combined space)"
-	case storageZFSRaidz:
-		return "Encrypted ZFS raidz (1-disk fault tolerance)"
-	case storageZFSRaidz2:
-		return "Encrypted ZFS raidz2 (2-disk fault tolerance)"
-	default:
-		return "Unknown"
-	}
-}
-
-func (s storageMode) isZFS() bool {
-	return s != storageXFS
-}
-
-func (s storageMode) isEncrypted() bool {
-	return s != storageXFS
-}
-
-func (s storageMode) isMultiDisk() bool {
-	return s == storageZFSStripe || s == storageZFSRaidz || s == storageZFSRaidz2
-}
-
-func (s storageMode) minDisks() int {
-	switch s {
-	case storageZFSRaidz:
-		return 3
-	case storageZFSRaidz2:
-		return 4
-	case storageZFSStripe:
-		return 2
-	default:
-		return 1
-	}
-}
-
 // Installation state
 type installState int
 
@@ -78,7 +30,11 @@ const (
 	stateHostname
 	stateStorageMode
 	stateDisk
+	stateFreeSpace
 	stateDiskMulti
+	stateBootDisks
+	stateSizes
+	stateDatasets
 	statePassphrase
 	statePassphraseConfirm
 	stateLocale
@@ -86,10 +42,13 @@ const (
 	stateSSH
 	stateGitHubUser
 	stateSummary
+	stateExport
 	stateConfirm
 	stateInstalling
 	stateComplete
 	stateError
+	stateResume
+	stateResumePassphrase
 )
 
 // Step information for wizard
@@ -99,22 +58,6 @@ type stepInfo struct {
 	stepNum     int
 }
 
-var storageModes = []storageMode{
-	storageZFSEncryptedSingle,
-	storageXFS,
-	storageZFSStripe,
-	storageZFSRaidz,
-	storageZFSRaidz2,
-}
-
-var storageModeDescriptions = map[storageMode]string{
-	storageZFSEncryptedSingle: "Single disk with AES-256-GCM encryption, compression, and snapshots",
-	storageXFS:                "Single disk, no encryption. Maximum raw I/O performance",
-	storageZFSStripe:          "Multiple disks combined for maximum space (no redundancy)",
-	storageZFSRaidz:           "Multiple disks with single parity. Tolerates 1 disk failure (min 3 disks)",
-	storageZFSRaidz2:          "Multiple disks with double parity. Tolerates 2 disk failures (min 4 disks)",
-}
-
 var wizardSteps = map[installState]stepInfo{
 	stateUsername: {
 		title: "User Account",
@@ -207,14 +150,24 @@ Single disk options:
 • Encrypted ZFS - Secure, with snapshots
   and compression (recommended)
 • XFS - Maximum performance, no encryption
+• LUKS + XFS / LUKS + ext4 - Encrypted,
+  without ZFS (for aarch64 machines)
+• Btrfs - Subvolumes, compression and
+  snapshots without ZFS (optional LUKS)
 
 Multi-disk options (requires 2+ disks):
 • ZFS Stripe - Combines all disks into one
   pool for maximum space (no redundancy)
+• ZFS Mirror - A full copy on every disk
+  (needs 2+ disks)
+• ZFS Striped mirrors (RAID10) - Mirrored
+  pairs, striped (needs 4, 6, 8... disks)
 • ZFS Raidz - Single parity, tolerates 1
   disk failure (needs 3+ disks)
 • ZFS Raidz2 - Double parity, tolerates 2
-  disk failures (needs 4+ disks)`,
+  disk failures (needs 4+ disks)
+• ZFS Raidz3 - Triple parity, tolerates 3
+  disk failures (needs 5+ disks)`,
 		stepNum: 7,
 	},
 	stateDisk: {
@@ -224,7 +177,29 @@ Multi-disk options (requires 2+ disks):
 WARNING: The selected disk will be
 COMPLETELY ERASED! All existing data,
 partitions, and operating systems will
-be destroyed.`,
+be destroyed.
+
+The disk is checked before moving on:
+SMART health, sector sizes and size.
+Lines marked x rule a disk out; lines
+marked ! are warnings.`,
+		stepNum: 8,
+	},
+	stateFreeSpace: {
+		title: "Install Alongside",
+		description: `This disk already has partitions and
+enough free space for tuinix.
+
+Choose a free space to install into
+next to the other operating system:
+• A new partition is created in the
+  free space for tuinix
+• The existing EFI system partition is
+  shared as /boot; it is not formatted
+• Every other partition is left alone
+
+Or use the whole disk, which ERASES
+everything on it.`,
 		stepNum: 8,
 	},
 	stateDiskMulti: {
@@ -239,19 +214,65 @@ every selected disk will be destroyed.
 
 Use Space to toggle disk selection.
 The first selected disk will also host
-the EFI boot partition.
-
-Press Enter when done selecting.`,
+the EFI boot partition; mirror and raidz
+pools can put one on every disk.
+
+Press Enter when done selecting. The
+selected disks are then checked: SMART
+health, sector and size mismatches and
+USB attachment. Lines marked x rule a
+disk out; lines marked ! are warnings.`,
 		stepNum: 8,
 	},
+	stateSizes: {
+		title: "Disk Space",
+		description: `Choose how much space each part of
+the system gets.
+
+• /boot - the EFI system partition
+  that holds kernels (default 5G)
+• /nix quota - the most the Nix store
+  may grow to (default 5% of the pool)
+• atuin - a small volume for shell
+  history (ZFS only)
+• /home quota - optional; leave it
+  empty and /home shares everything
+  left over with /
+
+Sizes take M, G or T, e.g. 512M or
+40G, or a percentage of the pool such
+as 10%. They are checked against the
+disk(s) as you type.`,
+		stepNum: 9,
+	},
+	stateDatasets: {
+		title: "ZFS Datasets",
+		description: `Add datasets to the pool or change
+the properties of the built-in ones.
+
+Each line is a name, a mountpoint and
+property=value pairs, e.g.
+
+  docker /var/lib/docker recordsize=16K
+  log /var/log compression=zstd
+  srv /srv quota=200G atime=off
+
+Properties: recordsize, compression,
+atime, quota, reservation,
+com.sun:auto-snapshot and more.
+
+Clear a line and press Enter to remove
+a dataset or undo your changes.`,
+		stepNum: 9,
+	},
 	statePassphrase: {
-		title: "ZFS Encryption Passphrase",
+		title: "Disk Encryption Passphrase",
 		description: `Set the encryption passphrase for your
-ZFS pool.
+ZFS pool or LUKS container.
 
 This passphrase will be required every
 time you boot the system. It protects
-all data on disk with AES-256-GCM
+all data on disk with AES-256
 encryption.
 
 Requirements:
@@ -261,16 +282,16 @@ Requirements:
 
 If you forget this passphrase, your
 data cannot be recovered.`,
-		stepNum: 9,
+		stepNum: 10,
 	},
 	statePassphraseConfirm: {
 		title: "Confirm Passphrase",
-		description: `Please re-enter your ZFS encryption
+		description: `Please re-enter your disk encryption
 passphrase to confirm.
 
 Make sure you remember this passphrase.
 You will need it every time you boot.`,
-		stepNum: 10,
+		stepNum: 11,
 	},
 	stateLocale: {
 		title: "System Locale",
@@ -284,7 +305,7 @@ This configures:
 
 The locale affects terminal output,
 file sorting, and application behavior.`,
-		stepNum: 11,
+		stepNum: 12,
 	},
 	stateKeymap: {
 		title: "Keyboard Layout",
@@ -299,7 +320,26 @@ Common layouts:
 • uk - UK English
 • de - German (QWERTZ)
 • fr - French (AZERTY)`,
-		stepNum: 12,
+		stepNum: 13,
+	},
+	stateBootDisks: {
+		title: "Boot Partitions",
+		description: `Choose which disks of the pool get an
+EFI system partition.
+
+With an ESP on every disk the machine
+still boots when any one disk fails:
+• /boot is on the first disk and GRUB
+  copies itself and the kernels to the
+  others on every rebuild
+• Each ESP gets its own firmware boot
+  entry, so the firmware falls back to
+  the next one
+
+With the first disk only, the pool
+survives losing a disk but the machine
+cannot boot without the first one.`,
+		stepNum: 8,
 	},
 	stateSSH: {
 		title: "SSH Server",
@@ -322,7 +362,7 @@ so we can fetch your public SSH keys.
 Recommended for servers and headless
 machines. You can change this later in
 your NixOS configuration.`,
-		stepNum: 13,
+		stepNum: 14,
 	},
 	stateGitHubUser: {
 		title: "GitHub Username",
@@ -340,7 +380,7 @@ GitHub SSH keys.
 Password authentication will be disabled,
 so key-based access is the only way to
 log in remotely.`,
-		stepNum: 14,
+		stepNum: 15,
 	},
 	stateSummary: {
 		title: "Review Configuration",
@@ -356,7 +396,25 @@ After confirmation, the installer will:
 This process takes 10-30 minutes
 depending on your hardware and
 internet connection speed.`,
-		stepNum: 15,
+		stepNum: 16,
+	},
+	stateExport: {
+		title: "Export Answer File",
+		description: `Save this configuration as an answer
+file so identical machines can be
+installed unattended with:
+
+  sudo installer --config <file>
+
+Your password is stored only as a
+SHA-512 hash. The ZFS encryption
+passphrase is NOT saved and will be
+asked for again when the file is used.
+
+A mounted USB stick is suggested when
+one is found, otherwise /tmp on the
+live system.`,
+		stepNum: 16,
 	},
 	stateConfirm: {
 		title: "Final Confirmation",
@@ -367,41 +425,42 @@ all data on the selected disk(s).
 
 This action cannot be undone.
 
+Everything found on the disk(s) is
+listed: partitions, filesystems, ZFS
+pools, LUKS, LVM and mdraid, with the
+system it probably belongs to.
+
 To proceed, type DESTROY exactly.
+Multi-disk pools then ask for the last
+4 characters of each disk's serial
+number, printed on its label.
 To cancel, press Ctrl+C or q.`,
-		stepNum: 16,
+		stepNum: 17,
 	},
 }
 
-const totalSteps = 16
-
-// Config holds all installation configuration
-type Config struct {
-	Username      string
-	Fullname      string
-	Email         string
-	Password      string
-	Hostname      string
-	Disk          string   // Primary disk (single-disk modes, or boot disk for multi-disk)
-	Disks         []string // All selected disks (multi-disk modes)
-	HostID        string
-	Passphrase    string
-	StorageMode   storageMode
-	Locale        string
-	Keymap        string
-	ConsoleKeyMap string
-	EnableSSH     bool
-	GitHubUser    string
-	SSHKeys       []string
-	SpaceBoot     string
-	SpaceNix      string
-	SpaceHome     string
-	SpaceAtuin    string
-	ZFSPoolName   string
-	ProjectRoot   string
-	WorkDir       string
+// confirmAlongsideStep replaces the confirmation step's text when the
+// install goes into free space and destroys nothing
+var confirmAlongsideStep = stepInfo{
+	title: "Final Confirmation",
+	description: `Point of no return!
+
+tuinix will be installed into free
+space next to the other operating
+system. The partitions listed are
+created or left alone exactly as
+shown; nothing else on the disk is
+touched.
+
+Back up anything important first.
+
+To proceed, type INSTALL exactly.
+To cancel, press Ctrl+C or q.`,
+	stepNum: 17,
 }
 
+const totalSteps = 17
+
 // Particle for fire effect
 type fireParticle struct {
 	x, y    float64
@@ -424,34 +483,27 @@ type physicsChar struct {
 	color       lipgloss.Color
 }
 
-type diskInfo struct {
-	Path  string
-	Size  string
-	Model string
-}
-
-type keymapEntry struct {
-	Label      string // Display label (e.g. "us", "pt")
-	XKBLayout  string // X11/Wayland layout (e.g. "us", "pt")
-	ConsoleMap string // Linux console keymap (e.g. "us", "pt-latin1")
-}
-
 // Model is the main application model
 type model struct {
 	state        installState
 	nextState    installState
 	prevState    installState // For detecting state transitions
-	config       Config
+	config       config.Config
 	width        int
 	height       int
 	input        textinput.Model
 	viewport     viewport.Model
 	err          error
-	disks        []diskInfo
+	disks        []disk.Info
+	hiddenDisks  []disk.Info  // Disks left out of the list; see disk.Info.Hidden
+	layout       *disk.Layout // Partitions and free space of the chosen disk (nil: whole disk only)
 	selectedIdx  int
 	diskSelected []bool // For multi-disk selection (toggle with space)
 	locales      []string
-	keymaps      []keymapEntry
+	keymaps      []config.Keymap
+	answers      *config.Answers // Pre-filled answers for unattended installs (nil if none)
+	answerSource string          // Where a discovered answer file came from (empty if none)
+	answerErr    error           // Why a discovered answer file could not be used
 
 	// Animation state
 	fireParticles []fireParticle
@@ -472,19 +524,41 @@ type model struct {
 	// Network check
 	networkOk bool
 
+	// Status line shown on the summary screen (e.g. after exporting answers)
+	notice string
+
+	// Pre-flight problems of the selected disks, by path, and the
+	// selection they are for
+	diskChecks   map[string][]diskCheck
+	checkedDisks string
+	checksSeen   bool // Its warnings have been shown once
+	acceptChecks bool // Accept warnings without a second Enter (disks from the answer file)
+
+	// What the confirmation screen shows will be erased, and how many of
+	// its confirmSteps have been typed
+	destroyPreview []diskPreview
+	confirmStep    int
+
+	// A failed installation that can be resumed (nil if none)
+	resume *pipeline.Progress
+
 	// Installation progress
-	installLog  []string
-	installStep int
-	installErr  error
-	logTail     []string // Last 3 lines from install log for live display
+	installStep        int
+	installStepName    string // Step now running
+	installDestructive bool   // Whether that step is writing the target disks
+	installErr         error
+	installEvents      chan pipeline.Event // Progress events from the install pipeline
+	logTail            []string            // Last 3 lines from install log for live display
+
+	// Stopping a running installation
+	stopInstall context.CancelCauseFunc // Cancels the installation's context
+	confirmStop bool                    // Stop confirmation dialog is open
+	stopping    bool                    // Stop confirmed; waiting for cleanup
 }
 
 // Messages
 type tickMsg time.Time
-type installStepMsg struct {
-	step int
-	msg  string
-}
+type installEventMsg pipeline.Event
 type installDoneMsg struct{}
 type installErrMsg struct {
 	err error
@@ -493,6 +567,5 @@ type networkCheckMsg struct {
 	ok bool
 }
 type logTailMsg struct {
-	lines       []string
-	currentStep int
+	lines []string
 }
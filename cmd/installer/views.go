@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/timlinux/tuinix/cmd/installer/pipeline"
 )
 
 func (m model) viewWizard() string {
@@ -12,6 +15,9 @@ func (m model) viewWizard() string {
 	if !ok {
 		return m.viewSplash()
 	}
+	if m.state == stateConfirm && m.config.Alongside != nil {
+		step = confirmAlongsideStep
+	}
 
 	// Header
 	header := m.renderHeader()
@@ -189,10 +195,14 @@ func (m model) viewSplash() string {
 		Width(m.width - 4).
 		Render("Installer v1.0")
 
+	hintText := "Starting installation wizard..."
+	if m.answerSource != "" && m.answerErr == nil {
+		hintText = "Starting installation from answer file: " + m.answerSource
+	}
 	hint := grayStyle.Copy().
 		Align(lipgloss.Center).
 		Width(m.width - 4).
-		Render("Starting installation wizard...")
+		Render(hintText)
 
 	footer := m.renderFooter()
 
@@ -310,7 +320,10 @@ func (m model) viewInstalling() string {
 	progress := detailStyle.Copy().
 		Align(lipgloss.Center).
 		Width(m.width - 4).
-		Render("This may take 10-30 minutes...")
+		Render("This may take 10-30 minutes... (Ctrl+C to stop)")
+	if m.confirmStop || m.stopping {
+		progress = m.renderStopDialog()
+	}
 
 	footer := m.renderFooter()
 
@@ -398,6 +411,13 @@ func (m model) viewError() string {
 		Align(lipgloss.Center).
 		Width(m.width - 4).
 		Render("Installation Failed")
+	if errors.Is(m.installErr, errStoppedByUser) {
+		title = errorStyle.Copy().
+			Bold(true).
+			Align(lipgloss.Center).
+			Width(m.width - 4).
+			Render("Installation Stopped")
+	}
 
 	errMsg := ""
 	if m.installErr != nil {
@@ -405,14 +425,41 @@ func (m model) viewError() string {
 	}
 
 	errInfoStyle := lipgloss.NewStyle().Foreground(colorOffWhite)
-	info := lipgloss.JoinVertical(lipgloss.Left,
+	lines := []string{
 		errInfoStyle.Render("An error occurred during installation:"),
 		"",
 		errorStyle.Render(errMsg),
-		"",
-		errInfoStyle.Bold(true).Render("Please check the error message above."),
-		grayStyle.Render("You may need to reboot and try again."),
-	)
+	}
+
+	// What the rollback undid, so nobody has to guess whether the pool is
+	// still imported or /mnt still mounted
+	var ierr *pipeline.InstallError
+	if errors.As(m.installErr, &ierr) && len(ierr.Cleanup) > 0 {
+		lines = append(lines, "", errInfoStyle.Render("Cleanup:"))
+		for _, r := range ierr.Cleanup {
+			if r.Err != nil {
+				lines = append(lines, errorStyle.Render("  [failed] "+r.Action+": "+r.Err.Error()))
+			} else {
+				lines = append(lines, successStyle.Render("  [done] "+r.Action))
+			}
+		}
+	}
+
+	lines = append(lines, "")
+	if m.resume != nil {
+		// The disks are formatted: running the installer again picks up
+		// from the failed step instead of wiping them
+		lines = append(lines,
+			errInfoStyle.Bold(true).Render("Your progress has been saved."),
+			grayStyle.Render(fmt.Sprintf("Fix the problem, then run sudo installer again (without rebooting)\nto resume from \"%s\".", m.resume.NextStep())),
+		)
+	} else {
+		lines = append(lines,
+			errInfoStyle.Bold(true).Render("Please check the error message above."),
+			grayStyle.Render("You may need to reboot and try again."),
+		)
+	}
+	info := lipgloss.JoinVertical(lipgloss.Left, lines...)
 
 	exitHint := grayStyle.Copy().
 		Align(lipgloss.Center).
@@ -437,6 +484,79 @@ func (m model) viewError() string {
 	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, content)
 }
 
+func (m model) viewResume() string {
+	header := m.renderHeader()
+	line := m.renderHorizontalLine()
+
+	title := titleStyle.Copy().
+		Align(lipgloss.Center).
+		Width(m.width - 4).
+		Render("Resume Installation")
+
+	p := m.resume
+	infoStyle := lipgloss.NewStyle().Foreground(colorOffWhite)
+	info := lipgloss.JoinVertical(lipgloss.Left,
+		infoStyle.Render("A previous installation did not finish:"),
+		"",
+		detailStyle.Render(fmt.Sprintf("  Host:      %s (%s)", p.Config.Hostname, p.Config.Username)),
+		detailStyle.Render(fmt.Sprintf("  Storage:   %s", p.Config.StorageMode)),
+		detailStyle.Render(fmt.Sprintf("  Disk(s):   %s", strings.Join(p.Config.Disks, ", "))),
+		detailStyle.Render(fmt.Sprintf("  Completed: %d of %d steps", len(p.Completed), len(pipeline.Steps(p.Config)))),
+		detailStyle.Render(fmt.Sprintf("  Next step: %s", p.NextStep())),
+		"",
+		grayStyle.Render("Resuming unlocks the disks again, remounts /mnt and"),
+		grayStyle.Render("continues without formatting the disks again."),
+	)
+
+	var choice string
+	if m.state == stateResumePassphrase {
+		inputBox := lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder()).
+			BorderForeground(colorNixBlue).
+			Padding(0, 1).
+			Render(m.input.View())
+		var errText string
+		if m.err != nil {
+			errText = "\n" + errorStyle.Render("! "+m.err.Error())
+		}
+		choice = infoStyle.Render(strings.TrimSuffix(unlockPrompt(p.Config), " ")) + "\n" + inputBox + errText +
+			grayStyle.Render("\nEnter to unlock and resume | Ctrl+C to quit")
+	} else {
+		options := []string{
+			"Resume from \"" + p.NextStep() + "\"",
+			"Start a new installation (formats the disks again)",
+		}
+		var optList strings.Builder
+		for i, opt := range options {
+			cursor := "  "
+			style := lipgloss.NewStyle().Foreground(colorOffWhite)
+			if i == m.selectedIdx {
+				cursor = "> "
+				style = style.Foreground(colorOrange).Bold(true)
+			}
+			optList.WriteString(style.Render(cursor+opt) + "\n")
+		}
+		choice = optList.String() + grayStyle.Render("\nUp/Down to select | Enter to confirm | q to quit")
+	}
+
+	footer := m.renderFooter()
+
+	content := lipgloss.JoinVertical(lipgloss.Center,
+		header,
+		line,
+		"",
+		title,
+		"",
+		info,
+		"",
+		choice,
+		"",
+		footer,
+	)
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, content)
+}
+
 func (m model) viewNetworkCheck() string {
 	header := m.renderHeader()
 	line := m.renderHorizontalLine()
@@ -468,6 +588,14 @@ func (m model) viewNetworkCheck() string {
 		}
 	}
 
+	var answerStatus string
+	if m.answerSource != "" {
+		answerStatus = detailStyle.Render("Answer file: " + m.answerSource)
+		if m.answerErr != nil {
+			answerStatus += "\n" + warningStyle.Render("! Not used: "+m.answerErr.Error())
+		}
+	}
+
 	footer := m.renderFooter()
 
 	content := lipgloss.JoinVertical(lipgloss.Center,
@@ -478,6 +606,8 @@ func (m model) viewNetworkCheck() string {
 		"",
 		status,
 		"",
+		answerStatus,
+		"",
 		footer,
 	)
 
@@ -112,6 +112,8 @@ in {
       e2fsprogs
       dosfstools
       xfsprogs
+      cryptsetup
+      btrfs-progs
       disko
       gum
       catimg
@@ -119,6 +121,8 @@ in {
       nixos-install-tools
       mkpasswd
       util-linux
+      # Disk health for the installer's pre-flight checks
+      smartmontools
       # iPhone USB tethering support
       libimobiledevice
       ifuse
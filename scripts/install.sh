@@ -14,7 +14,7 @@ LOCALE="en_US.UTF-8"
 KEYMAP="us"
 SPACE_BOOT="5G"
 SPACE_NIX="250G"
-SPACE_HOME=""
+SPACE_HOME="none"
 SPACE_ATUIN="50G"
 ZFS_POOL_NAME="NIXROOT"
 USERNAME=""
@@ -96,6 +96,32 @@ generate_host_id() {
   printf "%08x" $((RANDOM * RANDOM))
 }
 
+# Name of a disk that survives reboots and other disks coming and going,
+# as the Go installer picks it: the /dev/disk/by-id link naming its model
+# and serial number, else its WWN or EUI link, else its by-path link, else
+# the kernel name
+stable_disk_path() {
+  local disk="$1" link best="" fallback="" by_path=""
+  for link in $(udevadm info --query=symlink --name="$disk" 2>/dev/null | tr ' ' '\n' | sort); do
+    link="/dev/$link"
+    case "$link" in
+    /dev/disk/by-id/wwn-* | /dev/disk/by-id/nvme-eui.* | /dev/disk/by-id/nvme-nvme.*)
+      [[ -z $fallback ]] && fallback="$link"
+      ;;
+    /dev/disk/by-id/*)
+      # NVMe namespaces get a second link ending in _1; keep the shorter
+      if [[ -z $best || ${#link} -lt ${#best} ]]; then
+        best="$link"
+      fi
+      ;;
+    /dev/disk/by-path/*)
+      [[ -z $by_path ]] && by_path="$link"
+      ;;
+    esac
+  done
+  echo "${best:-${fallback:-${by_path:-$disk}}}"
+}
+
 # Interpolate template variables
 interpolate_template() {
   local template_file="$1"
@@ -103,11 +129,12 @@ interpolate_template() {
 
   # Read template and substitute variables
   sed \
-    -e "s|{{DISK_DEVICE}}|$DISK|g" \
+    -e "s|{{DISK_DEVICE}}|$(stable_disk_path "$DISK")|g" \
     -e "s|{{HOSTNAME}}|$HOSTNAME|g" \
     -e "s|{{SPACE_BOOT}}|$SPACE_BOOT|g" \
     -e "s|{{SPACE_NIX}}|$SPACE_NIX|g" \
     -e "s|{{SPACE_ATUIN}}|$SPACE_ATUIN|g" \
+    -e "s|{{SPACE_HOME}}|${SPACE_HOME:-none}|g" \
     -e "s|{{ZFS_POOL_NAME}}|$ZFS_POOL_NAME|g" \
     "$template_file" >"$output_file"
 }
@@ -205,14 +232,11 @@ collect_user_input() {
     atuin_gb=1 # Minimum 1GB for atuin
   fi
 
-  # Home gets the rest
-  local home_gb=$((disk_size_gb - boot_gb - nix_gb - atuin_gb))
-
   # Set global variables
   SPACE_BOOT="${boot_gb}G"
   SPACE_NIX="${nix_gb}G"
   SPACE_ATUIN="${atuin_gb}G"
-  SPACE_HOME="${home_gb}G"
+  SPACE_HOME="none" # Home shares the rest of the pool, without a quota
 
   gum style --foreground="#654321" \
     "Automatic space allocation:" \
@@ -289,7 +313,7 @@ show_summary() {
     "Space allocation:" \
     "  /boot: $SPACE_BOOT (EFI)" \
     "  /nix: $SPACE_NIX" \
-    "  /home: $SPACE_HOME" \
+    "  /home: ${SPACE_HOME/#none/no quota}" \
     "  /var/atuin: $SPACE_ATUIN (XFS on ZFS volume)" \
     "" \
     "🔥 THIS WILL DESTROY ALL DATA ON $DISK"
@@ -0,0 +1,52 @@
+# Disko configuration template for tuinix - LUKS2-encrypted XFS or ext4
+# (for machines without ZFS, such as aarch64)
+# Variables to be interpolated:
+# - {{DISK_DEVICE}} - Target disk, by its stable link where it has one
+#   (e.g., /dev/disk/by-id/nvme-Samsung_SSD_980_1TB_S64ANS0T123456, /dev/vda)
+# - {{SPACE_BOOT}} - Boot partition size (default: 5G)
+# - {{ROOT_FORMAT}} - Root filesystem: xfs or ext4
+#
+# The passphrase is read from stdin (twice) when the container is created.
+# The initrd unlock is configured in hardware.nix.
+
+{ lib, ... }:
+let disk = "{{DISK_DEVICE}}";
+in {
+  disko.devices = {
+    disk = {
+      main = {
+        type = "disk";
+        device = disk;
+        content = {
+          type = "gpt";
+          partitions = {
+            ESP = {
+              type = "EF00";
+              size = "{{SPACE_BOOT}}";
+              content = {
+                type = "filesystem";
+                format = "vfat";
+                mountpoint = "/boot";
+                mountOptions = [ "umask=0077" ];
+              };
+            };
+            luks = {
+              size = "100%";
+              content = {
+                type = "luks";
+                name = "cryptroot";
+                extraFormatArgs = [ "--type" "luks2" ];
+                settings = { allowDiscards = true; };
+                content = {
+                  type = "filesystem";
+                  format = "{{ROOT_FORMAT}}";
+                  mountpoint = "/";
+                };
+              };
+            };
+          };
+        };
+      };
+    };
+  };
+}
@@ -1,10 +1,15 @@
 # Disko configuration template for tuinix
+# Used by scripts/install.sh. The Go installer generates ZFS layouts from
+# its dataset model instead, so that added datasets and properties apply
+# to single-disk and multi-disk pools alike.
 # Variables to be interpolated:
-# - {{DISK_DEVICE}} - Target disk device (e.g., /dev/sda, /dev/nvme0n1, /dev/vda)
+# - {{DISK_DEVICE}} - Target disk, by its stable link where it has one
+#   (e.g., /dev/disk/by-id/nvme-Samsung_SSD_980_1TB_S64ANS0T123456, /dev/vda)
 # - {{HOSTNAME}} - System hostname
 # - {{SPACE_BOOT}} - Boot partition size (default: 5G)
 # - {{SPACE_NIX}} - /nix partition quota
 # - {{SPACE_ATUIN}} - /var/atuin volume size
+# - {{SPACE_HOME}} - /home dataset quota ("none" for no quota)
 # - {{ZFS_POOL_NAME}} - ZFS pool name (default: NIXROOT)
 
 { lib, ... }:
@@ -84,7 +89,10 @@ in {
           "home" = {
             type = "zfs_fs";
             mountpoint = "/home";
-            options = { "com.sun:auto-snapshot" = "true"; };
+            options = {
+              "com.sun:auto-snapshot" = "true";
+              quota = "{{SPACE_HOME}}";
+            };
           };
 
           "overflow" = {
@@ -1,6 +1,7 @@
 # Disko configuration template for tuinix - XFS unencrypted (maximum performance)
 # Variables to be interpolated:
-# - {{DISK_DEVICE}} - Target disk device (e.g., /dev/sda, /dev/nvme0n1, /dev/vda)
+# - {{DISK_DEVICE}} - Target disk, by its stable link where it has one
+#   (e.g., /dev/disk/by-id/nvme-Samsung_SSD_980_1TB_S64ANS0T123456, /dev/vda)
 # - {{SPACE_BOOT}} - Boot partition size (default: 5G)
 
 { lib, ... }: